@@ -1,14 +1,30 @@
 package main
 
 import (
+	"bufio"
+	"encoding/hex"
+	"encoding/json"
 	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"runtime/debug"
 	"strconv"
+	"strings"
+	"time"
 
 	"github.com/urfave/cli/v2"
-	deserializer "github.com/worldcoin/ptau-deserializer/deserialize"
+	"github.com/worldcoin/semaphore-mtb-setup/attestation"
+	"github.com/worldcoin/semaphore-mtb-setup/audit"
+	"github.com/worldcoin/semaphore-mtb-setup/ceremony"
+	"github.com/worldcoin/semaphore-mtb-setup/common"
+	"github.com/worldcoin/semaphore-mtb-setup/coordinator"
 	"github.com/worldcoin/semaphore-mtb-setup/keys"
 	"github.com/worldcoin/semaphore-mtb-setup/phase1"
 	"github.com/worldcoin/semaphore-mtb-setup/phase2"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/term"
 )
 
 func p1t(cCtx *cli.Context) error {
@@ -35,6 +51,25 @@ func p1t(cCtx *cli.Context) error {
 	return err
 }
 
+func p1r(cCtx *cli.Context) error {
+	// sanity check
+	if cCtx.Args().Len() != 3 {
+		return errors.New("please provide the correct arguments")
+	}
+	inputPath := cCtx.Args().Get(0)
+	outputPath := cCtx.Args().Get(1)
+	powerStr := cCtx.Args().Get(2)
+	power, err := strconv.Atoi(powerStr)
+	if err != nil {
+		return err
+	}
+	if power < 1 || power > 255 {
+		return errors.New("invalid power")
+	}
+	err = phase1.Truncate(inputPath, outputPath, byte(power))
+	return err
+}
+
 func p1n(cCtx *cli.Context) error {
 	// sanity check
 	if cCtx.Args().Len() != 2 {
@@ -45,8 +80,8 @@ func p1n(cCtx *cli.Context) error {
 	if err != nil {
 		return err
 	}
-	if power > 26 {
-		return errors.New("can't support powers larger than 26")
+	if power < 1 || power > 26 {
+		return errors.New("power must be between 1 and 26")
 	}
 	outputPath := cCtx.Args().Get(1)
 	err = phase1.Initialize(byte(power), outputPath)
@@ -60,34 +95,89 @@ func p1c(cCtx *cli.Context) error {
 	}
 	inputPath := cCtx.Args().Get(0)
 	outputPath := cCtx.Args().Get(1)
-	err := phase1.Contribute(inputPath, outputPath)
+	if cCtx.Bool("verify") {
+		if err := phase1.Verify(inputPath, cCtx.String("origin")); err != nil {
+			return err
+		}
+	}
+	rand, err := contributionRand(cCtx)
+	if err != nil {
+		return err
+	}
+	err = phase1.ContributeWithRand(inputPath, outputPath, rand)
 	return err
 }
 
 func p1i(cCtx *cli.Context) error {
+	// sanity check
+	if cCtx.Args().Len() != 2 {
+		return errors.New("please provide the correct arguments")
+	}
 	ptauFilePath := cCtx.Args().Get(0)
 	outputFilePath := cCtx.Args().Get(1)
 
-	ptau, err := deserializer.ReadPtau(ptauFilePath)
+	ptauFile, err := os.Open(ptauFilePath)
+	if err != nil {
+		return err
+	}
+	defer ptauFile.Close()
 
+	outputFile, err := os.Create(outputFilePath)
 	if err != nil {
 		return err
 	}
+	defer outputFile.Close()
+
+	// Use buffered IO to write parameters efficiently
+	writer := bufio.NewWriterSize(outputFile, 1<<20)
+	if err := phase1.ImportPtau(ptauFile, writer); err != nil {
+		return err
+	}
+	return writer.Flush()
+}
 
-	phase1, err := deserializer.ConvertPtauToPhase1(ptau)
+func p1e(cCtx *cli.Context) error {
+	// sanity check
+	if cCtx.Args().Len() != 2 {
+		return errors.New("please provide the correct arguments")
+	}
+	inputPath := cCtx.Args().Get(0)
+	ptauFilePath := cCtx.Args().Get(1)
 
+	inputFile, err := os.Open(inputPath)
 	if err != nil {
 		return err
 	}
+	defer inputFile.Close()
 
-	// Write phase1 to file
-	err = deserializer.WritePhase1(phase1, uint8(ptau.Header.Power), outputFilePath)
+	ptauFile, err := os.Create(ptauFilePath)
+	if err != nil {
+		return err
+	}
+	defer ptauFile.Close()
 
+	// Use buffered IO to write parameters efficiently
+	writer := bufio.NewWriterSize(ptauFile, 1<<20)
+	if err := phase1.ExportPtau(inputFile, writer); err != nil {
+		return err
+	}
+	return writer.Flush()
+}
+
+func p1vp(cCtx *cli.Context) error {
+	// sanity check
+	if cCtx.Args().Len() != 1 {
+		return errors.New("please provide the correct arguments")
+	}
+	ptauFilePath := cCtx.Args().Get(0)
+
+	ptauFile, err := os.Open(ptauFilePath)
 	if err != nil {
 		return err
 	}
+	defer ptauFile.Close()
 
-	return nil
+	return phase1.VerifyPtau(ptauFile)
 }
 
 func p1v(cCtx *cli.Context) error {
@@ -112,6 +202,25 @@ func p1vt(cCtx *cli.Context) error {
 }
 
 func p2n(cCtx *cli.Context) error {
+	if cCtx.IsSet("check") {
+		// sanity check
+		if cCtx.Args().Len() != 2 {
+			return errors.New("please provide the correct arguments")
+		}
+		phase1Path := cCtx.Args().Get(0)
+		r1csPath := cCtx.Args().Get(1)
+		phase2Path := cCtx.String("check")
+		lagPath, err := publishedPath(cCtx, "lag", phase2Path)
+		if err != nil {
+			return err
+		}
+		evalsPath, err := publishedPath(cCtx, "evals", phase2Path)
+		if err != nil {
+			return err
+		}
+		err = phase2.CheckInitialization(phase1Path, r1csPath, phase2Path, lagPath, evalsPath)
+		return err
+	}
 	// sanity check
 	if cCtx.Args().Len() != 3 {
 		return errors.New("please provide the correct arguments")
@@ -120,42 +229,493 @@ func p2n(cCtx *cli.Context) error {
 	phase1Path := cCtx.Args().Get(0)
 	r1csPath := cCtx.Args().Get(1)
 	phase2Path := cCtx.Args().Get(2)
-	err := phase2.Initialize(phase1Path, r1csPath, phase2Path)
+	paths, err := artifactPaths(cCtx, "lag", "evals")
+	if err != nil {
+		return err
+	}
+	err = phase2.Initialize(phase1Path, r1csPath, phase2Path, paths[0], paths[1])
 	return err
 }
 
+// Default names of the artifacts of a circuit, by flag
+var artifactNames = map[string]string{
+	"lag":   "srs.lag",
+	"evals": "evals",
+	"pk":    "pk",
+	"vk":    "vk",
+}
+
+// artifactPaths returns the path of each artifact, given by its own flag or named after the flag in the
+// artifact directory, which is created if needed
+func artifactPaths(cCtx *cli.Context, flags ...string) ([]string, error) {
+	dir := cCtx.String("dir")
+	if dir != "" {
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			return nil, err
+		}
+	}
+	paths := make([]string, len(flags))
+	for i, flag := range flags {
+		if cCtx.IsSet(flag) {
+			paths[i] = cCtx.String(flag)
+		} else {
+			paths[i] = filepath.Join(dir, artifactNames[flag])
+		}
+	}
+	return paths, nil
+}
+
+// publishedPath returns the path of a file published along with the initial phase 2 file, which defaults to
+// the file of the same directory. A missing file is an error, unless --skip-missing allows an empty path to skip it
+func publishedPath(cCtx *cli.Context, flag, phase2Path string) (string, error) {
+	if cCtx.IsSet(flag) {
+		return cCtx.String(flag), nil
+	}
+	path := filepath.Join(filepath.Dir(phase2Path), artifactNames[flag])
+	if _, err := os.Stat(path); err != nil {
+		if !cCtx.Bool("skip-missing") {
+			return "", fmt.Errorf("%s not found, please provide it with --%s or skip its comparison with --skip-missing", path, flag)
+		}
+		fmt.Printf("%s not found, skipping its comparison\n", path)
+		return "", nil
+	}
+	return path, nil
+}
+
 func p2c(cCtx *cli.Context) error {
+	if cCtx.Bool("multi") {
+		return p2cMulti(cCtx)
+	}
+	// sanity check
+	if cCtx.Args().Len() != 2 || cCtx.IsSet("receipt") {
+		return errors.New("please provide the correct arguments")
+	}
+	inputPath := cCtx.Args().Get(0)
+	outputPath := cCtx.Args().Get(1)
+	if inputPath == "-" || outputPath == "-" {
+		return p2cStream(cCtx, inputPath, outputPath)
+	}
+	// The key is checked before contributing rather than after a long computation
+	signer, err := loadSigner(cCtx)
+	if err != nil {
+		return err
+	}
+	rand, err := contributionRand(cCtx)
+	if err != nil {
+		return err
+	}
+	err = phase2.ContributeWithRand(inputPath, outputPath, rand, contributionMetadata(cCtx))
+	if err != nil {
+		return err
+	}
+	if signer == nil {
+		return nil
+	}
+	return signContribution(inputPath, outputPath, signer)
+}
+
+// p2cStream contributes with "-" standing for stdin as input and stdout as output
+func p2cStream(cCtx *cli.Context, inputPath, outputPath string) error {
+	if cCtx.IsSet("sign-key") {
+		return errors.New("--sign-key needs both files on disk")
+	}
+	if entropy := cCtx.String("entropy"); inputPath == "-" && (entropy == "-" || entropy == "prompt") {
+		return errors.New("the entropy can't be read from stdin along with the input")
+	}
+	rand, err := contributionRand(cCtx)
+	if err != nil {
+		return err
+	}
+
+	var input io.Reader = os.Stdin
+	if inputPath != "-" {
+		inputFile, err := os.Open(inputPath)
+		if err != nil {
+			return err
+		}
+		defer inputFile.Close()
+		input = inputFile
+	}
+	var output io.Writer = os.Stdout
+	if outputPath != "-" {
+		outputFile, err := os.Create(outputPath)
+		if err != nil {
+			return err
+		}
+		defer outputFile.Close()
+		output = outputFile
+	}
+	return phase2.ContributeStream(input, output, rand, contributionMetadata(cCtx))
+}
+
+// p2cMulti contributes to every <inputPath>:<outputPath> pair given as argument
+func p2cMulti(cCtx *cli.Context) error {
+	// sanity check
+	if cCtx.Args().Len() == 0 {
+		return errors.New("please provide the correct arguments")
+	}
+	files := make([]phase2.ContributionFiles, cCtx.Args().Len())
+	for i, arg := range cCtx.Args().Slice() {
+		in, out, ok := strings.Cut(arg, ":")
+		if !ok || in == "" || out == "" {
+			return fmt.Errorf("%s isn't of the form <inputPath>:<outputPath>", arg)
+		}
+		files[i] = phase2.ContributionFiles{Input: in, Output: out}
+	}
+	signer, err := loadSigner(cCtx)
+	if err != nil {
+		return err
+	}
+	rand, err := contributionRand(cCtx)
+	if err != nil {
+		return err
+	}
+	receipt, multiErr := phase2.ContributeMulti(files, rand, contributionMetadata(cCtx))
+	if receipt == nil {
+		return multiErr
+	}
+	// The contributions that succeeded are kept and listed even if others failed
+	if multiErr == nil {
+		fmt.Printf("Contributions have been successful!\n%s", receipt)
+	} else if len(receipt.Contributions) != 0 {
+		fmt.Printf("Contributions to the following files have been successful:\n%s", receipt)
+	}
+	if cCtx.IsSet("receipt") && len(receipt.Contributions) != 0 {
+		b, err := json.MarshalIndent(receipt, "", "  ")
+		if err != nil {
+			return err
+		}
+		if err := os.WriteFile(cCtx.String("receipt"), append(b, '\n'), 0644); err != nil {
+			return err
+		}
+		fmt.Printf("Receipt written to %s\n", cCtx.String("receipt"))
+	}
+	if signer != nil {
+		for _, c := range receipt.Contributions {
+			if err := signContribution(c.Input, c.Output, signer); err != nil {
+				return err
+			}
+		}
+	}
+	return multiErr
+}
+
+// loadSigner returns the signer of the key given with --sign-key, if any,
+// prompting for its passphrase if the key is encrypted
+func loadSigner(cCtx *cli.Context) (ssh.Signer, error) {
+	if !cCtx.IsSet("sign-key") {
+		return nil, nil
+	}
+	return attestation.LoadSigner(cCtx.String("sign-key"), readPassphrase)
+}
+
+// readPassphrase prompts for the passphrase of an encrypted key without echoing it
+func readPassphrase() ([]byte, error) {
+	fd := int(os.Stdin.Fd())
+	if !term.IsTerminal(fd) {
+		return nil, errors.New("the key is encrypted and its passphrase can only be typed in a terminal")
+	}
+	fmt.Fprint(os.Stderr, "Enter the passphrase of the key: ")
+	passphrase, err := term.ReadPassword(fd)
+	fmt.Fprintln(os.Stderr)
+	return passphrase, err
+}
+
+// signContribution writes the attestation of the contribution next to the output
+func signContribution(inputPath, outputPath string, signer ssh.Signer) error {
+	a, err := attestation.Sign(inputPath, outputPath, signer)
+	if err != nil {
+		return err
+	}
+	sigPath := outputPath + attestation.Extension
+	if err := a.Write(sigPath); err != nil {
+		return err
+	}
+	fmt.Printf("Attestation of contribution %d written to %s\n", a.Contribution, sigPath)
+	return nil
+}
+
+func p2attest(cCtx *cli.Context) error {
 	// sanity check
 	if cCtx.Args().Len() != 2 {
 		return errors.New("please provide the correct arguments")
 	}
 	inputPath := cCtx.Args().Get(0)
 	outputPath := cCtx.Args().Get(1)
-	err := phase2.Contribute(inputPath, outputPath)
+	signer, err := loadSigner(cCtx)
+	if err != nil {
+		return err
+	}
+	return signContribution(inputPath, outputPath, signer)
+}
+
+func p2attestVerify(cCtx *cli.Context) error {
+	// sanity check
+	if cCtx.Args().Len() != 3 {
+		return errors.New("please provide the correct arguments")
+	}
+	phase2Path := cCtx.Args().Get(0)
+	sigDir := cCtx.Args().Get(1)
+	knownKeysPath := cCtx.Args().Get(2)
+	err := attestation.Verify(phase2Path, sigDir, knownKeysPath)
 	return err
 }
 
-func p2v(cCtx *cli.Context) error {
+func p2b(cCtx *cli.Context) error {
 	// sanity check
-	if cCtx.Args().Len() != 2 {
+	if cCtx.Args().Len() != 4 {
+		return errors.New("please provide the correct arguments")
+	}
+	inputPath := cCtx.Args().Get(0)
+	outputPath := cCtx.Args().Get(1)
+	beacon, err := hex.DecodeString(cCtx.Args().Get(2))
+	if err != nil {
+		return err
+	}
+	iterationsStr := cCtx.Args().Get(3)
+	iterations, err := strconv.Atoi(iterationsStr)
+	if err != nil {
+		return err
+	}
+	err = phase2.Beacon(inputPath, outputPath, beacon, iterations)
+	return err
+}
+
+func p2v(cCtx *cli.Context) error {
+	checkpointPath := cCtx.String("checkpoint")
+	if cCtx.IsSet("from-checkpoint") {
+		// sanity check
+		if cCtx.Args().Len() != 1 {
+			return errors.New("please provide the correct arguments")
+		}
+		inputPath := cCtx.Args().Get(0)
+		err := phase2.VerifyFromCheckpoint(inputPath, cCtx.String("from-checkpoint"), checkpointPath)
+		return err
+	}
+	// sanity check, the origin can be omitted for bundles
+	if cCtx.Args().Len() != 1 && cCtx.Args().Len() != 2 {
 		return errors.New("please provide the correct arguments")
 	}
 	inputPath := cCtx.Args().Get(0)
 	originPath := cCtx.Args().Get(1)
+	if checkpointPath != "" {
+		err := phase2.VerifyWithCheckpoint(inputPath, originPath, checkpointPath)
+		return err
+	}
 	err := phase2.Verify(inputPath, originPath)
 	return err
 }
 
+func p2vi(cCtx *cli.Context) error {
+	// sanity check
+	if cCtx.Args().Len() != 2 {
+		return errors.New("please provide the correct arguments")
+	}
+	inputPath := cCtx.Args().Get(0)
+	prevPath := cCtx.Args().Get(1)
+	err := phase2.VerifyIncremental(inputPath, prevPath)
+	return err
+}
+
+func p2audit(cCtx *cli.Context) error {
+	// sanity check
+	if cCtx.Args().Len() != 1 {
+		return errors.New("please provide the correct arguments")
+	}
+	dir := cCtx.Args().Get(0)
+	report, err := audit.Run(dir, cCtx.String("origin"))
+	if err != nil {
+		return err
+	}
+	if err := report.WriteJSON(cCtx.String("json")); err != nil {
+		return err
+	}
+	if err := report.WriteMarkdown(cCtx.String("markdown")); err != nil {
+		return err
+	}
+	fmt.Printf("Reports have been written to %s and %s\n", cCtx.String("json"), cCtx.String("markdown"))
+	return report.Err()
+}
+
+func p2hashes(cCtx *cli.Context) error {
+	// sanity check
+	if cCtx.Args().Len() != 1 {
+		return errors.New("please provide the correct arguments")
+	}
+	inputPath := cCtx.Args().Get(0)
+	if cCtx.IsSet("expected") {
+		err := audit.CheckHashes(inputPath, cCtx.String("expected"))
+		return err
+	}
+	err := audit.PrintHashes(inputPath)
+	return err
+}
+
 func extract(cCtx *cli.Context) error {
 	// sanity check
 	if cCtx.Args().Len() != 1 {
 		return errors.New("please provide the correct arguments")
 	}
 	inputPath := cCtx.Args().Get(0)
-	err := keys.ExtractKeys(inputPath)
+	paths, err := artifactPaths(cCtx, "evals", "pk", "vk")
+	if err != nil {
+		return err
+	}
+	// Bundles hold their evaluations
+	isBundle, err := phase2.IsBundle(inputPath)
+	if err != nil {
+		return err
+	}
+	if isBundle && !cCtx.IsSet("evals") {
+		paths[0] = inputPath
+	}
+	err = keys.ExtractKeys(inputPath, paths[0], paths[1], paths[2])
+	return err
+}
+
+func p2bundle(cCtx *cli.Context) error {
+	// sanity check
+	if cCtx.Args().Len() != 4 {
+		return errors.New("please provide the correct arguments")
+	}
+	originPath := cCtx.Args().Get(0)
+	evalsPath := cCtx.Args().Get(1)
+	lagPath := cCtx.Args().Get(2)
+	bundlePath := cCtx.Args().Get(3)
+	err := phase2.CreateBundle(originPath, evalsPath, lagPath, cCtx.String("latest"), bundlePath)
+	return err
+}
+
+func p2split(cCtx *cli.Context) error {
+	// sanity check
+	if cCtx.Args().Len() != 2 {
+		return errors.New("please provide the correct arguments")
+	}
+	bundlePath := cCtx.Args().Get(0)
+	phase2Path := cCtx.Args().Get(1)
+	err := phase2.SplitBundle(bundlePath, phase2Path)
+	return err
+}
+
+func p2rebuild(cCtx *cli.Context) error {
+	// sanity check
+	if cCtx.Args().Len() != 3 {
+		return errors.New("please provide the correct arguments")
+	}
+	bundlePath := cCtx.Args().Get(0)
+	phase2Path := cCtx.Args().Get(1)
+	outputPath := cCtx.Args().Get(2)
+	err := phase2.RebuildBundle(bundlePath, phase2Path, outputPath)
 	return err
 }
 
+func coordinatorServe(cCtx *cli.Context) error {
+	// sanity check
+	if cCtx.Args().Len() != 0 {
+		return errors.New("please provide the correct arguments")
+	}
+	server, err := coordinator.NewServer(cCtx.String("dir"), cCtx.StringSlice("origin"), cCtx.String("admin-token"))
+	if err != nil {
+		return err
+	}
+	return server.ListenAndServe(cCtx.String("addr"))
+}
+
+func coordinatorRegister(cCtx *cli.Context) error {
+	// sanity check
+	if cCtx.Args().Len() != 1 {
+		return errors.New("please provide the correct arguments")
+	}
+	client := coordinator.NewClient(cCtx.String("url"), cCtx.String("admin-token"))
+	token, err := client.Register(cCtx.Args().Get(0))
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Token := %s\n", token)
+	return nil
+}
+
+func coordinatorSkip(cCtx *cli.Context) error {
+	// sanity check
+	if cCtx.Args().Len() != 0 {
+		return errors.New("please provide the correct arguments")
+	}
+	client := coordinator.NewClient(cCtx.String("url"), cCtx.String("admin-token"))
+	return client.Skip()
+}
+
+func contribute(cCtx *cli.Context) error {
+	// sanity check
+	if cCtx.Args().Len() != 0 {
+		return errors.New("please provide the correct arguments")
+	}
+	rand, err := contributionRand(cCtx)
+	if err != nil {
+		return err
+	}
+	dir := cCtx.String("dir")
+	if dir == "" {
+		dir = "."
+	}
+	client := coordinator.NewClient(cCtx.String("coordinator"), cCtx.String("token"))
+	receipts, err := client.Contribute(dir, rand, contributionMetadata(cCtx))
+	for _, r := range receipts {
+		fmt.Printf("Receipt of contribution %d to %s:\n", r.Contribution, r.Circuit)
+		fmt.Printf("\tHash := %s\n\tDigest := %s\n\tTimestamp := %s\n\tSignature := %s\n", r.Hash, r.Digest, r.Timestamp.Format(time.RFC3339), r.Signature)
+		fmt.Printf("\twritten to %s\n", filepath.Join(dir, r.File+coordinator.ReceiptExtension))
+	}
+	return err
+}
+
+// readManifest reads the manifest given as the only argument, restricted to the circuits of --circuit
+func readManifest(cCtx *cli.Context) (*ceremony.Manifest, error) {
+	// sanity check
+	if cCtx.Args().Len() != 1 {
+		return nil, errors.New("please provide the correct arguments")
+	}
+	m, err := ceremony.ReadManifest(cCtx.Args().Get(0))
+	if err != nil {
+		return nil, err
+	}
+	return m.Select(cCtx.StringSlice("circuit"))
+}
+
+func ceremonyInit(cCtx *cli.Context) error {
+	m, err := readManifest(cCtx)
+	if err != nil {
+		return err
+	}
+	return ceremony.Init(m)
+}
+
+func ceremonyContribute(cCtx *cli.Context) error {
+	m, err := readManifest(cCtx)
+	if err != nil {
+		return err
+	}
+	rand, err := contributionRand(cCtx)
+	if err != nil {
+		return err
+	}
+	return ceremony.Contribute(m, rand, contributionMetadata(cCtx))
+}
+
+func ceremonyVerify(cCtx *cli.Context) error {
+	m, err := readManifest(cCtx)
+	if err != nil {
+		return err
+	}
+	return ceremony.Verify(m)
+}
+
+func ceremonyKeys(cCtx *cli.Context) error {
+	m, err := readManifest(cCtx)
+	if err != nil {
+		return err
+	}
+	return ceremony.ExtractKeys(m)
+}
+
 func exportSol(cCtx *cli.Context) error {
 	// sanity check
 	if cCtx.Args().Len() != 1 {
@@ -165,3 +725,71 @@ func exportSol(cCtx *cli.Context) error {
 	err := keys.ExportSol(session)
 	return err
 }
+
+// contributionRand returns the source of randomness of a contribution, which is only
+// deterministic for test ceremonies run with --seed
+func contributionRand(cCtx *cli.Context) (io.Reader, error) {
+	if cCtx.IsSet("seed") {
+		if cCtx.IsSet("entropy") {
+			return nil, errors.New("--seed and --entropy can't be used together")
+		}
+		fmt.Fprintln(os.Stderr, "WARNING: contributing with a seed, the toxic parameters aren't secret")
+		return common.NewSeededReader([]byte(cCtx.String("seed")))
+	}
+	entropy, err := readEntropy(cCtx.String("entropy"))
+	if err != nil {
+		return nil, err
+	}
+	return common.NewEntropyReader(entropy)
+}
+
+// contributionMetadata returns the metadata of the contributor, which is only recorded with --name or --comment
+func contributionMetadata(cCtx *cli.Context) *phase2.Metadata {
+	if !cCtx.IsSet("name") && !cCtx.IsSet("comment") {
+		return nil
+	}
+	return &phase2.Metadata{
+		Name:        cCtx.String("name"),
+		Comment:     cCtx.String("comment"),
+		Timestamp:   time.Now().UTC(),
+		ToolVersion: toolVersion(),
+	}
+}
+
+// toolVersion returns the module version and VCS revision the binary was built from
+func toolVersion() string {
+	version := "semaphore-mtb-setup"
+	info, ok := debug.ReadBuildInfo()
+	if !ok {
+		return version
+	}
+	version += " " + info.Main.Version
+	for _, setting := range info.Settings {
+		if setting.Key == "vcs.revision" {
+			version += " " + setting.Value
+		}
+	}
+	return version
+}
+
+// readEntropy reads the entropy of a contributor from an interactive prompt, stdin ("-") or a file
+func readEntropy(source string) ([]byte, error) {
+	switch source {
+	case "":
+		return nil, nil
+	case "prompt":
+		// The entropy isn't echoed, so that it doesn't end up in scrollback or screen recordings
+		fd := int(os.Stdin.Fd())
+		if !term.IsTerminal(fd) {
+			return nil, errors.New("the entropy can only be typed in a terminal, use --entropy - to pipe it")
+		}
+		fmt.Fprint(os.Stderr, "Type some random text and press Enter (it isn't displayed): ")
+		line, err := term.ReadPassword(fd)
+		fmt.Fprintln(os.Stderr)
+		return line, err
+	case "-":
+		return io.ReadAll(os.Stdin)
+	default:
+		return os.ReadFile(source)
+	}
+}
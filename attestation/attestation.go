@@ -0,0 +1,253 @@
+package attestation
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/worldcoin/semaphore-mtb-setup/phase2"
+	"golang.org/x/crypto/ssh"
+)
+
+// Namespace of the SSH signatures, as given to ssh-keygen -Y verify -n
+const Namespace = "semaphore-mtb-setup"
+
+// Extension of detached signature files
+const Extension = ".sig"
+
+// Attestation is a detached signature of a phase 2 contribution
+type Attestation struct {
+	// Index of the contribution, starting from 1
+	Contribution int    `json:"contribution"`
+	Hash         string `json:"hash"`
+	InputDigest  string `json:"inputDigest"`
+	OutputDigest string `json:"outputDigest"`
+	// Public key in authorized_keys format
+	PublicKey string `json:"publicKey"`
+	// Signed message, rebuilt from the fields above on verification
+	Message string `json:"message"`
+	// Armored SSHSIG signature of Message, in the format of ssh-keygen -Y sign
+	Signature string `json:"signature"`
+}
+
+// The signed message covers the index and hash of the contribution and the digests of the input and output files.
+// Fields are hex encoded on their own line, so that they can't run into each other
+func message(contribution int, hash, inputDigest, outputDigest []byte) string {
+	return fmt.Sprintf("semaphore-mtb-setup attestation v2\ncontribution: %d\nhash: %x\ninput: %x\noutput: %x\n",
+		contribution, hash, inputDigest, outputDigest)
+}
+
+// LoadSigner reads an ed25519 SSH private key, asking passphrase for the passphrase of encrypted keys
+func LoadSigner(keyPath string, passphrase func() ([]byte, error)) (ssh.Signer, error) {
+	keyBytes, err := os.ReadFile(keyPath)
+	if err != nil {
+		return nil, err
+	}
+	signer, err := ssh.ParsePrivateKey(keyBytes)
+	var missing *ssh.PassphraseMissingError
+	if errors.As(err, &missing) && passphrase != nil {
+		var secret []byte
+		if secret, err = passphrase(); err != nil {
+			return nil, err
+		}
+		signer, err = ssh.ParsePrivateKeyWithPassphrase(keyBytes, secret)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", keyPath, err)
+	}
+	if signer.PublicKey().Type() != ssh.KeyAlgoED25519 {
+		return nil, fmt.Errorf("only %s keys are supported", ssh.KeyAlgoED25519)
+	}
+	return signer, nil
+}
+
+// Sign attests the last contribution of outputPath, made on top of inputPath, with a signer from LoadSigner
+func Sign(inputPath, outputPath string, signer ssh.Signer) (*Attestation, error) {
+	_, contributions, err := phase2.ReadContributions(outputPath)
+	if err != nil {
+		return nil, err
+	}
+	if len(contributions) == 0 {
+		return nil, errors.New("there are no contributions to attest")
+	}
+	hash := contributions[len(contributions)-1].Hash
+	inputDigest, err := phase2.Digest(inputPath)
+	if err != nil {
+		return nil, err
+	}
+	outputDigest, err := phase2.Digest(outputPath)
+	if err != nil {
+		return nil, err
+	}
+
+	msg := message(len(contributions), hash, inputDigest, outputDigest)
+	signature, err := signSSHSIG(signer, []byte(msg))
+	if err != nil {
+		return nil, err
+	}
+	return &Attestation{
+		Contribution: len(contributions),
+		Hash:         hex.EncodeToString(hash),
+		InputDigest:  hex.EncodeToString(inputDigest),
+		OutputDigest: hex.EncodeToString(outputDigest),
+		PublicKey:    strings.TrimSpace(string(ssh.MarshalAuthorizedKey(signer.PublicKey()))),
+		Message:      msg,
+		Signature:    signature,
+	}, nil
+}
+
+func (a *Attestation) Write(path string) error {
+	b, err := json.MarshalIndent(a, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, append(b, '\n'), 0644)
+}
+
+func Read(path string) (*Attestation, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var a Attestation
+	if err := json.Unmarshal(b, &a); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return &a, nil
+}
+
+// verify checks the signature of the attestation and returns its public key
+func (a *Attestation) verify() (ssh.PublicKey, error) {
+	publicKey, _, _, _, err := ssh.ParseAuthorizedKey([]byte(a.PublicKey))
+	if err != nil {
+		return nil, err
+	}
+	if publicKey.Type() != ssh.KeyAlgoED25519 {
+		return nil, fmt.Errorf("only %s keys are supported", ssh.KeyAlgoED25519)
+	}
+	hash, err := hex.DecodeString(a.Hash)
+	if err != nil {
+		return nil, err
+	}
+	inputDigest, err := hex.DecodeString(a.InputDigest)
+	if err != nil {
+		return nil, err
+	}
+	outputDigest, err := hex.DecodeString(a.OutputDigest)
+	if err != nil {
+		return nil, err
+	}
+	msg := message(a.Contribution, hash, inputDigest, outputDigest)
+	if a.Message != msg {
+		return nil, errors.New("message doesn't match the attested contribution")
+	}
+	if err := verifySSHSIG(publicKey, a.Signature, []byte(msg)); err != nil {
+		return nil, err
+	}
+	return publicKey, nil
+}
+
+// readKnownKeys reads public keys in authorized_keys format, named by their comment
+func readKnownKeys(path string) (map[string]string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	known := make(map[string]string)
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		line := bytes.TrimSpace(scanner.Bytes())
+		if len(line) == 0 || line[0] == '#' {
+			continue
+		}
+		publicKey, comment, _, _, err := ssh.ParseAuthorizedKey(line)
+		if err != nil {
+			return nil, err
+		}
+		known[string(publicKey.Marshal())] = comment
+	}
+	return known, scanner.Err()
+}
+
+// Verify checks the attestations of a directory against the contributions of a phase 2 file
+// and a list of known public keys, then reports which contributions are attested and by whom
+func Verify(phase2Path, dir, knownKeysPath string) error {
+	_, contributions, err := phase2.ReadContributions(phase2Path)
+	if err != nil {
+		return err
+	}
+	known, err := readKnownKeys(knownKeysPath)
+	if err != nil {
+		return err
+	}
+	paths, err := filepath.Glob(filepath.Join(dir, "*"+Extension))
+	if err != nil {
+		return err
+	}
+	sort.Strings(paths)
+
+	attestations := make([]*Attestation, len(contributions))
+	signers := make([]string, len(contributions))
+	for _, path := range paths {
+		a, err := Read(path)
+		if err != nil {
+			return err
+		}
+		if a.Contribution < 1 || a.Contribution > len(contributions) {
+			return fmt.Errorf("%s: contribution %d doesn't exist", path, a.Contribution)
+		}
+		if a.Hash != hex.EncodeToString(contributions[a.Contribution-1].Hash) {
+			return fmt.Errorf("%s: hash doesn't match contribution %d", path, a.Contribution)
+		}
+		publicKey, err := a.verify()
+		if err != nil {
+			return fmt.Errorf("%s: invalid signature: %w", path, err)
+		}
+		name, ok := known[string(publicKey.Marshal())]
+		if !ok {
+			return fmt.Errorf("%s: public key isn't known", path)
+		}
+		if attestations[a.Contribution-1] != nil {
+			return fmt.Errorf("%s: contribution %d is attested more than once", path, a.Contribution)
+		}
+		attestations[a.Contribution-1] = a
+		signers[a.Contribution-1] = name
+	}
+
+	// Successive attestations must agree on the file in between
+	for i := 1; i < len(attestations); i++ {
+		if attestations[i-1] != nil && attestations[i] != nil && attestations[i-1].OutputDigest != attestations[i].InputDigest {
+			return fmt.Errorf("contribution %d wasn't made on top of the output of contribution %d", i+1, i)
+		}
+	}
+	if last := len(attestations) - 1; last >= 0 && attestations[last] != nil {
+		digest, err := phase2.Digest(phase2Path)
+		if err != nil {
+			return err
+		}
+		if attestations[last].OutputDigest != hex.EncodeToString(digest) {
+			return fmt.Errorf("attestation of the last contribution doesn't match %s", phase2Path)
+		}
+	}
+
+	nbAttested := 0
+	for i, c := range contributions {
+		if attestations[i] == nil {
+			fmt.Printf("Contribution %d with Hash := %s isn't attested\n", i+1, hex.EncodeToString(c.Hash))
+			continue
+		}
+		nbAttested++
+		fmt.Printf("Contribution %d with Hash := %s is attested by %s\n", i+1, hex.EncodeToString(c.Hash), signers[i])
+	}
+	fmt.Printf("%d out of %d contributions are attested\n", nbAttested, len(contributions))
+	return nil
+}
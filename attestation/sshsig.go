@@ -0,0 +1,112 @@
+package attestation
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/sha512"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strings"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// Signatures follow the SSHSIG format of OpenSSH (PROTOCOL.sshsig), so that they can be checked with
+// ssh-keygen -Y verify as well
+
+const (
+	sshsigMagic     = "SSHSIG"
+	sshsigVersion   = 1
+	sshsigHash      = "sha512"
+	sshsigBegin     = "-----BEGIN SSH SIGNATURE-----"
+	sshsigEnd       = "-----END SSH SIGNATURE-----"
+	sshsigLineWidth = 70
+)
+
+// sshsigSigned is the data actually signed, following the magic
+type sshsigSigned struct {
+	Namespace string
+	Reserved  string
+	HashAlg   string
+	Hash      []byte
+}
+
+// sshsigEnvelope is the signature, following the magic
+type sshsigEnvelope struct {
+	Version   uint32
+	PublicKey []byte
+	Namespace string
+	Reserved  string
+	HashAlg   string
+	Signature []byte
+}
+
+func sshsigSignedData(msg []byte) []byte {
+	hash := sha512.Sum512(msg)
+	signed := ssh.Marshal(sshsigSigned{Namespace: Namespace, HashAlg: sshsigHash, Hash: hash[:]})
+	return append([]byte(sshsigMagic), signed...)
+}
+
+// signSSHSIG signs msg in the Namespace and returns the armored signature
+func signSSHSIG(signer ssh.Signer, msg []byte) (string, error) {
+	signature, err := signer.Sign(rand.Reader, sshsigSignedData(msg))
+	if err != nil {
+		return "", err
+	}
+	envelope := ssh.Marshal(sshsigEnvelope{
+		Version:   sshsigVersion,
+		PublicKey: signer.PublicKey().Marshal(),
+		Namespace: Namespace,
+		HashAlg:   sshsigHash,
+		Signature: ssh.Marshal(signature),
+	})
+	encoded := base64.StdEncoding.EncodeToString(append([]byte(sshsigMagic), envelope...))
+
+	var armored strings.Builder
+	armored.WriteString(sshsigBegin + "\n")
+	for len(encoded) > sshsigLineWidth {
+		armored.WriteString(encoded[:sshsigLineWidth] + "\n")
+		encoded = encoded[sshsigLineWidth:]
+	}
+	armored.WriteString(encoded + "\n")
+	armored.WriteString(sshsigEnd + "\n")
+	return armored.String(), nil
+}
+
+// verifySSHSIG checks that the armored signature is a signature of msg by publicKey in the Namespace
+func verifySSHSIG(publicKey ssh.PublicKey, armored string, msg []byte) error {
+	armored = strings.TrimSpace(armored)
+	if !strings.HasPrefix(armored, sshsigBegin) || !strings.HasSuffix(armored, sshsigEnd) {
+		return errors.New("signature isn't armored")
+	}
+	encoded := strings.Join(strings.Fields(armored[len(sshsigBegin):len(armored)-len(sshsigEnd)]), "")
+	blob, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		return err
+	}
+	if !bytes.HasPrefix(blob, []byte(sshsigMagic)) {
+		return errors.New("not an SSH signature")
+	}
+	var envelope sshsigEnvelope
+	if err := ssh.Unmarshal(blob[len(sshsigMagic):], &envelope); err != nil {
+		return err
+	}
+	if envelope.Version != sshsigVersion {
+		return fmt.Errorf("unsupported SSH signature version %d", envelope.Version)
+	}
+	if envelope.Namespace != Namespace {
+		return fmt.Errorf("signature is for namespace %q, expected %q", envelope.Namespace, Namespace)
+	}
+	if envelope.HashAlg != sshsigHash {
+		return fmt.Errorf("unsupported hash algorithm %s", envelope.HashAlg)
+	}
+	if !bytes.Equal(envelope.PublicKey, publicKey.Marshal()) {
+		return errors.New("signature was made with another key")
+	}
+	var signature ssh.Signature
+	if err := ssh.Unmarshal(envelope.Signature, &signature); err != nil {
+		return err
+	}
+	return publicKey.Verify(sshsigSignedData(msg), &signature)
+}
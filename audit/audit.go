@@ -0,0 +1,192 @@
+package audit
+
+import (
+	"bytes"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/worldcoin/semaphore-mtb-setup/phase2"
+)
+
+// Files of a ceremony are numbered after the origin, e.g. b1000t30c0.ph2, b1000t30c01.ph2, b1000t30c02.ph2...
+var numbered = regexp.MustCompile(`^(.*c)(\d+)\.ph2$`)
+
+// Step is the audit of a file against its predecessor
+type Step struct {
+	File         string `json:"file"`
+	Contribution int    `json:"contribution"`
+	Hash         string `json:"hash,omitempty"`
+	Name         string `json:"name,omitempty"`
+	// Number of files missing between the previous file and this one
+	Missing int    `json:"missing,omitempty"`
+	Valid   bool   `json:"valid"`
+	Error   string `json:"error,omitempty"`
+}
+
+type Report struct {
+	Origin string `json:"origin"`
+	Steps  []Step `json:"steps"`
+	Valid  bool   `json:"valid"`
+}
+
+// Run discovers the files of dir numbered after the origin and verifies each of them against its predecessor,
+// or against the origin if files are missing in between or the predecessor is invalid.
+// Failing steps are recorded in the report, which is only invalid if any step failed
+func Run(dir, originPath string) (*Report, error) {
+	match := numbered.FindStringSubmatch(filepath.Base(originPath))
+	if match == nil {
+		return nil, fmt.Errorf("origin %s isn't numbered like <name>c<number>.ph2", originPath)
+	}
+	prefix := match[1]
+	originNumber, _ := strconv.Atoi(match[2])
+
+	// Discover the numbered files
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	numbers := make(map[int]string)
+	for _, entry := range entries {
+		m := numbered.FindStringSubmatch(entry.Name())
+		if entry.IsDir() || m == nil || m[1] != prefix {
+			continue
+		}
+		n, err := strconv.Atoi(m[2])
+		if err != nil || n <= originNumber {
+			continue
+		}
+		if other, ok := numbers[n]; ok {
+			return nil, fmt.Errorf("%s and %s have the same number", other, entry.Name())
+		}
+		numbers[n] = entry.Name()
+	}
+	if len(numbers) == 0 {
+		return nil, fmt.Errorf("no files numbered after %s in %s", filepath.Base(originPath), dir)
+	}
+	sorted := make([]int, 0, len(numbers))
+	for n := range numbers {
+		sorted = append(sorted, n)
+	}
+	sort.Ints(sorted)
+
+	_, prevContributions, err := phase2.ReadContributions(originPath)
+	if err != nil {
+		return nil, err
+	}
+	report := Report{Origin: filepath.Base(originPath), Valid: true}
+	prevPath := originPath
+	prevNumber := originNumber
+	lastNumber := originNumber
+	for _, n := range sorted {
+		path := filepath.Join(dir, numbers[n])
+		fmt.Printf("Auditing %s\n", numbers[n])
+		step := Step{File: numbers[n], Contribution: len(prevContributions) + 1, Missing: n - lastNumber - 1}
+		lastNumber = n
+		if step.Missing > 0 {
+			fmt.Printf("%d files are missing before %s, verifying it from the origin\n", step.Missing, numbers[n])
+		}
+		// Files following a missing or invalid one are verified from the origin
+		contributions, err := auditStep(&step, path, prevPath, originPath, n-prevNumber == 1, prevContributions)
+		if err != nil {
+			step.Error = err.Error()
+			report.Valid = false
+		} else {
+			step.Valid = true
+			// Later files are compared to the last valid one
+			prevPath = path
+			prevNumber = n
+			prevContributions = contributions
+		}
+		report.Steps = append(report.Steps, step)
+	}
+	return &report, nil
+}
+
+func auditStep(step *Step, path, prevPath, originPath string, incremental bool, prevContributions []phase2.Contribution) ([]phase2.Contribution, error) {
+	_, contributions, err := phase2.ReadContributions(path)
+	if err != nil {
+		return nil, err
+	}
+	if len(contributions) > 0 {
+		last := contributions[len(contributions)-1]
+		step.Contribution = len(contributions)
+		step.Hash = hex.EncodeToString(last.Hash)
+		if last.Metadata != nil {
+			step.Name = last.Metadata.Name
+		}
+	}
+
+	// Earlier contributions must be shared with the predecessor
+	for i := range prevContributions {
+		if i >= len(contributions) {
+			return nil, fmt.Errorf("contribution %d is missing", i+1)
+		}
+		if !bytes.Equal(contributions[i].Hash, prevContributions[i].Hash) {
+			return nil, fmt.Errorf("contribution %d was replaced, %s forks from %s", i+1, step.File, filepath.Base(prevPath))
+		}
+	}
+
+	if !incremental {
+		return contributions, phase2.Verify(path, originPath)
+	}
+	return contributions, phase2.VerifyIncremental(path, prevPath)
+}
+
+func (r *Report) WriteJSON(path string) error {
+	b, err := json.MarshalIndent(r, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, append(b, '\n'), 0644)
+}
+
+// WriteMarkdown lists the contributions in the layout of the list of contributors of CEREMONY.md
+func (r *Report) WriteMarkdown(path string) error {
+	var b strings.Builder
+	fmt.Fprintf(&b, "# Audit of %s\n\n", r.Origin)
+	for _, step := range r.Steps {
+		name := step.Name
+		if name == "" {
+			name = "unknown"
+		}
+		fmt.Fprintf(&b, "%d. **%s**\n", step.Contribution, name)
+		if step.Hash != "" {
+			fmt.Fprintf(&b, "- contribution hash: `%s`\n", step.Hash)
+		}
+		fmt.Fprintf(&b, "- generated file: `%s`\n", step.File)
+		if step.Missing > 0 {
+			fmt.Fprintf(&b, "- %d files are missing before it, it was verified from the origin\n", step.Missing)
+		}
+		if !step.Valid {
+			fmt.Fprintf(&b, "- error: %s\n", step.Error)
+		}
+	}
+	if r.Valid {
+		fmt.Fprintf(&b, "\nAll %d files are valid\n", len(r.Steps))
+	} else {
+		fmt.Fprintf(&b, "\nThe ceremony is invalid\n")
+	}
+	return os.WriteFile(path, []byte(b.String()), 0644)
+}
+
+// Err summarizes the failing steps of the report
+func (r *Report) Err() error {
+	var failing []string
+	for _, step := range r.Steps {
+		if !step.Valid {
+			failing = append(failing, step.File)
+		}
+	}
+	if len(failing) == 0 {
+		return nil
+	}
+	return errors.New("audit failed for " + strings.Join(failing, ", "))
+}
@@ -0,0 +1,115 @@
+package audit
+
+import (
+	"bufio"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/worldcoin/semaphore-mtb-setup/phase2"
+)
+
+// Entry is a published contribution hash, with an optional label such as the name of the contributor
+type Entry struct {
+	Hash  string
+	Label string
+}
+
+// ReadExpected reads one hex contribution hash per line, optionally followed or preceded by a label.
+// Empty lines and lines starting with # are skipped
+func ReadExpected(path string) ([]Entry, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	var entries []Entry
+	scanner := bufio.NewScanner(file)
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+		var entry Entry
+		var label []string
+		for _, field := range strings.Fields(text) {
+			field = strings.Trim(field, "`")
+			if entry.Hash == "" && isHash(field) {
+				entry.Hash = strings.ToLower(field)
+			} else {
+				label = append(label, field)
+			}
+		}
+		if entry.Hash == "" {
+			return nil, fmt.Errorf("%s:%d: no contribution hash", path, line)
+		}
+		entry.Label = strings.Join(label, " ")
+		entries = append(entries, entry)
+	}
+	return entries, scanner.Err()
+}
+
+func (e Entry) String() string {
+	if e.Label == "" {
+		return e.Hash
+	}
+	return e.Hash + " " + e.Label
+}
+
+func isHash(s string) bool {
+	b, err := hex.DecodeString(s)
+	return err == nil && len(b) == 32
+}
+
+// CheckHashes compares the contribution hashes of a phase 2 file position by position with the expected ones,
+// reporting missing, extra and mismatched entries
+func CheckHashes(phase2Path, expectedPath string) error {
+	expected, err := ReadExpected(expectedPath)
+	if err != nil {
+		return err
+	}
+	_, contributions, err := phase2.ReadContributions(phase2Path)
+	if err != nil {
+		return err
+	}
+
+	nbErrors := 0
+	for i := 0; i < len(expected) || i < len(contributions); i++ {
+		switch {
+		case i >= len(contributions):
+			fmt.Printf("Contribution %d is missing, expected %s\n", i+1, expected[i])
+			nbErrors++
+		case i >= len(expected):
+			fmt.Printf("Contribution %d with Hash := %s is extra\n", i+1, hex.EncodeToString(contributions[i].Hash))
+			nbErrors++
+		case hex.EncodeToString(contributions[i].Hash) != expected[i].Hash:
+			fmt.Printf("Contribution %d with Hash := %s doesn't match, expected %s\n", i+1, hex.EncodeToString(contributions[i].Hash), expected[i])
+			nbErrors++
+		default:
+			fmt.Printf("Contribution %d matches %s\n", i+1, expected[i])
+		}
+	}
+	if nbErrors > 0 {
+		return fmt.Errorf("%d contribution hashes don't match %s", nbErrors, expectedPath)
+	}
+	fmt.Printf("All %d contribution hashes match\n", len(contributions))
+	return nil
+}
+
+// PrintHashes lists the contribution hashes of a phase 2 file, in the format read by CheckHashes
+func PrintHashes(phase2Path string) error {
+	_, contributions, err := phase2.ReadContributions(phase2Path)
+	if err != nil {
+		return err
+	}
+	for _, c := range contributions {
+		line := hex.EncodeToString(c.Hash)
+		if c.Metadata != nil && c.Metadata.Name != "" {
+			line += " " + c.Metadata.Name
+		}
+		fmt.Println(line)
+	}
+	return nil
+}
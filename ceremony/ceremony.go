@@ -0,0 +1,126 @@
+package ceremony
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/worldcoin/semaphore-mtb-setup/keys"
+	"github.com/worldcoin/semaphore-mtb-setup/phase2"
+)
+
+// Init initializes phase 2 of every circuit into its directory
+func Init(m *Manifest) error {
+	for _, c := range m.Circuits {
+		fmt.Printf("Initializing %s\n", c.Name)
+		if err := os.MkdirAll(c.Dir, 0755); err != nil {
+			return err
+		}
+		if _, err := os.Stat(c.phase2Path(0)); err == nil {
+			return fmt.Errorf("%s is already initialized", c.Name)
+		}
+		if err := phase2.Initialize(c.Phase1, c.R1CS, c.phase2Path(0), c.path("srs.lag"), c.path("evals")); err != nil {
+			return fmt.Errorf("%s: %w", c.Name, err)
+		}
+	}
+	return nil
+}
+
+// Contribute adds a contribution to the latest phase 2 file of every circuit, with a δ read from rand for each.
+// The latest files are verified against their origin first, so that nothing is added to an invalid file
+func Contribute(m *Manifest, rand io.Reader, metadata *phase2.Metadata) error {
+	for _, c := range m.Circuits {
+		n, latestPath, err := c.latest()
+		if err != nil {
+			return err
+		}
+		if n > 0 {
+			fmt.Printf("Verifying %s\n", c.Name)
+			if err := phase2.Verify(latestPath, c.phase2Path(0)); err != nil {
+				return fmt.Errorf("%s: %w", c.Name, err)
+			}
+		}
+		fmt.Printf("Contributing to %s\n", c.Name)
+		if err := phase2.ContributeWithRand(latestPath, c.phase2Path(n+1), rand, metadata); err != nil {
+			return fmt.Errorf("%s: %w", c.Name, err)
+		}
+	}
+	return nil
+}
+
+// Verify verifies the latest phase 2 file of every circuit against its origin. All circuits are verified
+// before the failing ones are reported
+func Verify(m *Manifest) error {
+	var failed []string
+	for _, c := range m.Circuits {
+		fmt.Printf("Verifying %s\n", c.Name)
+		if err := c.verify(); err != nil {
+			fmt.Printf("Verification of %s has failed: %s\n", c.Name, err)
+			failed = append(failed, c.Name)
+		}
+	}
+	if len(failed) != 0 {
+		return fmt.Errorf("verification has failed for %s", strings.Join(failed, ", "))
+	}
+	return nil
+}
+
+func (c *Circuit) verify() error {
+	n, latestPath, err := c.latest()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return errors.New("there are no contributions yet")
+	}
+	return phase2.Verify(latestPath, c.phase2Path(0))
+}
+
+// ExtractKeys extracts the proving and verifying keys of every circuit from its latest phase 2 file
+func ExtractKeys(m *Manifest) error {
+	for _, c := range m.Circuits {
+		_, latestPath, err := c.latest()
+		if err != nil {
+			return err
+		}
+		fmt.Printf("Extracting the keys of %s from %s\n", c.Name, latestPath)
+		if err := keys.ExtractKeys(latestPath, c.path("evals"), c.path("pk"), c.path("vk")); err != nil {
+			return fmt.Errorf("%s: %w", c.Name, err)
+		}
+	}
+	return nil
+}
+
+// latest returns the number and path of the latest phase 2 file in the directory of the circuit
+func (c *Circuit) latest() (int, string, error) {
+	entries, err := os.ReadDir(c.Dir)
+	if err != nil {
+		return 0, "", err
+	}
+	numbered := regexp.MustCompile(`^` + regexp.QuoteMeta(c.Name) + `c(\d+)\.ph2$`)
+	latest, latestName := -1, ""
+	for _, entry := range entries {
+		m := numbered.FindStringSubmatch(entry.Name())
+		if entry.IsDir() || m == nil {
+			continue
+		}
+		n, err := strconv.Atoi(m[1])
+		if err != nil {
+			continue
+		}
+		if n == latest {
+			return 0, "", fmt.Errorf("%s and %s have the same number", latestName, entry.Name())
+		}
+		if n > latest {
+			latest, latestName = n, entry.Name()
+		}
+	}
+	if latest < 0 {
+		return 0, "", fmt.Errorf("%s isn't initialized, %s has no phase 2 file", c.Name, c.Dir)
+	}
+	return latest, c.path(latestName), nil
+}
@@ -0,0 +1,111 @@
+package ceremony
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Circuit is a circuit of the ceremony. Relative paths are relative to the manifest
+type Circuit struct {
+	// Name of the circuit, which prefixes its phase 2 files, e.g. b10t30 for b10t30c0.ph2
+	Name string `json:"name" yaml:"name"`
+	R1CS string `json:"r1cs" yaml:"r1cs"`
+	// Last phase 1 contribution the circuit is initialized from
+	Phase1 string `json:"phase1" yaml:"phase1"`
+	// Directory of the phase 2 files, srs.lag, evals, pk and vk of the circuit, defaults to the name
+	Dir string `json:"dir,omitempty" yaml:"dir,omitempty"`
+}
+
+// Manifest lists the circuits a ceremony runs with the same contributors
+type Manifest struct {
+	Circuits []Circuit `json:"circuits" yaml:"circuits"`
+}
+
+// ReadManifest reads and checks a manifest, and resolves its paths.
+// Manifests ending with .yaml or .yml are read as YAML, others as JSON
+func ReadManifest(path string) (*Manifest, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	unmarshal := json.Unmarshal
+	if ext := strings.ToLower(filepath.Ext(path)); ext == ".yaml" || ext == ".yml" {
+		unmarshal = yaml.Unmarshal
+	}
+	var m Manifest
+	if err := unmarshal(b, &m); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	if len(m.Circuits) == 0 {
+		return nil, errors.New("manifest lists no circuits")
+	}
+
+	base := filepath.Dir(path)
+	resolve := func(p string) string {
+		if filepath.IsAbs(p) {
+			return p
+		}
+		return filepath.Join(base, p)
+	}
+	names := make(map[string]bool)
+	for i := range m.Circuits {
+		c := &m.Circuits[i]
+		if c.Name == "" {
+			return nil, fmt.Errorf("circuit %d has no name", i+1)
+		}
+		// Names end up in file paths, and must stay within the directory of the manifest
+		if strings.ContainsAny(c.Name, `/\`) || strings.Contains(c.Name, "..") || c.Name == "." {
+			return nil, fmt.Errorf("name %q of circuit %d isn't a plain file name", c.Name, i+1)
+		}
+		if names[c.Name] {
+			return nil, fmt.Errorf("circuit %s is listed twice", c.Name)
+		}
+		names[c.Name] = true
+		if c.R1CS == "" || c.Phase1 == "" {
+			return nil, fmt.Errorf("circuit %s needs both r1cs and phase1", c.Name)
+		}
+		if c.Dir == "" {
+			c.Dir = c.Name
+		}
+		c.R1CS = resolve(c.R1CS)
+		c.Phase1 = resolve(c.Phase1)
+		c.Dir = resolve(c.Dir)
+	}
+	return &m, nil
+}
+
+// Select keeps the given circuits only, or all of them if names is empty
+func (m *Manifest) Select(names []string) (*Manifest, error) {
+	if len(names) == 0 {
+		return m, nil
+	}
+	var selected Manifest
+	for _, name := range names {
+		found := false
+		for _, c := range m.Circuits {
+			if c.Name == name {
+				selected.Circuits = append(selected.Circuits, c)
+				found = true
+			}
+		}
+		if !found {
+			return nil, fmt.Errorf("manifest has no circuit %s", name)
+		}
+	}
+	return &selected, nil
+}
+
+func (c *Circuit) path(name string) string {
+	return filepath.Join(c.Dir, name)
+}
+
+// phase2Path returns the path of the phase 2 file of a contribution, the origin being 0
+func (c *Circuit) phase2Path(contribution int) string {
+	return c.path(fmt.Sprintf("%sc%d.ph2", c.Name, contribution))
+}
@@ -0,0 +1,119 @@
+package common
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"errors"
+	"hash"
+	"io"
+	"os"
+)
+
+// DigestSize is the size of the SHA256 digest trailing .ph1 and .ph2 files
+const DigestSize = sha256.Size
+
+// AppendDigest appends the SHA256 of the whole file to its end
+func AppendDigest(file *os.File) error {
+	if _, err := file.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	sha := sha256.New()
+	if _, err := io.Copy(sha, file); err != nil {
+		return err
+	}
+	_, err := file.Write(sha.Sum(nil))
+	return err
+}
+
+// CheckDigest checks the SHA256 trailing the file against the rest of it,
+// then seeks back to the beginning of the file
+func CheckDigest(file io.ReadSeeker) error {
+	_, err := ContentDigest(file, true)
+	return err
+}
+
+// ContentDigest returns the SHA256 of the file without its trailing digest, if any,
+// after checking it against the trailing one. It then seeks back to the beginning of the file
+func ContentDigest(file io.ReadSeeker, trailing bool) ([]byte, error) {
+	size, err := file.Seek(0, io.SeekEnd)
+	if err != nil {
+		return nil, err
+	}
+	if trailing {
+		if size < DigestSize {
+			return nil, errors.New("file is too short to hold a digest")
+		}
+		size -= DigestSize
+	}
+	if _, err := file.Seek(0, io.SeekStart); err != nil {
+		return nil, err
+	}
+	sha := sha256.New()
+	if _, err := io.CopyN(sha, file, size); err != nil {
+		return nil, err
+	}
+	res := sha.Sum(nil)
+	if trailing {
+		digest := make([]byte, DigestSize)
+		if _, err := io.ReadFull(file, digest); err != nil {
+			return nil, err
+		}
+		if !bytes.Equal(digest, res) {
+			return nil, errors.New("digest of the file doesn't match its content, it may be corrupted")
+		}
+	}
+	if _, err := file.Seek(0, io.SeekStart); err != nil {
+		return nil, err
+	}
+	return res, nil
+}
+
+// DigestReader hashes a stream whose last DigestSize bytes are the SHA256 of the rest of it,
+// so that the digest can be checked without seeking
+type DigestReader struct {
+	reader io.Reader
+	sha    hash.Hash
+	// Last bytes read, which may be the digest
+	tail []byte
+	eof  bool
+}
+
+func NewDigestReader(reader io.Reader) *DigestReader {
+	return &DigestReader{reader: reader, sha: sha256.New(), tail: make([]byte, 0, DigestSize)}
+}
+
+func (d *DigestReader) Read(p []byte) (int, error) {
+	n, err := d.reader.Read(p)
+	if err == io.EOF {
+		d.eof = true
+	}
+	// Hash all but the last DigestSize bytes read so far
+	if held := len(d.tail) + n - DigestSize; held > 0 {
+		if held <= len(d.tail) {
+			d.sha.Write(d.tail[:held])
+			d.tail = append(d.tail[:0], d.tail[held:]...)
+			d.tail = append(d.tail, p[:n]...)
+		} else {
+			d.sha.Write(d.tail)
+			d.sha.Write(p[:held-len(d.tail)])
+			d.tail = append(d.tail[:0], p[n-DigestSize:n]...)
+		}
+	} else {
+		d.tail = append(d.tail, p[:n]...)
+	}
+	return n, err
+}
+
+// Check checks the digest once the stream has been read to its end
+func (d *DigestReader) Check() error {
+	if !d.eof {
+		return errors.New("stream hasn't been read to its end")
+	}
+	if len(d.tail) < DigestSize {
+		return errors.New("stream is too short to hold a digest")
+	}
+	if !bytes.Equal(d.tail, d.sha.Sum(nil)) {
+		return errors.New("digest of the stream doesn't match its content, it may be corrupted")
+	}
+	return nil
+}
@@ -0,0 +1,92 @@
+package common
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"io"
+
+	"github.com/consensys/gnark-crypto/ecc/bn254/fr"
+	"golang.org/x/crypto/chacha20"
+	"golang.org/x/crypto/hkdf"
+)
+
+// Domain separation of the keys derived for contributions, for seeded test ceremonies and for verification
+const (
+	entropyInfo      = "semaphore-mtb-setup contribution"
+	seedInfo         = "semaphore-mtb-setup seeded contribution"
+	coefficientsInfo = "semaphore-mtb-setup verification coefficients"
+	forkInfo         = "semaphore-mtb-setup forked contribution"
+)
+
+// Number of bytes reduced into a scalar, twice the size of r to make the bias negligible
+const elementSampleSize = 2 * fr.Bytes
+
+type chachaDRBG struct {
+	cipher *chacha20.Cipher
+}
+
+// NewEntropyReader returns a ChaCha20 keystream keyed by HKDF-SHA256 over 32 bytes of OS randomness
+// followed by the entropy supplied by the contributor. Without entropy, it only depends on OS randomness
+func NewEntropyReader(entropy []byte) (io.Reader, error) {
+	ikm := make([]byte, 32, 32+len(entropy))
+	if _, err := io.ReadFull(rand.Reader, ikm); err != nil {
+		return nil, err
+	}
+	ikm = append(ikm, entropy...)
+	return newDRBG(ikm, entropyInfo)
+}
+
+// NewSeededReader returns a ChaCha20 keystream keyed by HKDF-SHA256 over the seed only.
+// It must only be used to reproduce test ceremonies since contributions are then known to anyone with the seed
+func NewSeededReader(seed []byte) (io.Reader, error) {
+	return newDRBG(seed, seedInfo)
+}
+
+// NewCoefficientsReader returns a ChaCha20 keystream keyed by HKDF-SHA256 over the seed, from which
+// verifiers derive the coefficients of random linear combinations
+func NewCoefficientsReader(seed []byte) (io.Reader, error) {
+	return newDRBG(seed, coefficientsInfo)
+}
+
+// NewForkedReader returns a ChaCha20 keystream keyed by HKDF-SHA256 over 32 bytes read from rand, so that
+// contributions running concurrently each get their own source of randomness derived from the same one
+func NewForkedReader(rand io.Reader) (io.Reader, error) {
+	ikm := make([]byte, 32)
+	if _, err := io.ReadFull(rand, ikm); err != nil {
+		return nil, err
+	}
+	return newDRBG(ikm, forkInfo)
+}
+
+func newDRBG(ikm []byte, info string) (io.Reader, error) {
+	key := make([]byte, chacha20.KeySize)
+	if _, err := io.ReadFull(hkdf.New(sha256.New, ikm, nil, []byte(info)), key); err != nil {
+		return nil, err
+	}
+	cipher, err := chacha20.NewUnauthenticatedCipher(key, make([]byte, chacha20.NonceSize))
+	if err != nil {
+		return nil, err
+	}
+	return &chachaDRBG{cipher: cipher}, nil
+}
+
+func (d *chachaDRBG) Read(p []byte) (int, error) {
+	for i := range p {
+		p[i] = 0
+	}
+	d.cipher.XORKeyStream(p, p)
+	return len(p), nil
+}
+
+// SampleElement reads a non-zero scalar from the given source of randomness
+func SampleElement(rand io.Reader) (fr.Element, error) {
+	var e fr.Element
+	buff := make([]byte, elementSampleSize)
+	for e.IsZero() {
+		if _, err := io.ReadFull(rand, buff); err != nil {
+			return e, err
+		}
+		e.SetBytes(buff)
+	}
+	return e, nil
+}
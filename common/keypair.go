@@ -1,6 +1,7 @@
 package common
 
 import (
+	"io"
 	"math/big"
 
 	"github.com/consensys/gnark-crypto/ecc/bn254"
@@ -13,13 +14,16 @@ type PublicKey struct {
 	SPX bn254.G2Affine
 }
 
-func GenPublicKey(x fr.Element, challenge []byte, dst byte) PublicKey {
+// GenPublicKey generates the proof of knowledge of x, reading s from the given source of randomness
+func GenPublicKey(x fr.Element, challenge []byte, dst byte, rand io.Reader) (PublicKey, error) {
 	var pk PublicKey
 	_, _, g1, _ := bn254.Generators()
 
-	var s fr.Element
+	s, err := SampleElement(rand)
+	if err != nil {
+		return pk, err
+	}
 	var sBi big.Int
-	s.SetRandom()
 	s.BigInt(&sBi)
 	pk.S.ScalarMultiplication(&g1, &sBi)
 
@@ -33,7 +37,7 @@ func GenPublicKey(x fr.Element, challenge []byte, dst byte) PublicKey {
 
 	// compute x*spG2
 	pk.SPX.ScalarMultiplication(&SP, &xBi)
-	return pk
+	return pk, nil
 }
 
 // Generate SP in G₂ as Hash(gˢ, gˢˣ, challenge, dst)
@@ -0,0 +1,131 @@
+package coordinator
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+)
+
+// Client talks to a coordinator on behalf of an admin or a contributor
+type Client struct {
+	url   string
+	token string
+	http  *http.Client
+}
+
+func NewClient(url, token string) *Client {
+	return &Client{url: strings.TrimSuffix(url, "/"), token: token, http: http.DefaultClient}
+}
+
+// do sends a request and decodes the JSON response into res, if any
+func (c *Client) do(method, path string, body io.Reader, res interface{}) error {
+	req, err := http.NewRequest(method, c.url+path, body)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "Bearer "+c.token)
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 1<<16))
+		return fmt.Errorf("%s %s: %s: %s", method, path, resp.Status, strings.TrimSpace(string(msg)))
+	}
+	if res == nil {
+		return nil
+	}
+	return json.NewDecoder(resp.Body).Decode(res)
+}
+
+// Register adds a contributor to the queue and returns their token
+func (c *Client) Register(name string) (string, error) {
+	body, err := json.Marshal(map[string]string{"name": name})
+	if err != nil {
+		return "", err
+	}
+	var res struct {
+		Token string `json:"token"`
+	}
+	if err := c.do(http.MethodPost, "/contributors", bytes.NewReader(body), &res); err != nil {
+		return "", err
+	}
+	return res.Token, nil
+}
+
+// Skip removes the current contributor from the queue
+func (c *Client) Skip() error {
+	return c.do(http.MethodPost, "/skip", nil, nil)
+}
+
+func (c *Client) Status() (*Status, error) {
+	var status Status
+	if err := c.do(http.MethodGet, "/status", nil, &status); err != nil {
+		return nil, err
+	}
+	return &status, nil
+}
+
+// Turn returns the position of the contributor in the queue
+func (c *Client) Turn() (*Turn, error) {
+	var turn Turn
+	if err := c.do(http.MethodGet, "/turn", nil, &turn); err != nil {
+		return nil, err
+	}
+	return &turn, nil
+}
+
+// Download writes the latest phase 2 file of a circuit to outputPath
+func (c *Client) Download(circuit, outputPath string) error {
+	return c.download("/circuits/"+circuit+"/latest", outputPath)
+}
+
+// DownloadOrigin writes the initial phase 2 file of a circuit to outputPath
+func (c *Client) DownloadOrigin(circuit, outputPath string) error {
+	return c.download("/circuits/"+circuit+"/origin", outputPath)
+}
+
+func (c *Client) download(path, outputPath string) error {
+	req, err := http.NewRequest(http.MethodGet, c.url+path, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "Bearer "+c.token)
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 1<<16))
+		return fmt.Errorf("GET %s: %s: %s", path, resp.Status, strings.TrimSpace(string(msg)))
+	}
+	file, err := os.Create(outputPath)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	if _, err := io.Copy(file, resp.Body); err != nil {
+		return err
+	}
+	return file.Close()
+}
+
+// Upload sends the phase 2 file of a contribution to a circuit, and returns the receipt of the coordinator
+func (c *Client) Upload(circuit, inputPath string) (*Receipt, error) {
+	file, err := os.Open(inputPath)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	var receipt Receipt
+	if err := c.do(http.MethodPost, "/circuits/"+circuit+"/contribution", file, &receipt); err != nil {
+		return nil, err
+	}
+	return &receipt, nil
+}
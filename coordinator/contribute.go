@@ -0,0 +1,127 @@
+package coordinator
+
+import (
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+
+	"github.com/worldcoin/semaphore-mtb-setup/phase2"
+)
+
+// Extension of the receipts written next to the contributions
+const ReceiptExtension = ".receipt"
+
+// Contribute takes the turn of the contributor: for each circuit they have yet to contribute to, it downloads
+// and verifies the latest phase 2 file, contributes to it and uploads the result. Files and receipts are
+// written to dir, with the same names as in the state directory of the coordinator
+func (c *Client) Contribute(dir string, rand io.Reader, metadata *phase2.Metadata) ([]*Receipt, error) {
+	status, err := c.Status()
+	if err != nil {
+		return nil, err
+	}
+	turn, err := c.Turn()
+	if err != nil {
+		return nil, err
+	}
+	if turn.Position > 0 {
+		return nil, fmt.Errorf("it isn't your turn yet, %d contributors are ahead of you", turn.Position)
+	}
+
+	var receipts []*Receipt
+	for _, name := range turn.Pending {
+		var circuit *Circuit
+		for _, c := range status.Circuits {
+			if c.Name == name {
+				circuit = c
+			}
+		}
+		if circuit == nil {
+			return receipts, fmt.Errorf("coordinator has no status for %s", name)
+		}
+		receipt, err := c.contributeCircuit(dir, circuit, status.PublicKey, rand, metadata)
+		if err != nil {
+			return receipts, fmt.Errorf("%s: %w", name, err)
+		}
+		receipts = append(receipts, receipt)
+	}
+	return receipts, nil
+}
+
+func (c *Client) contributeCircuit(dir string, circuit *Circuit, publicKey string, rand io.Reader, metadata *phase2.Metadata) (*Receipt, error) {
+	// The origin is kept across turns, only the latest file is downloaded every time
+	originPath := filepath.Join(dir, fileName(circuit.Name, 0))
+	if _, err := os.Stat(originPath); os.IsNotExist(err) {
+		fmt.Printf("Downloading the initial file of %s\n", circuit.Name)
+		if err := c.DownloadOrigin(circuit.Name, originPath); err != nil {
+			return nil, err
+		}
+	} else if err != nil {
+		return nil, err
+	}
+	n := len(circuit.Contributions)
+	inputPath := originPath
+	if n > 0 {
+		inputPath = filepath.Join(dir, fileName(circuit.Name, n))
+		fmt.Printf("Downloading contribution %d of %s\n", n, circuit.Name)
+		if err := c.Download(circuit.Name, inputPath); err != nil {
+			return nil, err
+		}
+	}
+	if err := verifyLatest(inputPath, originPath, circuit); err != nil {
+		return nil, err
+	}
+
+	outputPath := filepath.Join(dir, fileName(circuit.Name, n+1))
+	if err := phase2.ContributeWithRand(inputPath, outputPath, rand, metadata); err != nil {
+		return nil, err
+	}
+	_, contributions, err := phase2.ReadContributions(outputPath)
+	if err != nil {
+		return nil, err
+	}
+	digest, err := phase2.Digest(outputPath)
+	if err != nil {
+		return nil, err
+	}
+
+	fmt.Printf("Uploading contribution %d of %s\n", n+1, circuit.Name)
+	receipt, err := c.Upload(circuit.Name, outputPath)
+	if err != nil {
+		return nil, err
+	}
+	if err := receipt.Verify(publicKey); err != nil {
+		return nil, err
+	}
+	if receipt.Circuit != circuit.Name || receipt.Contribution != n+1 ||
+		receipt.Hash != hex.EncodeToString(contributions[n].Hash) || receipt.Digest != hex.EncodeToString(digest) {
+		return nil, errors.New("receipt doesn't match the uploaded contribution")
+	}
+	if err := receipt.Write(outputPath + ReceiptExtension); err != nil {
+		return nil, err
+	}
+	return receipt, nil
+}
+
+// verifyLatest checks the latest file holds the contributions listed by the coordinator, and verifies them from the origin
+func verifyLatest(latestPath, originPath string, circuit *Circuit) error {
+	_, contributions, err := phase2.ReadContributions(latestPath)
+	if err != nil {
+		return err
+	}
+	if len(contributions) != len(circuit.Contributions) {
+		return fmt.Errorf("expected %d contributions, got %d", len(circuit.Contributions), len(contributions))
+	}
+	for i, c := range circuit.Contributions {
+		if hex.EncodeToString(contributions[i].Hash) != c.Hash {
+			return fmt.Errorf("hash of contribution %d doesn't match the one listed by the coordinator", i+1)
+		}
+	}
+	if len(contributions) == 0 {
+		return nil
+	}
+	fmt.Printf("Verifying the %d contributions of %s\n", len(contributions), circuit.Name)
+	return phase2.Verify(latestPath, originPath)
+}
@@ -0,0 +1,117 @@
+package coordinator
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// Domain separation of the signed message
+const receiptPrefix = "semaphore-mtb-setup receipt v1\x00"
+
+// Seed of the ed25519 key the coordinator signs receipts with, kept in the state directory
+const keyFile = "coordinator.key"
+
+// Receipt is the acknowledgement, signed by the coordinator, that a contribution was verified and accepted
+type Receipt struct {
+	Circuit string `json:"circuit"`
+	// Index of the contribution, starting from 1
+	Contribution int    `json:"contribution"`
+	Name         string `json:"name"`
+	File         string `json:"file"`
+	Hash         string `json:"hash"`
+	// SHA256 of the accepted phase 2 file, without its trailing digest
+	Digest    string    `json:"digest"`
+	Timestamp time.Time `json:"timestamp"`
+	// Public key of the coordinator in authorized_keys format
+	PublicKey string `json:"publicKey"`
+	// Wire format of the SSH signature in base64
+	Signature string `json:"signature,omitempty"`
+}
+
+// The signed message covers every field of the receipt but the signature
+func (r *Receipt) message() ([]byte, error) {
+	unsigned := *r
+	unsigned.Signature = ""
+	b, err := json.Marshal(&unsigned)
+	if err != nil {
+		return nil, err
+	}
+	return append([]byte(receiptPrefix), b...), nil
+}
+
+// loadSigner reads the key of the coordinator, or generates it on the first start
+func loadSigner(dir string) (ssh.Signer, error) {
+	path := filepath.Join(dir, keyFile)
+	seed, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		seed = make([]byte, ed25519.SeedSize)
+		if _, err := rand.Read(seed); err != nil {
+			return nil, err
+		}
+		if err := os.WriteFile(path, seed, 0600); err != nil {
+			return nil, err
+		}
+	} else if err != nil {
+		return nil, err
+	}
+	if len(seed) != ed25519.SeedSize {
+		return nil, fmt.Errorf("%s is corrupted", path)
+	}
+	return ssh.NewSignerFromKey(ed25519.NewKeyFromSeed(seed))
+}
+
+func (r *Receipt) sign(signer ssh.Signer) error {
+	r.PublicKey = strings.TrimSpace(string(ssh.MarshalAuthorizedKey(signer.PublicKey())))
+	msg, err := r.message()
+	if err != nil {
+		return err
+	}
+	signature, err := signer.Sign(rand.Reader, msg)
+	if err != nil {
+		return err
+	}
+	r.Signature = base64.StdEncoding.EncodeToString(ssh.Marshal(signature))
+	return nil
+}
+
+// Verify checks the receipt was signed by the coordinator with the given public key, in authorized_keys format
+func (r *Receipt) Verify(publicKey string) error {
+	if r.PublicKey != publicKey {
+		return errors.New("receipt isn't signed by the coordinator")
+	}
+	key, _, _, _, err := ssh.ParseAuthorizedKey([]byte(r.PublicKey))
+	if err != nil {
+		return err
+	}
+	blob, err := base64.StdEncoding.DecodeString(r.Signature)
+	if err != nil {
+		return err
+	}
+	var signature ssh.Signature
+	if err := ssh.Unmarshal(blob, &signature); err != nil {
+		return err
+	}
+	msg, err := r.message()
+	if err != nil {
+		return err
+	}
+	return key.Verify(msg, &signature)
+}
+
+func (r *Receipt) Write(path string) error {
+	b, err := json.MarshalIndent(r, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, append(b, '\n'), 0644)
+}
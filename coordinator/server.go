@@ -0,0 +1,480 @@
+package coordinator
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/worldcoin/semaphore-mtb-setup/phase2"
+	"golang.org/x/crypto/ssh"
+)
+
+// Upper bound of the growth of a phase 2 file by a contribution, including its metadata
+const maxContributionSize = 1 << 20
+
+// Server hands out the latest phase 2 file of each circuit to the current contributor of the queue,
+// and advances the chain of a circuit with their upload once it's verified
+type Server struct {
+	dir            string
+	adminTokenHash string
+	signer         ssh.Signer
+
+	// mu guards state, upload serializes verifications which can take a while
+	mu     sync.Mutex
+	state  *State
+	upload sync.Mutex
+}
+
+// NewServer resumes the ceremony persisted in dir, or starts one from the initial phase 2 file
+// of each circuit if dir has no state yet
+func NewServer(dir string, originPaths []string, adminToken string) (*Server, error) {
+	if adminToken == "" {
+		return nil, errors.New("admin token is required")
+	}
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	signer, err := loadSigner(dir)
+	if err != nil {
+		return nil, err
+	}
+	s := &Server{dir: dir, adminTokenHash: hashToken(adminToken), signer: signer}
+
+	state, err := loadState(dir)
+	if err == nil {
+		if len(originPaths) != 0 {
+			return nil, fmt.Errorf("a ceremony was already started in %s", dir)
+		}
+		fmt.Printf("Resuming ceremony of %d circuits with %d contributors in the queue\n", len(state.Circuits), len(state.Queue))
+		s.state = state
+		return s, nil
+	}
+	if !os.IsNotExist(err) {
+		return nil, err
+	}
+
+	if len(originPaths) == 0 {
+		return nil, errors.New("origin is required to start a ceremony")
+	}
+	state = &State{}
+	for _, originPath := range originPaths {
+		if originPath, err = filepath.Abs(originPath); err != nil {
+			return nil, err
+		}
+		header, _, err := phase2.ReadContributions(originPath)
+		if err != nil {
+			return nil, err
+		}
+		if header.Contributions != 0 {
+			return nil, fmt.Errorf("%s has %d contributions, it isn't an initial file", originPath, header.Contributions)
+		}
+		name := circuitName(originPath)
+		if state.circuit(name) != nil {
+			return nil, fmt.Errorf("circuit %s is given twice", name)
+		}
+		state.Circuits = append(state.Circuits, &Circuit{Name: name, Origin: originPath})
+		fmt.Printf("Starting ceremony of %s from %s\n", name, originPath)
+	}
+	if err := state.save(dir); err != nil {
+		return nil, err
+	}
+	s.state = state
+	return s, nil
+}
+
+func (s *Server) Handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/status", s.handleStatus)
+	mux.HandleFunc("/contributors", s.handleRegister)
+	mux.HandleFunc("/skip", s.handleSkip)
+	mux.HandleFunc("/turn", s.handleTurn)
+	mux.HandleFunc("/circuits/", s.handleCircuit)
+	return mux
+}
+
+func (s *Server) ListenAndServe(addr string) error {
+	server := &http.Server{
+		Addr:              addr,
+		Handler:           s.Handler(),
+		ReadHeaderTimeout: 30 * time.Second,
+	}
+	fmt.Printf("Coordinator listening on %s\n", addr)
+	return server.ListenAndServe()
+}
+
+// Status is the public state of the ceremony
+type Status struct {
+	Circuits []*Circuit `json:"circuits"`
+	Current  string     `json:"current,omitempty"`
+	Queue    []string   `json:"queue"`
+	// Public key receipts are signed with, in authorized_keys format
+	PublicKey string `json:"publicKey"`
+}
+
+// Turn is the position of a contributor in the queue, 0 being the current one,
+// and the circuits they have yet to contribute to
+type Turn struct {
+	Position int      `json:"position"`
+	Pending  []string `json:"pending"`
+}
+
+func bearerToken(r *http.Request) string {
+	return strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+}
+
+func (s *Server) isAdmin(r *http.Request) bool {
+	return subtle.ConstantTimeCompare([]byte(hashToken(bearerToken(r))), []byte(s.adminTokenHash)) == 1
+}
+
+// position returns the position in the queue of the contributor of the request, or -1
+func (s *Server) position(r *http.Request) int {
+	tokenHash := hashToken(bearerToken(r))
+	for i, c := range s.state.Queue {
+		if subtle.ConstantTimeCompare([]byte(c.TokenHash), []byte(tokenHash)) == 1 {
+			return i
+		}
+	}
+	return -1
+}
+
+// commit saves a modified copy of the state before it replaces the current one. mu must be held
+func (s *Server) commit(update func(state *State)) error {
+	state := s.state.clone()
+	update(state)
+	if err := state.save(s.dir); err != nil {
+		return err
+	}
+	s.state = state
+	return nil
+}
+
+func writeJSON(w http.ResponseWriter, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(v)
+}
+
+func allowMethod(w http.ResponseWriter, r *http.Request, method string) bool {
+	if r.Method != method {
+		w.Header().Set("Allow", method)
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return false
+	}
+	return true
+}
+
+func (s *Server) handleStatus(w http.ResponseWriter, r *http.Request) {
+	if !allowMethod(w, r, http.MethodGet) {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	status := Status{
+		Circuits:  s.state.clone().Circuits,
+		Queue:     []string{},
+		PublicKey: strings.TrimSpace(string(ssh.MarshalAuthorizedKey(s.signer.PublicKey()))),
+	}
+	for i, c := range s.state.Queue {
+		if i == 0 {
+			status.Current = c.Name
+		}
+		status.Queue = append(status.Queue, c.Name)
+	}
+	writeJSON(w, status)
+}
+
+// handleRegister adds a contributor to the queue and returns their token, which is only known to them
+func (s *Server) handleRegister(w http.ResponseWriter, r *http.Request) {
+	if !allowMethod(w, r, http.MethodPost) {
+		return
+	}
+	if !s.isAdmin(r) {
+		http.Error(w, "admin token is invalid", http.StatusUnauthorized)
+		return
+	}
+	var req struct {
+		Name string `json:"name"`
+	}
+	if err := json.NewDecoder(io.LimitReader(r.Body, 1<<16)).Decode(&req); err != nil || req.Name == "" {
+		http.Error(w, "name is required", http.StatusBadRequest)
+		return
+	}
+	buff := make([]byte, 32)
+	if _, err := rand.Read(buff); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	token := hex.EncodeToString(buff)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	err := s.commit(func(state *State) {
+		state.Queue = append(state.Queue, Contributor{Name: req.Name, TokenHash: hashToken(token)})
+	})
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	fmt.Printf("Registered %s at position %d\n", req.Name, len(s.state.Queue)-1)
+	writeJSON(w, map[string]string{"token": token})
+}
+
+// handleSkip removes the current contributor from the queue, e.g. when they don't show up.
+// The contributions they already made are kept
+func (s *Server) handleSkip(w http.ResponseWriter, r *http.Request) {
+	if !allowMethod(w, r, http.MethodPost) {
+		return
+	}
+	if !s.isAdmin(r) {
+		http.Error(w, "admin token is invalid", http.StatusUnauthorized)
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if len(s.state.Queue) == 0 {
+		http.Error(w, "queue is empty", http.StatusConflict)
+		return
+	}
+	skipped := s.state.Queue[0].Name
+	if err := s.commit(func(state *State) { state.Queue = state.Queue[1:] }); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	fmt.Printf("Skipped %s\n", skipped)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (s *Server) handleTurn(w http.ResponseWriter, r *http.Request) {
+	if !allowMethod(w, r, http.MethodGet) {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	position := s.position(r)
+	if position < 0 {
+		http.Error(w, "token isn't in the queue", http.StatusUnauthorized)
+		return
+	}
+	turn := Turn{Position: position, Pending: []string{}}
+	contributor := s.state.Queue[position]
+	for _, c := range s.state.Circuits {
+		if !contributor.hasContributed(c.Name) {
+			turn.Pending = append(turn.Pending, c.Name)
+		}
+	}
+	writeJSON(w, turn)
+}
+
+// handleCircuit routes /circuits/<name>/origin, /circuits/<name>/latest and /circuits/<name>/contribution
+func (s *Server) handleCircuit(w http.ResponseWriter, r *http.Request) {
+	parts := strings.Split(strings.TrimPrefix(r.URL.Path, "/circuits/"), "/")
+	if len(parts) != 2 {
+		http.NotFound(w, r)
+		return
+	}
+	switch parts[1] {
+	case "origin":
+		if allowMethod(w, r, http.MethodGet) {
+			s.handleOrigin(w, r, parts[0])
+		}
+	case "latest":
+		if allowMethod(w, r, http.MethodGet) {
+			s.handleLatest(w, r, parts[0])
+		}
+	case "contribution":
+		if allowMethod(w, r, http.MethodPost) {
+			s.handleContribution(w, r, parts[0])
+		}
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+// current checks the request comes from the current contributor and that they have yet to contribute
+// to the circuit, and returns the contributor and the circuit
+func (s *Server) current(w http.ResponseWriter, r *http.Request, name string) (*Contributor, *Circuit, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	switch position := s.position(r); {
+	case position < 0:
+		http.Error(w, "token isn't in the queue", http.StatusUnauthorized)
+		return nil, nil, false
+	case position > 0:
+		http.Error(w, "it isn't your turn yet", http.StatusForbidden)
+		return nil, nil, false
+	}
+	circuit := s.state.circuit(name)
+	if circuit == nil {
+		http.Error(w, fmt.Sprintf("unknown circuit %s", name), http.StatusNotFound)
+		return nil, nil, false
+	}
+	contributor := s.state.Queue[0]
+	if contributor.hasContributed(name) {
+		http.Error(w, fmt.Sprintf("you already contributed to %s", name), http.StatusConflict)
+		return nil, nil, false
+	}
+	state := s.state.clone()
+	return &state.Queue[0], state.circuit(name), true
+}
+
+// handleOrigin serves the initial phase 2 file of a circuit to anyone, so that contributors can verify
+// the latest file before they contribute
+func (s *Server) handleOrigin(w http.ResponseWriter, r *http.Request, name string) {
+	s.mu.Lock()
+	circuit := s.state.circuit(name)
+	s.mu.Unlock()
+	if circuit == nil {
+		http.Error(w, fmt.Sprintf("unknown circuit %s", name), http.StatusNotFound)
+		return
+	}
+	serveFile(w, r, circuit.Origin)
+}
+
+func (s *Server) handleLatest(w http.ResponseWriter, r *http.Request, name string) {
+	_, circuit, ok := s.current(w, r, name)
+	if !ok {
+		return
+	}
+	serveFile(w, r, circuit.latest(s.dir))
+}
+
+func serveFile(w http.ResponseWriter, r *http.Request, path string) {
+	file, err := os.Open(path)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	defer file.Close()
+	info, err := file.Stat()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/octet-stream")
+	http.ServeContent(w, r, filepath.Base(path), info.ModTime(), file)
+}
+
+// handleContribution verifies the upload of the current contributor from the origin, and advances
+// the chain if it builds on the latest contribution. The queue advances once the contributor
+// has contributed to every circuit. The response is a receipt signed by the coordinator
+func (s *Server) handleContribution(w http.ResponseWriter, r *http.Request, name string) {
+	if !s.upload.TryLock() {
+		http.Error(w, "a contribution is being verified", http.StatusConflict)
+		return
+	}
+	defer s.upload.Unlock()
+	contributor, circuit, ok := s.current(w, r, name)
+	if !ok {
+		return
+	}
+
+	info, err := os.Stat(circuit.latest(s.dir))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	tmp, err := os.CreateTemp(s.dir, "upload-*.ph2")
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	defer os.Remove(tmp.Name())
+	_, err = io.Copy(tmp, http.MaxBytesReader(w, r.Body, info.Size()+maxContributionSize))
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	hash, err := verifyUpload(tmp.Name(), circuit)
+	if err != nil {
+		fmt.Printf("Contribution of %s to %s has been rejected: %s\n", contributor.Name, name, err)
+		http.Error(w, err.Error(), http.StatusUnprocessableEntity)
+		return
+	}
+	digest, err := phase2.Digest(tmp.Name())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	// The contributor may have been skipped in the meantime
+	if len(s.state.Queue) == 0 || s.state.Queue[0].TokenHash != contributor.TokenHash {
+		http.Error(w, "you have been removed from the queue", http.StatusConflict)
+		return
+	}
+	// The upload was verified against the latest file, which must still be the latest one
+	if latest := s.state.circuit(name); len(latest.Contributions) != len(circuit.Contributions) || s.state.Queue[0].hasContributed(name) {
+		http.Error(w, fmt.Sprintf("%s has changed during the verification", name), http.StatusConflict)
+		return
+	}
+	receipt := Receipt{
+		Circuit:      name,
+		Contribution: len(circuit.Contributions) + 1,
+		Name:         contributor.Name,
+		File:         fileName(name, len(circuit.Contributions)+1),
+		Hash:         hash,
+		Digest:       hex.EncodeToString(digest),
+		Timestamp:    time.Now().UTC().Truncate(time.Second),
+	}
+	if err := receipt.sign(s.signer); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	contribution := Contribution{Name: receipt.Name, File: receipt.File, Hash: receipt.Hash}
+	contributionPath := filepath.Join(s.dir, contribution.File)
+	if err := os.Rename(tmp.Name(), contributionPath); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	err = s.commit(func(state *State) {
+		c := state.circuit(name)
+		c.Contributions = append(c.Contributions, contribution)
+		state.Queue[0].Done = append(state.Queue[0].Done, name)
+		if len(state.Queue[0].Done) == len(state.Circuits) {
+			state.Queue = state.Queue[1:]
+		}
+	})
+	if err != nil {
+		// The file isn't referenced by the state, which wasn't saved
+		os.Remove(contributionPath)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	fmt.Printf("Contribution %d of %s to %s has been accepted with Hash := %s\n", receipt.Contribution, contributor.Name, name, hash)
+	writeJSON(w, receipt)
+}
+
+// verifyUpload checks the upload from the origin, and that it has exactly one more contribution than the chain,
+// on top of the same ones. It returns the hash of the new contribution
+func verifyUpload(uploadPath string, circuit *Circuit) (string, error) {
+	if err := phase2.Verify(uploadPath, circuit.Origin); err != nil {
+		return "", err
+	}
+	_, contributions, err := phase2.ReadContributions(uploadPath)
+	if err != nil {
+		return "", err
+	}
+	if len(contributions) != len(circuit.Contributions)+1 {
+		return "", fmt.Errorf("expected %d contributions, got %d", len(circuit.Contributions)+1, len(contributions))
+	}
+	for i, c := range circuit.Contributions {
+		if hex.EncodeToString(contributions[i].Hash) != c.Hash {
+			return "", fmt.Errorf("contribution %d was replaced", i+1)
+		}
+	}
+	return hex.EncodeToString(contributions[len(circuit.Contributions)].Hash), nil
+}
@@ -0,0 +1,119 @@
+package coordinator
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+const stateFile = "state.json"
+
+// Contribution is an accepted contribution to the chain of a circuit
+type Contribution struct {
+	Name string `json:"name"`
+	File string `json:"file"`
+	Hash string `json:"hash"`
+}
+
+// Circuit is the chain of contributions of a circuit, starting from its origin
+type Circuit struct {
+	Name string `json:"name"`
+	// Phase 2 file from Initialize
+	Origin        string         `json:"origin"`
+	Contributions []Contribution `json:"contributions"`
+}
+
+// Contributor is a registered contributor waiting for their turn
+type Contributor struct {
+	Name string `json:"name"`
+	// SHA256 of the token of the contributor, the token itself isn't stored
+	TokenHash string `json:"tokenHash"`
+	// Circuits the contributor has contributed to during their turn
+	Done []string `json:"done,omitempty"`
+}
+
+// State of the ceremony, persisted in the state directory after every change
+type State struct {
+	Circuits []*Circuit `json:"circuits"`
+	// The first contributor of the queue is the current one, until they contributed to every circuit
+	Queue []Contributor `json:"queue"`
+}
+
+func hashToken(token string) string {
+	h := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(h[:])
+}
+
+// circuitName names a circuit after its initial phase 2 file, e.g. b1000t30 for b1000t30c0.ph2
+func circuitName(originPath string) string {
+	name := strings.TrimSuffix(filepath.Base(originPath), ".ph2")
+	return strings.TrimSuffix(name, "c0")
+}
+
+// fileName follows the naming of CEREMONY.md, so that the state directory can be audited with p2audit
+func fileName(circuit string, contribution int) string {
+	return fmt.Sprintf("%sc%d.ph2", circuit, contribution)
+}
+
+func loadState(dir string) (*State, error) {
+	b, err := os.ReadFile(filepath.Join(dir, stateFile))
+	if err != nil {
+		return nil, err
+	}
+	var state State
+	if err := json.Unmarshal(b, &state); err != nil {
+		return nil, err
+	}
+	return &state, nil
+}
+
+// save replaces the state file atomically, so that a crash never leaves a partial state
+func (s *State) save(dir string) error {
+	b, err := json.MarshalIndent(s, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp := filepath.Join(dir, stateFile+".tmp")
+	if err := os.WriteFile(tmp, append(b, '\n'), 0600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, filepath.Join(dir, stateFile))
+}
+
+// clone returns a deep copy of the state, to be modified and saved before it replaces the current one
+func (s *State) clone() *State {
+	var state State
+	b, _ := json.Marshal(s)
+	json.Unmarshal(b, &state)
+	return &state
+}
+
+func (s *State) circuit(name string) *Circuit {
+	for _, c := range s.Circuits {
+		if c.Name == name {
+			return c
+		}
+	}
+	return nil
+}
+
+// latest returns the path of the phase 2 file of the latest contribution, or the origin
+func (c *Circuit) latest(dir string) string {
+	if len(c.Contributions) == 0 {
+		return c.Origin
+	}
+	return filepath.Join(dir, c.Contributions[len(c.Contributions)-1].File)
+}
+
+func (c *Contributor) hasContributed(circuit string) bool {
+	for _, name := range c.Done {
+		if name == circuit {
+			return true
+		}
+	}
+	return false
+}
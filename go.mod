@@ -6,7 +6,9 @@ require (
 	github.com/consensys/gnark v0.8.0
 	github.com/consensys/gnark-crypto v0.9.1
 	github.com/urfave/cli/v2 v2.25.7
-	github.com/worldcoin/ptau-deserializer v0.1.3
+	golang.org/x/crypto v0.6.0
+	golang.org/x/term v0.5.0
+	gopkg.in/yaml.v3 v3.0.1
 )
 
 require (
@@ -22,7 +24,6 @@ require (
 	github.com/russross/blackfriday/v2 v2.1.0 // indirect
 	github.com/x448/float16 v0.8.4 // indirect
 	github.com/xrash/smetrics v0.0.0-20201216005158-039620a65673 // indirect
-	golang.org/x/crypto v0.6.0 // indirect
 	golang.org/x/sys v0.9.0 // indirect
 	rsc.io/tmplfunc v0.0.3 // indirect
 )
@@ -2,6 +2,7 @@ package keys
 
 import (
 	"bufio"
+	"errors"
 	"fmt"
 	"io"
 	"os"
@@ -12,6 +13,7 @@ import (
 	"github.com/consensys/gnark-crypto/ecc/bn254/fr/pedersen"
 	"github.com/consensys/gnark/backend/groth16"
 	"github.com/consensys/gnark/constraint"
+	"github.com/worldcoin/semaphore-mtb-setup/common"
 	"github.com/worldcoin/semaphore-mtb-setup/phase2"
 )
 
@@ -61,16 +63,16 @@ func (vk *VerifyingKey) writeTo(w io.Writer) (int64, error) {
 	return enc.BytesWritten(), nil
 }
 
-func extractPK(phase2Path string) error {
+func extractPK(phase2Path, evalsPath, pkPath string) error {
 	// Phase 2 file
-	phase2File, err := os.Open(phase2Path)
+	phase2File, err := phase2.OpenSection(phase2Path, phase2.SectionLatest)
 	if err != nil {
 		return err
 	}
 	defer phase2File.Close()
 
 	// Evaluations
-	evalsFile, err := os.Open("evals")
+	evalsFile, err := phase2.OpenSection(evalsPath, phase2.SectionEvals)
 	if err != nil {
 		return err
 	}
@@ -84,11 +86,14 @@ func extractPK(phase2Path string) error {
 	if err := header.Read(ph2Reader); err != nil {
 		return err
 	}
+	if _, err := checkEvals(evalsReader, &header); err != nil {
+		return err
+	}
 
 	decPh2 := bn254.NewDecoder(ph2Reader)
 	decEvals := bn254.NewDecoder(evalsReader)
 
-	pkFile, err := os.Create("pk")
+	pkFile, err := os.Create(pkPath)
 	if err != nil {
 		return err
 	}
@@ -228,17 +233,17 @@ func extractPK(phase2Path string) error {
 	return nil
 }
 
-func extractVK(phase2Path string) error {
+func extractVK(phase2Path, evalsPath, vkPath string) error {
 	vk := VerifyingKey{}
 	// Phase 2 file
-	phase2File, err := os.Open(phase2Path)
+	phase2File, err := phase2.OpenSection(phase2Path, phase2.SectionLatest)
 	if err != nil {
 		return err
 	}
 	defer phase2File.Close()
 
 	// Evaluations
-	evalsFile, err := os.Open("evals")
+	evalsFile, err := phase2.OpenSection(evalsPath, phase2.SectionEvals)
 	if err != nil {
 		return err
 	}
@@ -252,11 +257,15 @@ func extractVK(phase2Path string) error {
 	if err := header.Read(ph2Reader); err != nil {
 		return err
 	}
+	evalsHeaderSize, err := checkEvals(evalsReader, &header)
+	if err != nil {
+		return err
+	}
 
 	decPh2 := bn254.NewDecoder(ph2Reader)
 	decEvals := bn254.NewDecoder(evalsReader)
 
-	vkFile, err := os.Create("vk")
+	vkFile, err := os.Create(vkPath)
 	if err != nil {
 		return err
 	}
@@ -294,7 +303,7 @@ func extractVK(phase2Path string) error {
 	}
 
 	// 7. Read VKK
-	pos := int64(128*(header.Wires+1) + 12)
+	pos := evalsHeaderSize + int64(128*(header.Wires+1)+12)
 	if _, err := evalsFile.Seek(pos, io.SeekStart); err != nil {
 		return err
 	}
@@ -318,19 +327,59 @@ func extractVK(phase2Path string) error {
 	return nil
 }
 
-func ExtractKeys(phase2Path string) error {
+// ExtractKeys writes the proving and verifying keys of the last phase 2 file, from the evaluations of the initialization.
+// Both paths can be bundles, in which case their latest and evaluations sections are read
+func ExtractKeys(phase2Path, evalsPath, pkPath, vkPath string) error {
+	if err := checkDigest(phase2Path); err != nil {
+		return err
+	}
 	fmt.Println("Extracting proving key")
-	if err := extractPK(phase2Path); err != nil {
+	if err := extractPK(phase2Path, evalsPath, pkPath); err != nil {
 		return err
 	}
 	fmt.Println("Extracting verifying key")
-	if err := extractVK(phase2Path); err != nil {
+	if err := extractVK(phase2Path, evalsPath, vkPath); err != nil {
 		return err
 	}
 	fmt.Println("Keys have been extracted successfully")
 	return nil
 }
 
+// checkEvals checks the evaluations were computed from the same circuit and phase 1 file as phase 2,
+// and returns the size of their header
+func checkEvals(evalsReader *bufio.Reader, header *phase2.Header) (int64, error) {
+	evalsHeader, err := phase2.ReadEvalsHeader(evalsReader)
+	if err != nil {
+		return 0, err
+	}
+	if evalsHeader == nil && header.R1CSDigest == nil {
+		// Both files predate the digests
+		return 0, nil
+	}
+	if evalsHeader == nil || !evalsHeader.Matches(header) {
+		return 0, errors.New("evaluations weren't computed from the same circuit and phase 1 file as phase 2")
+	}
+	return int64(phase2.EvalsHeaderSize), nil
+}
+
+// checkDigest checks the digest of phase 2 files that end with one
+func checkDigest(phase2Path string) error {
+	phase2File, err := phase2.OpenSection(phase2Path, phase2.SectionLatest)
+	if err != nil {
+		return err
+	}
+	defer phase2File.Close()
+
+	var header phase2.Header
+	if err := header.Read(bufio.NewReader(phase2File)); err != nil {
+		return err
+	}
+	if !header.HasDigest() {
+		return nil
+	}
+	return common.CheckDigest(phase2File)
+}
+
 func ExportSol(session string) error {
 	filename := session + ".sol"
 	fmt.Printf("Exporting %s\n", filename)
@@ -7,6 +7,44 @@ import (
 	"github.com/urfave/cli/v2"
 )
 
+var entropyFlag = &cli.StringFlag{
+	Name:  "entropy",
+	Usage: "mix your own entropy into the toxic parameters, read from `SOURCE`: \"prompt\", \"-\" for stdin, or a file path",
+}
+
+// Only meant for reproducible test ceremonies, hence hidden
+var seedFlag = &cli.StringFlag{
+	Name:   "seed",
+	Usage:  "derive the toxic parameters deterministically from `SEED`, which makes them public",
+	Hidden: true,
+}
+
+// Directory of the artifacts of a circuit, so that several circuits can be set up side by side
+var dirFlag = &cli.StringFlag{
+	Name:  "dir",
+	Usage: "read and write srs.lag, evals, pk and vk in `DIR` instead of the working directory",
+}
+
+// Restricts the ceremony commands to some circuits of the manifest
+var circuitFlag = &cli.StringSliceFlag{
+	Name:  "circuit",
+	Usage: "only act on the circuit named `NAME` in the manifest, can be repeated",
+}
+
+// Shared by the coordinator and its admin commands
+var adminTokenFlag = &cli.StringFlag{
+	Name:     "admin-token",
+	Usage:    "`TOKEN` of the coordinator admin, who registers contributors",
+	EnvVars:  []string{"COORDINATOR_ADMIN_TOKEN"},
+	Required: true,
+}
+
+var coordinatorURLFlag = &cli.StringFlag{
+	Name:  "url",
+	Value: "http://localhost:8080",
+	Usage: "`URL` of the coordinator",
+}
+
 func main() {
 	app := &cli.App{
 		Name:      "setup",
@@ -14,6 +52,53 @@ func main() {
 		UsageText: "setup command [arguments...]",
 		Commands: []*cli.Command{
 
+			/* --------------------------- Phase 1 Initialize --------------------------- */
+			{
+				Name:        "p1n",
+				Usage:       "p1n <power> <outputPath>",
+				Description: "initialize phase 1 of parameters generation for Groth16",
+				Action:      p1n,
+			},
+			/* --------------------------- Phase 1 Contribute --------------------------- */
+			{
+				Name:        "p1c",
+				Usage:       "p1c [--verify [--origin <transformedPath>]] [--entropy <source>] <inputPath> <outputPath>",
+				Description: "contribute phase 1 randomness for Groth16",
+				Flags: []cli.Flag{
+					entropyFlag,
+					seedFlag,
+					&cli.BoolFlag{
+						Name:  "verify",
+						Usage: "verify the input file before contributing",
+					},
+					&cli.StringFlag{
+						Name:  "origin",
+						Usage: "transformed file the input file is verified against",
+					},
+				},
+				Action: p1c,
+			},
+			/* ----------------------------- Phase 1 Verify ----------------------------- */
+			{
+				Name:        "p1v",
+				Usage:       "p1v <inputPath>",
+				Description: "verify phase 1 contributions for Groth16",
+				Action:      p1v,
+			},
+			/* ------------------ Phase 1 Transform from PPoT Ceremony ------------------ */
+			{
+				Name:        "p1t",
+				Usage:       "p1t <inputPath> <outputPath> <originalPower> <reducedPower>",
+				Description: "transforms output of PPoT ceremony to be usable by semaphore-mtb-setup",
+				Action:      p1t,
+			},
+			/* ------------------ Phase 1 Verify from transformed file ------------------ */
+			{
+				Name:        "p1vt",
+				Usage:       "p1vt <inputPath> <transformedPath>",
+				Description: "verify phase 1 contributions for Groth16 based on transformed PPoT ceremony file",
+				Action:      p1vt,
+			},
 			/* ----------------------------- Phase 1 Import ----------------------------- */
 			{
 				Name:        "p1i",
@@ -21,33 +106,345 @@ func main() {
 				Description: "Deserialize snarkjs .ptau file into gnark's phase1 format and write to `OUTPUT`.ph1",
 				Action:      p1i,
 			},
+			/* ----------------------------- Phase 1 Export ----------------------------- */
+			{
+				Name:        "p1e",
+				Usage:       "p1e <inputPath> <outputPath>",
+				Description: "Serialize gnark's phase1 format into snarkjs .ptau file and write to `OUTPUT`.ptau",
+				Action:      p1e,
+			},
+			/* --------------------------- Phase 1 Verify PTAU -------------------------- */
+			{
+				Name:        "p1vp",
+				Usage:       "p1vp <inputPath>",
+				Description: "verify the chain of contributions embedded in a snarkjs .ptau file",
+				Action:      p1vp,
+			},
+			/* ---------------------------- Phase 1 Truncate ---------------------------- */
+			{
+				Name:        "p1r",
+				Usage:       "p1r <inputPath> <outputPath> <power>",
+				Description: "reduce the power of a phase1 file by keeping its first powers",
+				Action:      p1r,
+			},
 			/* --------------------------- Phase 2 Initialize --------------------------- */
 			{
 				Name:        "p2n",
-				Usage:       "p2n <phase1Path> <r1csPath> <phase2Path>",
+				Usage:       "p2n [--dir <dir>] [--lag <path>] [--evals <path>] <phase1Path> <r1csPath> <phase2Path> | p2n --check <phase2Path> [--lag <path>] [--evals <path>] [--skip-missing] <phase1Path> <r1csPath>",
 				Description: "initialize phase 2 for the given circuit",
-				Action:      p2n,
+				Flags: []cli.Flag{
+					dirFlag,
+					&cli.StringFlag{
+						Name:  "lag",
+						Usage: "write the Lagrange SRS to `PATH`, or with --check compare the one at PATH (defaults to srs.lag beside the phase 2 file)",
+					},
+					&cli.StringFlag{
+						Name:  "evals",
+						Usage: "write the evaluations to `PATH`, or with --check compare the ones at PATH (defaults to evals beside the phase 2 file)",
+					},
+					&cli.StringFlag{
+						Name:  "check",
+						Usage: "reproduce the initialization and compare it with the published phase 2 file at `PATH`",
+					},
+					&cli.BoolFlag{
+						Name:  "skip-missing",
+						Usage: "with --check, skip the comparison of srs.lag or evals if they aren't found",
+					},
+				},
+				Action: p2n,
 			},
 			/* --------------------------- Phase 2 Contribute --------------------------- */
 			{
 				Name:        "p2c",
-				Usage:       "p2c <inputPath> <outputPath>",
-				Description: "contribute phase 2 randomness for Groth16",
-				Action:      p2c,
+				Usage:       "p2c [--entropy <source>] [--name <name>] [--comment <comment>] [--sign-key <key>] <inputPath> <outputPath> | p2c --multi [--receipt <path>] [...] <inputPath>:<outputPath>...",
+				Description: "contribute phase 2 randomness for Groth16, \"-\" reads the input from stdin or writes the output to stdout",
+				Flags: []cli.Flag{
+					entropyFlag,
+					seedFlag,
+					&cli.StringFlag{
+						Name:  "name",
+						Usage: "record your `NAME` in the contribution",
+					},
+					&cli.StringFlag{
+						Name:  "comment",
+						Usage: "record a free-text `COMMENT` in the contribution",
+					},
+					&cli.StringFlag{
+						Name:  "sign-key",
+						Usage: "sign the contribution with the ed25519 SSH private key at `PATH`",
+					},
+					&cli.BoolFlag{
+						Name:  "multi",
+						Usage: "contribute to several files at once, given as <inputPath>:<outputPath> arguments",
+					},
+					&cli.StringFlag{
+						Name:  "receipt",
+						Usage: "with --multi, write the combined receipt of the contributions to `PATH`",
+					},
+				},
+				Action: p2c,
+			},
+			/* ----------------------------- Phase 2 Beacon ----------------------------- */
+			{
+				Name:        "p2b",
+				Usage:       "p2b <inputPath> <outputPath> <beaconHex> <iterations>",
+				Description: "close phase 2 with a contribution derived from a public random beacon",
+				Action:      p2b,
 			},
 			/* ----------------------------- Phase 2 Verify ----------------------------- */
 			{
 				Name:        "p2v",
-				Usage:       "p2v <inputPath> <originPath>",
+				Usage:       "p2v [--checkpoint <checkpointPath>] <inputPath> [<originPath>] | p2v --from-checkpoint <checkpointPath> [--checkpoint <nextCheckpointPath>] <inputPath>",
 				Description: "verify phase 2 contributions for Groth16",
-				Action:      p2v,
+				Flags: []cli.Flag{
+					&cli.StringFlag{
+						Name:  "checkpoint",
+						Usage: "write a verification checkpoint of the input to `PATH`",
+					},
+					&cli.StringFlag{
+						Name:  "from-checkpoint",
+						Usage: "verify the contributions made since the checkpoint at `PATH`, without the origin file",
+					},
+				},
+				Action: p2v,
+			},
+			/* ------------------------ Phase 2 Incremental Verify ---------------------- */
+			{
+				Name:        "p2vi",
+				Usage:       "p2vi <inputPath> <prevPath>",
+				Description: "verify a single new phase 2 contribution against the previous, already verified, file",
+				Action:      p2vi,
+			},
+			/* ------------------------------ Phase 2 Audit ----------------------------- */
+			{
+				Name:        "p2audit",
+				Usage:       "p2audit --origin <originPath> [--json <path>] [--markdown <path>] <dir>",
+				Description: "verify every numbered phase 2 file of a directory against its predecessor and write reports",
+				Flags: []cli.Flag{
+					&cli.StringFlag{
+						Name:     "origin",
+						Usage:    "initial phase 2 file at `PATH`, e.g. b1000t30c0.ph2",
+						Required: true,
+					},
+					&cli.StringFlag{
+						Name:  "json",
+						Value: "audit.json",
+						Usage: "write the JSON report to `PATH`",
+					},
+					&cli.StringFlag{
+						Name:  "markdown",
+						Value: "audit.md",
+						Usage: "write the Markdown report to `PATH`",
+					},
+				},
+				Action: p2audit,
+			},
+			/* ------------------------------ Phase 2 Hashes ---------------------------- */
+			{
+				Name:        "p2hashes",
+				Usage:       "p2hashes [--expected <hashesPath>] <inputPath>",
+				Description: "list the contribution hashes of a phase 2 file, or compare them with published ones",
+				Flags: []cli.Flag{
+					&cli.StringFlag{
+						Name:  "expected",
+						Usage: "compare with the hashes listed at `PATH`, one per line",
+					},
+				},
+				Action: p2hashes,
+			},
+			/* --------------------------- Phase 2 Attestation -------------------------- */
+			{
+				Name:        "p2attest",
+				Usage:       "p2attest --sign-key <key> <inputPath> <outputPath>",
+				Description: "sign the attestation of the last contribution of outputPath, made on top of inputPath",
+				Flags: []cli.Flag{
+					&cli.StringFlag{
+						Name:     "sign-key",
+						Usage:    "sign with the ed25519 SSH private key at `PATH`",
+						Required: true,
+					},
+				},
+				Action: p2attest,
+			},
+			{
+				Name:        "p2attest-verify",
+				Usage:       "p2attest-verify <inputPath> <signaturesDir> <knownKeysPath>",
+				Description: "verify the signed attestations of phase 2 contributions against known public keys",
+				Action:      p2attestVerify,
+			},
+			/* ----------------------------- Phase 2 Bundle ----------------------------- */
+			{
+				Name:        "p2bundle",
+				Usage:       "p2bundle [--latest <phase2Path>] <originPath> <evalsPath> <lagPath> <bundlePath>",
+				Description: "gather the state of the ceremony of a circuit into a single bundle file",
+				Flags: []cli.Flag{
+					&cli.StringFlag{
+						Name:  "latest",
+						Usage: "phase 2 file of the latest contribution at `PATH`, defaults to the origin",
+					},
+				},
+				Action: p2bundle,
+			},
+			{
+				Name:        "p2split",
+				Usage:       "p2split <bundlePath> <phase2Path>",
+				Description: "write the phase 2 file of the latest contribution of a bundle, to send to the next contributor",
+				Action:      p2split,
+			},
+			{
+				Name:        "p2rebuild",
+				Usage:       "p2rebuild <bundlePath> <phase2Path> <outputBundlePath>",
+				Description: "write a bundle whose latest contribution is the one of a phase 2 file",
+				Action:      p2rebuild,
+			},
+			/* -------------------------------- Ceremony -------------------------------- */
+			{
+				Name:        "ceremony",
+				Usage:       "ceremony init|contribute|verify|keys [--circuit <name>...] <manifestPath>",
+				Description: "run phase 2 on every circuit listed in a JSON or YAML manifest",
+				Subcommands: []*cli.Command{
+					{
+						Name:        "init",
+						Usage:       "init [--circuit <name>...] <manifestPath>",
+						Description: "initialize phase 2 of every circuit into its directory",
+						Flags:       []cli.Flag{circuitFlag},
+						Action:      ceremonyInit,
+					},
+					{
+						Name:        "contribute",
+						Usage:       "contribute [--circuit <name>...] [--entropy <source>] [--name <name>] [--comment <comment>] <manifestPath>",
+						Description: "contribute to the latest phase 2 file of every circuit",
+						Flags: []cli.Flag{
+							circuitFlag,
+							entropyFlag,
+							seedFlag,
+							&cli.StringFlag{
+								Name:  "name",
+								Usage: "record your `NAME` in the contributions",
+							},
+							&cli.StringFlag{
+								Name:  "comment",
+								Usage: "record a free-text `COMMENT` in the contributions",
+							},
+						},
+						Action: ceremonyContribute,
+					},
+					{
+						Name:        "verify",
+						Usage:       "verify [--circuit <name>...] <manifestPath>",
+						Description: "verify the latest phase 2 file of every circuit against its origin",
+						Flags:       []cli.Flag{circuitFlag},
+						Action:      ceremonyVerify,
+					},
+					{
+						Name:        "keys",
+						Usage:       "keys [--circuit <name>...] <manifestPath>",
+						Description: "extract the proving and verifying keys of every circuit",
+						Flags:       []cli.Flag{circuitFlag},
+						Action:      ceremonyKeys,
+					},
+				},
+			},
+			/* ------------------------------- Coordinator ------------------------------ */
+			{
+				Name:        "coordinator",
+				Usage:       "coordinator serve|register|skip",
+				Description: "run the contribution queue of a phase 2 ceremony over HTTP",
+				Subcommands: []*cli.Command{
+					{
+						Name:        "serve",
+						Usage:       "serve --dir <dir> [--origin <originPath>...] [--addr <addr>] --admin-token <token>",
+						Description: "serve the latest phase 2 file of each circuit to the current contributor and verify their uploads",
+						Flags: []cli.Flag{
+							&cli.StringFlag{
+								Name:     "dir",
+								Usage:    "keep the state and the contributions of the ceremony in `DIR`",
+								Required: true,
+							},
+							&cli.StringSliceFlag{
+								Name:  "origin",
+								Usage: "start the ceremony of the circuit whose initial phase 2 file is at `PATH`, e.g. b1000t30c0.ph2",
+							},
+							&cli.StringFlag{
+								Name:  "addr",
+								Value: ":8080",
+								Usage: "listen on `ADDR`",
+							},
+							adminTokenFlag,
+						},
+						Action: coordinatorServe,
+					},
+					{
+						Name:        "register",
+						Usage:       "register [--url <url>] --admin-token <token> <name>",
+						Description: "add a contributor to the queue and print their token",
+						Flags:       []cli.Flag{coordinatorURLFlag, adminTokenFlag},
+						Action:      coordinatorRegister,
+					},
+					{
+						Name:        "skip",
+						Usage:       "skip [--url <url>] --admin-token <token>",
+						Description: "remove the current contributor from the queue",
+						Flags:       []cli.Flag{coordinatorURLFlag, adminTokenFlag},
+						Action:      coordinatorSkip,
+					},
+				},
+			},
+			/* ---------------------------- Coordinator Client -------------------------- */
+			{
+				Name:        "contribute",
+				Usage:       "contribute --coordinator <url> --token <token> [--dir <dir>] [--entropy <source>] [--name <name>] [--comment <comment>]",
+				Description: "download, verify, contribute to and upload the latest phase 2 file of every circuit of a coordinator",
+				Flags: []cli.Flag{
+					&cli.StringFlag{
+						Name:     "coordinator",
+						Usage:    "`URL` of the coordinator",
+						Required: true,
+					},
+					&cli.StringFlag{
+						Name:     "token",
+						Usage:    "`TOKEN` given by the coordinator admin",
+						EnvVars:  []string{"COORDINATOR_TOKEN"},
+						Required: true,
+					},
+					&cli.StringFlag{
+						Name:  "dir",
+						Usage: "write the phase 2 files and receipts to `DIR` instead of the working directory",
+					},
+					entropyFlag,
+					seedFlag,
+					&cli.StringFlag{
+						Name:  "name",
+						Usage: "record your `NAME` in the contributions",
+					},
+					&cli.StringFlag{
+						Name:  "comment",
+						Usage: "record a free-text `COMMENT` in the contributions",
+					},
+				},
+				Action: contribute,
 			},
 			/* ----------------------------- Keys Extraction ---------------------------- */
 			{
 				Name:        "key",
-				Usage:       "key <inputPath>",
+				Usage:       "key [--dir <dir>] [--evals <path>] [--pk <path>] [--vk <path>] <inputPath>",
 				Description: "extract proving and verifying keys",
-				Action:      extract,
+				Flags: []cli.Flag{
+					dirFlag,
+					&cli.StringFlag{
+						Name:  "evals",
+						Usage: "read the evaluations of the initialization from `PATH`",
+					},
+					&cli.StringFlag{
+						Name:  "pk",
+						Usage: "write the proving key to `PATH`",
+					},
+					&cli.StringFlag{
+						Name:  "vk",
+						Usage: "write the verifying key to `PATH`",
+					},
+				},
+				Action: extract,
 			},
 			{
 				Name:        "sol",
@@ -55,44 +452,6 @@ func main() {
 				Description: "export verifier smart contract from verifying key",
 				Action:      exportSol,
 			},
-
-			// Unused since we use the powers of tau ceremony from PPoT
-			// /* --------------------------- Phase 1 Initialize --------------------------- */
-			// {
-			// 	Name:        "p1n",
-			// 	Usage:       "p1n <power> <outputPath>",
-			// 	Description: "initialize phase 1 of parameters generation for Groth16",
-			// 	Action:      p1n,
-			// },
-			// /* --------------------------- Phase 1 Contribute --------------------------- */
-			// {
-			// 	Name:        "p1c",
-			// 	Usage:       "p1c <inputPath> <outputPath>",
-			// 	Description: "contribute phase 1 randomness for Groth16",
-			// 	Action:      p1c,
-			// },
-
-			// /* ----------------------------- Phase 1 Verify ----------------------------- */
-			// {
-			// 	Name:        "p1v",
-			// 	Usage:       "p1v <inputPath>",
-			// 	Description: "verify phase 1 contributions for Groth16",
-			// 	Action:      p1v,
-			// },
-			// /* ------------------ Phase 1 Transform from PPoT Ceremony ------------------ */
-			// {
-			// 	Name:        "p1t",
-			// 	Usage:       "p1t <inputPath> <outputPath> <originalPower> <reducedPower>",
-			// 	Description: "transforms output of PPoT ceremony to be usable by semaphore-mtb-setup",
-			// 	Action:      p1t,
-			// },
-			// /* ------------------ Phase 1 Verify from transformed file ------------------ */
-			// {
-			// 	Name:        "p1vt",
-			// 	Usage:       "p1vt <inputPath> <transformedPath",
-			// 	Description: "verify phase 1 contributions for Groth16 based on transformed PPoT ceremony file",
-			// 	Action:      p1vt,
-			// },
 		},
 	}
 
@@ -78,7 +78,7 @@ func (c *Contribution) ReadFrom(reader io.Reader) (int64, error) {
 		}
 	}
 	c.Hash = make([]byte, 32)
-	nBytes, err := reader.Read(c.Hash)
+	nBytes, err := io.ReadFull(reader, c.Hash)
 	return int64(nBytes), err
 }
 
@@ -134,13 +134,13 @@ func defaultContribution(transformedPath string) (Contribution, error) {
 
 		// Read header
 		var header Header
-		if err := header.ReadFrom(inputFile); err != nil {
+		if _, err := header.ReadFrom(inputFile); err != nil {
 			return c, err
 		}
 
 		N := int(math.Pow(2, float64(header.Power)))
 
-		var posTauG1 int64 = 3 + G1CompressedSize
+		var posTauG1 int64 = header.Size() + G1CompressedSize
 		var posAlphaG1 int64 = posTauG1 + int64(2*N-2)*G1CompressedSize
 		var posBetaG1 int64 = posAlphaG1 + int64(N)*G1CompressedSize
 		var posTauG2 int64 = posBetaG1 + int64(N)*G1CompressedSize + G2CompressedSize
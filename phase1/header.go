@@ -2,32 +2,87 @@ package phase1
 
 import (
 	"encoding/binary"
+	"errors"
+	"fmt"
 	"io"
+
+	"github.com/consensys/gnark-crypto/ecc"
+)
+
+// Magic tag of .ph1 files, its first byte can't be mistaken for the power of a legacy header
+var magic = [4]byte{0x93, 'P', 'H', '1'}
+
+const (
+	// Legacy files only hold the power and #contributions, without magic, curve nor digest
+	legacyVersion byte = 0
+	// Files with magic, version, curve ID and a trailing SHA256 of the whole file
+	currentVersion byte = 1
+)
+
+const (
+	legacyHeaderSize  = 3
+	currentHeaderSize = len(magic) + 1 + 2 + legacyHeaderSize
 )
 
 type Header struct {
 	Power         byte
 	Contributions uint16
+
+	version byte
 }
 
-func (p *Header) ReadFrom(reader io.Reader) error {
+// ReadFrom returns the number of bytes of the header, as io.ReaderFrom
+func (p *Header) ReadFrom(reader io.Reader) (int64, error) {
 	buffPower := make([]byte, 1)
-	// Read NConstraints
-	if _, err := reader.Read(buffPower); err != nil {
-		return err
+	if _, err := io.ReadFull(reader, buffPower); err != nil {
+		return 0, err
+	}
+
+	p.version = legacyVersion
+	if buffPower[0] == magic[0] {
+		// Read the rest of magic, version, and curve ID
+		buff := make([]byte, currentHeaderSize-legacyHeaderSize-1)
+		if _, err := io.ReadFull(reader, buff); err != nil {
+			return 0, err
+		}
+		if [4]byte{buffPower[0], buff[0], buff[1], buff[2]} != magic {
+			return 0, errors.New("not a phase 1 file")
+		}
+		p.version = buff[3]
+		if p.version != currentVersion {
+			return 0, fmt.Errorf("unsupported phase 1 format version %d", p.version)
+		}
+		if curve := ecc.ID(binary.BigEndian.Uint16(buff[4:])); curve != ecc.BN254 {
+			return 0, fmt.Errorf("unsupported curve %s", curve)
+		}
+
+		// Read Power
+		if _, err := io.ReadFull(reader, buffPower); err != nil {
+			return 0, err
+		}
 	}
 	p.Power = buffPower[0]
 
 	// Read NContribution
 	buffContributions := make([]byte, 2)
-	if _, err := reader.Read(buffContributions); err != nil {
-		return err
+	if _, err := io.ReadFull(reader, buffContributions); err != nil {
+		return 0, err
 	}
 	p.Contributions = binary.BigEndian.Uint16(buffContributions)
-	return nil
+	return p.Size(), nil
 }
 
+// writeTo always writes the current version, whatever the version of the header read
 func (p *Header) writeTo(writer io.Writer) error {
+	// Write Magic, Version, and Curve ID
+	buff := make([]byte, currentHeaderSize-legacyHeaderSize)
+	copy(buff, magic[:])
+	buff[4] = currentVersion
+	binary.BigEndian.PutUint16(buff[5:], uint16(ecc.BN254))
+	if _, err := writer.Write(buff); err != nil {
+		return err
+	}
+
 	// Write Power
 	if _, err := writer.Write([]byte{p.Power}); err != nil {
 		return err
@@ -42,3 +97,16 @@ func (p *Header) writeTo(writer io.Writer) error {
 
 	return nil
 }
+
+// Size returns the number of bytes of the header in the file it was read from
+func (p *Header) Size() int64 {
+	if p.version == legacyVersion {
+		return legacyHeaderSize
+	}
+	return int64(currentHeaderSize)
+}
+
+// HasDigest reports whether the file the header was read from ends with a digest
+func (p *Header) HasDigest() bool {
+	return p.version != legacyVersion
+}
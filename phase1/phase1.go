@@ -5,12 +5,12 @@ import (
 	"encoding/hex"
 	"errors"
 	"fmt"
+	"io"
 	"math"
 	"math/big"
 	"os"
 
 	"github.com/consensys/gnark-crypto/ecc/bn254"
-	"github.com/consensys/gnark-crypto/ecc/bn254/fr"
 	"github.com/worldcoin/semaphore-mtb-setup/common"
 )
 
@@ -79,6 +79,95 @@ func Transform(inputPath, outputPath string, inPower, outPower byte) error {
 		return err
 	}
 
+	return common.AppendDigest(outputFile)
+}
+
+// Truncate reduces the power of a .ph1 file by keeping the first powers of each section.
+// Contributions are carried over since they only refer to the first powers.
+func Truncate(inputPath, outputPath string, outPower byte) error {
+	// Both files are in compressed representation
+	const G1Size = 32
+	const G2Size = 64
+
+	inputFile, err := os.Open(inputPath)
+	if err != nil {
+		return err
+	}
+	defer inputFile.Close()
+
+	outputFile, err := os.Create(outputPath)
+	if err != nil {
+		return err
+	}
+	defer outputFile.Close()
+
+	// Read/Write header with reduced power
+	var header Header
+	if _, err := header.ReadFrom(inputFile); err != nil {
+		return err
+	}
+	fmt.Printf("Power := %d and  #Contributions := %d\n", header.Power, header.Contributions)
+	if outPower > header.Power {
+		return errors.New("cannot truncate to a higher power")
+	}
+	if err := checkDigest(inputFile, &header); err != nil {
+		return err
+	}
+	inN := int(math.Pow(2, float64(header.Power)))
+	outN := int(math.Pow(2, float64(outPower)))
+	header.Power = outPower
+	if err := header.writeTo(outputFile); err != nil {
+		return err
+	}
+
+	var posTauG1 int64 = header.Size()
+	var posAlphaG1 int64 = posTauG1 + int64(2*inN-1)*G1Size
+	var posBetaG1 int64 = posAlphaG1 + int64(inN)*G1Size
+	var posTauG2 int64 = posBetaG1 + int64(inN)*G1Size
+	var posBetaG2 int64 = posTauG2 + int64(inN)*G2Size
+	var posContributions int64 = posBetaG2 + G2Size
+
+	// Points are copied as-is since the compressed representation has a fixed size
+	writer := bufio.NewWriter(outputFile)
+	defer writer.Flush()
+
+	fmt.Println("Truncating TauG1")
+	if err := copySection(inputFile, writer, posTauG1, int64(2*outN-1)*G1Size); err != nil {
+		return err
+	}
+
+	fmt.Println("Truncating AlphaTauG1")
+	if err := copySection(inputFile, writer, posAlphaG1, int64(outN)*G1Size); err != nil {
+		return err
+	}
+
+	fmt.Println("Truncating BetaTauG1")
+	if err := copySection(inputFile, writer, posBetaG1, int64(outN)*G1Size); err != nil {
+		return err
+	}
+
+	fmt.Println("Truncating TauG2")
+	if err := copySection(inputFile, writer, posTauG2, int64(outN)*G2Size); err != nil {
+		return err
+	}
+
+	fmt.Println("Copying BetaG2")
+	if err := copySection(inputFile, writer, posBetaG2, G2Size); err != nil {
+		return err
+	}
+
+	fmt.Println("Copying Contributions")
+	if err := copySection(inputFile, writer, posContributions, int64(header.Contributions)*ContributionSize); err != nil {
+		return err
+	}
+	if err := writer.Flush(); err != nil {
+		return err
+	}
+	if err := common.AppendDigest(outputFile); err != nil {
+		return err
+	}
+
+	fmt.Println("Truncation has been completed successfully")
 	return nil
 }
 
@@ -97,7 +186,9 @@ func Initialize(power byte, outputPath string) error {
 	fmt.Printf("Power %d supports up to %d constraints\n", power, N)
 
 	// Write the header
-	header.writeTo(outputFile)
+	if err := header.writeTo(outputFile); err != nil {
+		return err
+	}
 
 	// Use buffered IO to write parameters efficiently
 	buffSize := int(math.Pow(2, 20))
@@ -142,13 +233,35 @@ func Initialize(power byte, outputPath string) error {
 
 	// Write [β]₂
 	fmt.Println("5. Writing BetaG2")
-	enc.Encode(&g2)
+	if err := enc.Encode(&g2); err != nil {
+		return err
+	}
+	if err := writer.Flush(); err != nil {
+		return err
+	}
+	if err := common.AppendDigest(outputFile); err != nil {
+		return err
+	}
 
 	fmt.Println("Initialization has been completed successfully")
 	return nil
 }
 
 func Contribute(inputPath, outputPath string) error {
+	return ContributeWithEntropy(inputPath, outputPath, nil)
+}
+
+// ContributeWithEntropy samples toxic parameters from OS randomness mixed with the given entropy
+func ContributeWithEntropy(inputPath, outputPath string, entropy []byte) error {
+	rand, err := common.NewEntropyReader(entropy)
+	if err != nil {
+		return err
+	}
+	return ContributeWithRand(inputPath, outputPath, rand)
+}
+
+// ContributeWithRand reads toxic parameters and proofs of knowledge from the given source of randomness
+func ContributeWithRand(inputPath, outputPath string, rand io.Reader) error {
 	// Input file
 	inputFile, err := os.Open(inputPath)
 	if err != nil {
@@ -165,10 +278,13 @@ func Contribute(inputPath, outputPath string) error {
 
 	// Read/Write header with extra contribution
 	var header Header
-	if err := header.ReadFrom(inputFile); err != nil {
+	if _, err := header.ReadFrom(inputFile); err != nil {
 		return err
 	}
 	fmt.Printf("Power := %d and  #Contributions := %d\n", header.Power, header.Contributions)
+	if err := checkDigest(inputFile, &header); err != nil {
+		return err
+	}
 	N := int(math.Pow(2, float64(header.Power)))
 	header.Contributions++
 	if err := header.writeTo(outputFile); err != nil {
@@ -185,11 +301,18 @@ func Contribute(inputPath, outputPath string) error {
 
 	// Sample toxic parameters
 	fmt.Println("Sampling toxic parameters Tau, Alpha, and Beta")
-	var tau, alpha, beta, one fr.Element
-	tau.SetRandom()
-	alpha.SetRandom()
-	beta.SetRandom()
-	one.SetOne()
+	tau, err := common.SampleElement(rand)
+	if err != nil {
+		return err
+	}
+	alpha, err := common.SampleElement(rand)
+	if err != nil {
+		return err
+	}
+	beta, err := common.SampleElement(rand)
+	if err != nil {
+		return err
+	}
 
 	var contribution Contribution
 	var firstG1 *bn254.G1Affine
@@ -258,13 +381,27 @@ func Contribute(inputPath, outputPath string) error {
 	}
 
 	// Generate public keys
-	contribution.PublicKeys.Tau = common.GenPublicKey(tau, prevHash, 1)
-	contribution.PublicKeys.Alpha = common.GenPublicKey(alpha, prevHash, 2)
-	contribution.PublicKeys.Beta = common.GenPublicKey(beta, prevHash, 3)
+	if contribution.PublicKeys.Tau, err = common.GenPublicKey(tau, prevHash, 1, rand); err != nil {
+		return err
+	}
+	if contribution.PublicKeys.Alpha, err = common.GenPublicKey(alpha, prevHash, 2, rand); err != nil {
+		return err
+	}
+	if contribution.PublicKeys.Beta, err = common.GenPublicKey(beta, prevHash, 3, rand); err != nil {
+		return err
+	}
 	contribution.Hash = computeHash(&contribution)
 
 	// Write the contribution
-	contribution.writeTo(writer)
+	if _, err := contribution.writeTo(writer); err != nil {
+		return err
+	}
+	if err := writer.Flush(); err != nil {
+		return err
+	}
+	if err := common.AppendDigest(outputFile); err != nil {
+		return err
+	}
 
 	fmt.Println("Contirbution has been successful!")
 	fmt.Println("Contribution Hash := ", hex.EncodeToString(contribution.Hash))
@@ -282,10 +419,13 @@ func Verify(inputPath, transformedPath string) error {
 
 	// Read header
 	var header Header
-	if err := header.ReadFrom(inputFile); err != nil {
+	if _, err := header.ReadFrom(inputFile); err != nil {
 		return err
 	}
 	fmt.Printf("Power := %d and  #Contributions := %d\n", header.Power, header.Contributions)
+	if err := checkDigest(inputFile, &header); err != nil {
+		return err
+	}
 	N := int(math.Pow(2, float64(header.Power)))
 
 	// Use buffered IO to write parameters efficiently
@@ -324,13 +464,16 @@ func Verify(inputPath, transformedPath string) error {
 	}
 
 	// Verify contributions
-	var current Contribution
 	prev, err := defaultContribution(transformedPath)
 	if err != nil {
 		return err
 	}
+	// Without contributions, parameters must match the origin
+	current := prev
 	for i := 0; i < int(header.Contributions); i++ {
-		current.ReadFrom(reader)
+		if _, err := current.ReadFrom(reader); err != nil {
+			return err
+		}
 		fmt.Printf("Verifying contribution %d with Hash := %s\n", i+1, hex.EncodeToString(current.Hash))
 		if err := verifyContribution(current, prev); err != nil {
 			return err
@@ -360,7 +503,7 @@ func Verify(inputPath, transformedPath string) error {
 
 	// Read and verify TauG2
 	fmt.Println("Verifying powers of TauG2")
-	if !common.SameRatio(g1, current.G1.Tau, tau2L1, tau2L2) {
+	if !common.SameRatio(current.G1.Tau, g1, tau2L1, tau2L2) {
 		return errors.New("failed pairing check")
 	}
 
@@ -0,0 +1,1018 @@
+package phase1
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"math"
+	"math/big"
+	"sync/atomic"
+
+	"github.com/consensys/gnark-crypto/ecc/bn254"
+	"github.com/consensys/gnark-crypto/ecc/bn254/fp"
+	"github.com/worldcoin/semaphore-mtb-setup/common"
+	"golang.org/x/crypto/blake2b"
+)
+
+// Sections of snarkjs .ptau files
+// https://github.com/iden3/snarkjs/blob/master/src/powersoftau_new.js
+const (
+	ptauHeaderSection        = 1
+	ptauTauG1Section         = 2
+	ptauTauG2Section         = 3
+	ptauAlphaTauG1Section    = 4
+	ptauBetaTauG1Section     = 5
+	ptauBetaG2Section        = 6
+	ptauContributionsSection = 7
+)
+
+// Points in .ptau files are uncompressed with coordinates in little-endian Montgomery form
+const (
+	ptauG1Size = 64
+	ptauG2Size = 128
+)
+
+// Sizes of the hashes recorded by snarkjs for every contribution
+const (
+	ptauPartialHashSize   = 216
+	ptauChallengeHashSize = 64
+)
+
+type ptauSection struct {
+	pos  int64
+	size int64
+}
+
+type ptauHeader struct {
+	Power         uint32
+	CeremonyPower uint32
+}
+
+// Types of the contributions recorded by snarkjs
+const (
+	ptauContributionType = 0
+	ptauBeaconType       = 1
+)
+
+// Contribution as recorded by snarkjs, including the ones imported from PPoT
+type ptauContribution struct {
+	Contribution
+	PartialHash []byte
+	// Hash of the response of the contributor, which is the previous challenge, the points
+	// and the public keys of the contribution, resumed from the partial hash of the record
+	ResponseHash  []byte
+	NextChallenge []byte
+	Type          uint32
+	Name          string
+	// Parameters of a beacon contribution
+	NumIterationsExp byte
+	BeaconHash       []byte
+}
+
+// ImportPtau streams a snarkjs .ptau file into the .ph1 format
+// The sections are visited in the order of the .ph1 layout, one batch of points at a time
+func ImportPtau(reader io.ReadSeeker, writer io.Writer) error {
+	sections, err := readPtauSections(reader)
+	if err != nil {
+		return err
+	}
+	ptauHeader, err := readPtauHeader(reader, sections)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Power := %d and CeremonyPower := %d\n", ptauHeader.Power, ptauHeader.CeremonyPower)
+	N := int(math.Pow(2, float64(ptauHeader.Power)))
+
+	// The PPoT contributions aren't representable as .ph1 contributions,
+	// so the imported file is an origin the same way as a transformed one
+	// The digest trailing the file is computed along the way
+	sha := sha256.New()
+	output := writer
+	writer = io.MultiWriter(output, sha)
+	header := Header{Power: byte(ptauHeader.Power), Contributions: 0}
+	if err := header.writeTo(writer); err != nil {
+		return err
+	}
+	enc := bn254.NewEncoder(writer)
+
+	fmt.Println("Importing TauG1")
+	if err := importG1(reader, sections, ptauTauG1Section, enc, 2*N-1); err != nil {
+		return err
+	}
+
+	fmt.Println("Importing AlphaTauG1")
+	if err := importG1(reader, sections, ptauAlphaTauG1Section, enc, N); err != nil {
+		return err
+	}
+
+	fmt.Println("Importing BetaTauG1")
+	if err := importG1(reader, sections, ptauBetaTauG1Section, enc, N); err != nil {
+		return err
+	}
+
+	fmt.Println("Importing TauG2")
+	if err := importG2(reader, sections, ptauTauG2Section, enc, N); err != nil {
+		return err
+	}
+
+	fmt.Println("Importing BetaG2")
+	if err := importG2(reader, sections, ptauBetaG2Section, enc, 1); err != nil {
+		return err
+	}
+	if _, err := output.Write(sha.Sum(nil)); err != nil {
+		return err
+	}
+
+	fmt.Println("Import has been completed successfully")
+	return nil
+}
+
+// ExportPtau writes the parameters and the contributions of a .ph1 file as a snarkjs .ptau file
+func ExportPtau(reader io.ReadSeeker, writer io.Writer) error {
+	// Read header
+	var header Header
+	if _, err := reader.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	if _, err := header.ReadFrom(reader); err != nil {
+		return err
+	}
+	fmt.Printf("Power := %d and  #Contributions := %d\n", header.Power, header.Contributions)
+	N := int(math.Pow(2, float64(header.Power)))
+
+	const G1CompressedSize = 32
+	const G2CompressedSize = 64
+	var posTauG1 int64 = header.Size()
+	var posAlphaG1 int64 = posTauG1 + int64(2*N-1)*G1CompressedSize
+	var posBetaG1 int64 = posAlphaG1 + int64(N)*G1CompressedSize
+	var posTauG2 int64 = posBetaG1 + int64(N)*G1CompressedSize
+	var posBetaG2 int64 = posTauG2 + int64(N)*G2CompressedSize
+	var posContributions int64 = posBetaG2 + G2CompressedSize
+
+	// Read contributions
+	if _, err := reader.Seek(posContributions, io.SeekStart); err != nil {
+		return err
+	}
+	contributions := make([]ptauContribution, header.Contributions)
+	contributionsReader := bufio.NewReader(reader)
+	for i := range contributions {
+		if _, err := contributions[i].ReadFrom(contributionsReader); err != nil {
+			return err
+		}
+	}
+	nextChallenge, err := chainPtauContributions(contributions, uint32(header.Power))
+	if err != nil {
+		return err
+	}
+
+	// Write magic, version and #Sections
+	if _, err := writer.Write([]byte("ptau")); err != nil {
+		return err
+	}
+	if err := writeULE32(writer, 1); err != nil {
+		return err
+	}
+	if err := writeULE32(writer, ptauContributionsSection); err != nil {
+		return err
+	}
+
+	// Write header section as n8, q, power, ceremonyPower
+	if err := writePtauSectionHeader(writer, ptauHeaderSection, 4+fp.Bytes+8); err != nil {
+		return err
+	}
+	if err := writeULE32(writer, fp.Bytes); err != nil {
+		return err
+	}
+	q := make([]byte, fp.Bytes)
+	fp.Modulus().FillBytes(q)
+	if _, err := writer.Write(reverse(q)); err != nil {
+		return err
+	}
+	if err := writeULE32(writer, uint32(header.Power)); err != nil {
+		return err
+	}
+	if err := writeULE32(writer, uint32(header.Power)); err != nil {
+		return err
+	}
+
+	fmt.Println("Exporting TauG1")
+	if err := exportG1(reader, writer, nextChallenge, ptauTauG1Section, posTauG1, 2*N-1); err != nil {
+		return err
+	}
+
+	fmt.Println("Exporting TauG2")
+	if err := exportG2(reader, writer, nextChallenge, ptauTauG2Section, posTauG2, N); err != nil {
+		return err
+	}
+
+	fmt.Println("Exporting AlphaTauG1")
+	if err := exportG1(reader, writer, nextChallenge, ptauAlphaTauG1Section, posAlphaG1, N); err != nil {
+		return err
+	}
+
+	fmt.Println("Exporting BetaTauG1")
+	if err := exportG1(reader, writer, nextChallenge, ptauBetaTauG1Section, posBetaG1, N); err != nil {
+		return err
+	}
+
+	fmt.Println("Exporting BetaG2")
+	if err := exportG2(reader, writer, nextChallenge, ptauBetaG2Section, posBetaG2, 1); err != nil {
+		return err
+	}
+
+	fmt.Println("Exporting contributions")
+	if len(contributions) > 0 {
+		contributions[len(contributions)-1].NextChallenge = nextChallenge.Sum(nil)
+	}
+	if err := writePtauSectionHeader(writer, ptauContributionsSection, 4+int64(len(contributions))*ptauContributionSize); err != nil {
+		return err
+	}
+	if err := writeULE32(writer, uint32(len(contributions))); err != nil {
+		return err
+	}
+	for i := range contributions {
+		if err := writePtauContribution(writer, &contributions[i]); err != nil {
+			return err
+		}
+	}
+
+	fmt.Println("Export has been completed successfully")
+	return nil
+}
+
+// chainPtauContributions fills the partial hashes and the challenges of the records of gnark's contributions.
+// The points of the file after each contribution are gone, so the response hashes the contribution hash
+// in their place, starting from the challenge of the initial accumulator as in snarkjs. The challenge
+// following a contribution is the hash of its response, except for the last one, which also hashes
+// the points of the exported file: the returned hash is left for them to be written to
+func chainPtauContributions(contributions []ptauContribution, power uint32) (hash.Hash, error) {
+	challenge := firstChallengeHash(power)
+	next, _ := blake2b.New512(nil)
+	for i := range contributions {
+		c := &contributions[i]
+		h, _ := blake2b.New512(nil)
+		h.Write(challenge)
+		h.Write(c.Hash)
+		partialHash, err := partialHashFromBlake2b(h)
+		if err != nil {
+			return nil, err
+		}
+		c.PartialHash = partialHash
+		h.Write(ptauPublicKeys(&c.Contribution))
+		c.ResponseHash = h.Sum(nil)
+
+		next.Reset()
+		next.Write(c.ResponseHash)
+		challenge = next.Sum(nil)
+		c.NextChallenge = challenge
+	}
+	return next, nil
+}
+
+// VerifyPtau verifies the chain of contributions recorded in a snarkjs .ptau file,
+// and that the parameters of the file are the outcome of the last contribution
+func VerifyPtau(reader io.ReadSeeker) error {
+	sections, err := readPtauSections(reader)
+	if err != nil {
+		return err
+	}
+	ptauHeader, err := readPtauHeader(reader, sections)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Power := %d and CeremonyPower := %d\n", ptauHeader.Power, ptauHeader.CeremonyPower)
+	N := int(math.Pow(2, float64(ptauHeader.Power)))
+
+	contributions, err := readPtauContributions(reader, sections)
+	if err != nil {
+		return err
+	}
+	if len(contributions) == 0 {
+		return errors.New("there are no contributions to verify")
+	}
+
+	// Initial accumulator has τ = α = β = 1
+	fmt.Println("Computing the challenge of the first contribution")
+	_, _, g1, g2 := bn254.Generators()
+	var prev ptauContribution
+	prev.G1.Tau.Set(&g1)
+	prev.G1.Alpha.Set(&g1)
+	prev.G1.Beta.Set(&g1)
+	prev.G2.Tau.Set(&g2)
+	prev.G2.Beta.Set(&g2)
+	prev.NextChallenge = firstChallengeHash(ptauHeader.CeremonyPower)
+
+	// The challenge following the last contribution is the hash of its response and of the parameters,
+	// which ties the chain of contributions to the points of the file. It can only be recomputed
+	// at the power of the ceremony, as the points of a smaller file are only part of the hashed ones
+	last := contributions[len(contributions)-1]
+	if ptauHeader.Power == ptauHeader.CeremonyPower {
+		fmt.Println("Verifying the challenge following the last contribution")
+		h, _ := blake2b.New512(nil)
+		h.Write(last.ResponseHash)
+		if err := hashPtauSections(h, reader, sections, N); err != nil {
+			return err
+		}
+		if !bytes.Equal(h.Sum(nil), last.NextChallenge) {
+			return errors.New("parameters don't match the challenge following the last contribution")
+		}
+	} else {
+		fmt.Println("Power is smaller than the power of the ceremony, skipping the challenge following the last contribution")
+	}
+
+	// Verify contributions
+	fmt.Printf("#Contributions := %d\n", len(contributions))
+	for i := range contributions {
+		current := &contributions[i]
+		fmt.Printf("Verifying contribution %d %q with Challenge := %s\n", i+1, current.Name, hex.EncodeToString(prev.NextChallenge))
+		if err := verifyPtauContribution(current, &prev); err != nil {
+			return fmt.Errorf("contribution %d: %w", i+1, err)
+		}
+		fmt.Printf("Response := %s\n", hex.EncodeToString(current.ResponseHash))
+		prev = *current
+	}
+
+	// Verify the parameters start with the points of the last contribution
+	fmt.Println("Verifying first points of parameters")
+	firstTauG1, err := readPtauG1(reader, sections, ptauTauG1Section, 2*N-1, 2)
+	if err != nil {
+		return err
+	}
+	firstTauG2, err := readPtauG2(reader, sections, ptauTauG2Section, N, 2)
+	if err != nil {
+		return err
+	}
+	firstAlphaTauG1, err := readPtauG1(reader, sections, ptauAlphaTauG1Section, N, 1)
+	if err != nil {
+		return err
+	}
+	firstBetaTauG1, err := readPtauG1(reader, sections, ptauBetaTauG1Section, N, 1)
+	if err != nil {
+		return err
+	}
+	betaG2, err := readPtauG2(reader, sections, ptauBetaG2Section, 1, 1)
+	if err != nil {
+		return err
+	}
+	if !firstTauG1[0].Equal(&g1) || !firstTauG1[1].Equal(&last.G1.Tau) {
+		return errors.New("TauG1 doesn't match the last contribution")
+	}
+	if !firstTauG2[0].Equal(&g2) || !firstTauG2[1].Equal(&last.G2.Tau) {
+		return errors.New("TauG2 doesn't match the last contribution")
+	}
+	if !firstAlphaTauG1[0].Equal(&last.G1.Alpha) {
+		return errors.New("AlphaTauG1 doesn't match the last contribution")
+	}
+	if !firstBetaTauG1[0].Equal(&last.G1.Beta) {
+		return errors.New("BetaTauG1 doesn't match the last contribution")
+	}
+	if !betaG2[0].Equal(&last.G2.Beta) {
+		return errors.New("BetaG2 doesn't match the last contribution")
+	}
+
+	// Verify consistency of the powers
+	fmt.Println("Verifying powers of TauG1")
+	sectionReader, err := seekPtauSection(reader, sections, ptauTauG1Section, int64(2*N-1)*ptauG1Size)
+	if err != nil {
+		return err
+	}
+	tau1L1, tau1L2, err := linearCombinationG1From(ptauG1Reader(sectionReader), 2*N-1)
+	if err != nil {
+		return err
+	}
+	if !common.SameRatio(tau1L1, tau1L2, last.G2.Tau, g2) {
+		return errors.New("failed pairing check")
+	}
+
+	fmt.Println("Verifying powers of AlphaTauG1")
+	if sectionReader, err = seekPtauSection(reader, sections, ptauAlphaTauG1Section, int64(N)*ptauG1Size); err != nil {
+		return err
+	}
+	alphaTau1L1, alphaTau1L2, err := linearCombinationG1From(ptauG1Reader(sectionReader), N)
+	if err != nil {
+		return err
+	}
+	if !common.SameRatio(alphaTau1L1, alphaTau1L2, last.G2.Tau, g2) {
+		return errors.New("failed pairing check")
+	}
+
+	fmt.Println("Verifying powers of BetaTauG1")
+	if sectionReader, err = seekPtauSection(reader, sections, ptauBetaTauG1Section, int64(N)*ptauG1Size); err != nil {
+		return err
+	}
+	betaTau1L1, betaTau1L2, err := linearCombinationG1From(ptauG1Reader(sectionReader), N)
+	if err != nil {
+		return err
+	}
+	if !common.SameRatio(betaTau1L1, betaTau1L2, last.G2.Tau, g2) {
+		return errors.New("failed pairing check")
+	}
+
+	fmt.Println("Verifying powers of TauG2")
+	if sectionReader, err = seekPtauSection(reader, sections, ptauTauG2Section, int64(N)*ptauG2Size); err != nil {
+		return err
+	}
+	tau2L1, tau2L2, err := linearCombinationG2From(ptauG2Reader(sectionReader), N)
+	if err != nil {
+		return err
+	}
+	if !common.SameRatio(last.G1.Tau, g1, tau2L1, tau2L2) {
+		return errors.New("failed pairing check")
+	}
+
+	fmt.Println("Contributions verification has been successful")
+	return nil
+}
+
+// Same checks as snarkjs, where SP is derived from the challenge of the previous contribution
+func verifyPtauContribution(current, prev *ptauContribution) error {
+	switch current.Type {
+	case ptauContributionType:
+	case ptauBeaconType:
+		// Keys of a beacon contribution must be the ones derived from the beacon
+		if current.BeaconHash == nil {
+			return errors.New("beacon contribution has no beacon hash")
+		}
+		tau, alpha, beta, err := ptauBeaconKeys(prev.NextChallenge, current.BeaconHash, current.NumIterationsExp)
+		if err != nil {
+			return err
+		}
+		if !current.PublicKeys.Tau.S.Equal(&tau.S) || !current.PublicKeys.Tau.SX.Equal(&tau.SX) || !current.PublicKeys.Tau.SPX.Equal(&tau.SPX) ||
+			!current.PublicKeys.Alpha.S.Equal(&alpha.S) || !current.PublicKeys.Alpha.SX.Equal(&alpha.SX) || !current.PublicKeys.Alpha.SPX.Equal(&alpha.SPX) ||
+			!current.PublicKeys.Beta.S.Equal(&beta.S) || !current.PublicKeys.Beta.SX.Equal(&beta.SX) || !current.PublicKeys.Beta.SPX.Equal(&beta.SPX) {
+			return errors.New("keys of the beacon contribution aren't derived from the beacon")
+		}
+	default:
+		return fmt.Errorf("unknown contribution type %d", current.Type)
+	}
+
+	tauSP := getG2SP(ptauTauPersonalization, prev.NextChallenge, &current.PublicKeys.Tau.S, &current.PublicKeys.Tau.SX)
+	alphaSP := getG2SP(ptauAlphaPersonalization, prev.NextChallenge, &current.PublicKeys.Alpha.S, &current.PublicKeys.Alpha.SX)
+	betaSP := getG2SP(ptauBetaPersonalization, prev.NextChallenge, &current.PublicKeys.Beta.S, &current.PublicKeys.Beta.SX)
+
+	// Check for knowledge of toxic parameters
+	if !common.SameRatio(current.PublicKeys.Tau.S, current.PublicKeys.Tau.SX, current.PublicKeys.Tau.SPX, tauSP) {
+		return errors.New("couldn't verify knowledge of Tau")
+	}
+	if !common.SameRatio(current.PublicKeys.Alpha.S, current.PublicKeys.Alpha.SX, current.PublicKeys.Alpha.SPX, alphaSP) {
+		return errors.New("couldn't verify knowledge of Alpha")
+	}
+	if !common.SameRatio(current.PublicKeys.Beta.S, current.PublicKeys.Beta.SX, current.PublicKeys.Beta.SPX, betaSP) {
+		return errors.New("couldn't verify knowledge of Beta")
+	}
+
+	// Check for valid updates using previous parameters
+	if !common.SameRatio(prev.G1.Tau, current.G1.Tau, current.PublicKeys.Tau.SPX, tauSP) {
+		return errors.New("couldn't verify that TauG1 is based on previous contribution")
+	}
+	if !common.SameRatio(current.PublicKeys.Tau.S, current.PublicKeys.Tau.SX, current.G2.Tau, prev.G2.Tau) {
+		return errors.New("couldn't verify that TauG2 is based on previous contribution")
+	}
+	if !common.SameRatio(prev.G1.Alpha, current.G1.Alpha, current.PublicKeys.Alpha.SPX, alphaSP) {
+		return errors.New("couldn't verify that AlphaTauG1 is based on previous contribution")
+	}
+	if !common.SameRatio(prev.G1.Beta, current.G1.Beta, current.PublicKeys.Beta.SPX, betaSP) {
+		return errors.New("couldn't verify that BetaTauG1 is based on previous contribution")
+	}
+	if !common.SameRatio(current.PublicKeys.Beta.S, current.PublicKeys.Beta.SX, current.G2.Beta, prev.G2.Beta) {
+		return errors.New("couldn't verify that BetaG2 is based on previous contribution")
+	}
+	return nil
+}
+
+func readPtauContributions(reader io.ReadSeeker, sections map[uint32]ptauSection) ([]ptauContribution, error) {
+	section, ok := sections[ptauContributionsSection]
+	if !ok {
+		return nil, errors.New("missing contributions section")
+	}
+	if _, err := reader.Seek(section.pos, io.SeekStart); err != nil {
+		return nil, err
+	}
+	sectionReader := bufio.NewReader(io.LimitReader(reader, section.size))
+
+	buff := make([]byte, 4)
+	if _, err := io.ReadFull(sectionReader, buff); err != nil {
+		return nil, err
+	}
+	// The records must fit in the section, the bytes left over being their parameters
+	n := int64(binary.LittleEndian.Uint32(buff))
+	paramsSize := section.size - 4 - n*ptauContributionSize
+	if paramsSize < 0 {
+		return nil, fmt.Errorf("contributions section is too small for %d contributions", n)
+	}
+	contributions := make([]ptauContribution, n)
+	for i := range contributions {
+		if err := readPtauContribution(sectionReader, &contributions[i], &paramsSize); err != nil {
+			return nil, fmt.Errorf("contribution %d: %w", i+1, err)
+		}
+	}
+	return contributions, nil
+}
+
+// readPtauContribution reads a record, whose parameters take at most paramsSize bytes
+func readPtauContribution(reader io.Reader, c *ptauContribution, paramsSize *int64) error {
+	buff := make([]byte, ptauContributionSize)
+	if _, err := io.ReadFull(reader, buff); err != nil {
+		return err
+	}
+	pos := 0
+	g1 := func(p *bn254.G1Affine) bool {
+		pos += ptauG1Size
+		return g1FromPtau(p, buff[pos-ptauG1Size:])
+	}
+	g2 := func(p *bn254.G2Affine) bool {
+		pos += ptauG2Size
+		return g2FromPtau(p, buff[pos-ptauG2Size:])
+	}
+	if !(g1(&c.G1.Tau) && g2(&c.G2.Tau) && g1(&c.G1.Alpha) && g1(&c.G1.Beta) && g2(&c.G2.Beta) &&
+		g1(&c.PublicKeys.Tau.S) && g1(&c.PublicKeys.Tau.SX) &&
+		g1(&c.PublicKeys.Alpha.S) && g1(&c.PublicKeys.Alpha.SX) &&
+		g1(&c.PublicKeys.Beta.S) && g1(&c.PublicKeys.Beta.SX) &&
+		g2(&c.PublicKeys.Tau.SPX) && g2(&c.PublicKeys.Alpha.SPX) && g2(&c.PublicKeys.Beta.SPX)) {
+		return errors.New("contribution has a point which isn't on the curve")
+	}
+	// The response hash covers the public keys in the uncompressed encoding of the challenge
+	c.PartialHash = append([]byte{}, buff[pos:pos+ptauPartialHashSize]...)
+	h, err := blake2bFromPartialHash(c.PartialHash)
+	if err != nil {
+		return err
+	}
+	h.Write(ptauPublicKeys(&c.Contribution))
+	c.ResponseHash = h.Sum(nil)
+	pos += ptauPartialHashSize
+	c.NextChallenge = append([]byte{}, buff[pos:pos+ptauChallengeHashSize]...)
+	pos += ptauChallengeHashSize
+	c.Type = binary.LittleEndian.Uint32(buff[pos:])
+	pos += 4
+
+	size := int64(binary.LittleEndian.Uint32(buff[pos:]))
+	if size > *paramsSize {
+		return errors.New("parameters exceed the contributions section")
+	}
+	*paramsSize -= size
+	params := make([]byte, size)
+	if _, err := io.ReadFull(reader, params); err != nil {
+		return err
+	}
+
+	// Parameters are sorted by type as name(1), numIterationsExp(2), beaconHash(3)
+	var last byte
+	for i := 0; i < len(params); {
+		if params[i] <= last || i+1 >= len(params) {
+			return errors.New("contribution has invalid parameters")
+		}
+		last = params[i]
+		switch params[i] {
+		case 1, 3:
+			size := int(params[i+1])
+			if i+2+size > len(params) {
+				return errors.New("contribution has invalid parameters")
+			}
+			if params[i] == 1 {
+				c.Name = string(params[i+2 : i+2+size])
+			} else {
+				c.BeaconHash = append([]byte{}, params[i+2:i+2+size]...)
+			}
+			i += 2 + size
+		case 2:
+			c.NumIterationsExp = params[i+1]
+			i += 2
+		default:
+			return errors.New("contribution has unknown parameters")
+		}
+	}
+	return nil
+}
+
+func readPtauSections(reader io.ReadSeeker) (map[uint32]ptauSection, error) {
+	if _, err := reader.Seek(0, io.SeekStart); err != nil {
+		return nil, err
+	}
+
+	// Read magic, version and #Sections
+	buff := make([]byte, 12)
+	if _, err := io.ReadFull(reader, buff); err != nil {
+		return nil, err
+	}
+	if string(buff[:4]) != "ptau" {
+		return nil, errors.New("input isn't a snarkjs .ptau file")
+	}
+	nSections := binary.LittleEndian.Uint32(buff[8:])
+
+	// Index the sections by skipping over their content
+	sections := make(map[uint32]ptauSection)
+	for i := uint32(0); i < nSections; i++ {
+		if _, err := io.ReadFull(reader, buff); err != nil {
+			return nil, err
+		}
+		id := binary.LittleEndian.Uint32(buff[:4])
+		size := int64(binary.LittleEndian.Uint64(buff[4:]))
+		if _, ok := sections[id]; ok {
+			return nil, fmt.Errorf("section %d has more than one segment", id)
+		}
+		pos, err := reader.Seek(size, io.SeekCurrent)
+		if err != nil {
+			return nil, err
+		}
+		sections[id] = ptauSection{pos: pos - size, size: size}
+	}
+	return sections, nil
+}
+
+func readPtauHeader(reader io.ReadSeeker, sections map[uint32]ptauSection) (ptauHeader, error) {
+	var header ptauHeader
+	section, ok := sections[ptauHeaderSection]
+	if !ok {
+		return header, errors.New("missing header section")
+	}
+	// n8, q, power, ceremonyPower
+	if section.size != 4+fp.Bytes+8 {
+		return header, errors.New("unsupported field size in .ptau header")
+	}
+	if _, err := reader.Seek(section.pos, io.SeekStart); err != nil {
+		return header, err
+	}
+	buff := make([]byte, section.size)
+	if _, err := io.ReadFull(reader, buff); err != nil {
+		return header, err
+	}
+	if binary.LittleEndian.Uint32(buff) != fp.Bytes {
+		return header, errors.New("unsupported field size in .ptau header")
+	}
+	var q big.Int
+	q.SetBytes(reverse(buff[4 : 4+fp.Bytes]))
+	if q.Cmp(fp.Modulus()) != 0 {
+		return header, errors.New(".ptau file isn't defined over bn254")
+	}
+	header.Power = binary.LittleEndian.Uint32(buff[4+fp.Bytes:])
+	header.CeremonyPower = binary.LittleEndian.Uint32(buff[8+fp.Bytes:])
+	return header, nil
+}
+
+// Seek to the beginning of a section after checking it holds exactly size bytes
+func seekPtauSection(reader io.ReadSeeker, sections map[uint32]ptauSection, id uint32, size int64) (*bufio.Reader, error) {
+	section, ok := sections[id]
+	if !ok {
+		return nil, fmt.Errorf("missing section %d", id)
+	}
+	if section.size != size {
+		return nil, fmt.Errorf("section %d has %d bytes, expected %d", id, section.size, size)
+	}
+	if _, err := reader.Seek(section.pos, io.SeekStart); err != nil {
+		return nil, err
+	}
+	return bufio.NewReaderSize(reader, int(math.Pow(2, 20))), nil
+}
+
+func importG1(reader io.ReadSeeker, sections map[uint32]ptauSection, id uint32, enc *bn254.Encoder, N int) error {
+	sectionReader, err := seekPtauSection(reader, sections, id, int64(N)*ptauG1Size)
+	if err != nil {
+		return err
+	}
+	read := ptauG1Reader(sectionReader)
+
+	// Allocate batch with smallest of (N, batchSize)
+	var initialSize = int(math.Min(float64(N), float64(batchSize)))
+	buff := make([]bn254.G1Affine, initialSize)
+
+	remaining := N
+	for remaining > 0 {
+		// Read batch
+		readCount := int(math.Min(float64(remaining), float64(batchSize)))
+		if err := read(buff[:readCount]); err != nil {
+			return fmt.Errorf("section %d: %w", id, err)
+		}
+
+		// Write the batch
+		for i := 0; i < readCount; i++ {
+			if err := enc.Encode(&buff[i]); err != nil {
+				return err
+			}
+		}
+
+		// Update remaining
+		remaining -= readCount
+	}
+	return nil
+}
+
+func importG2(reader io.ReadSeeker, sections map[uint32]ptauSection, id uint32, enc *bn254.Encoder, N int) error {
+	sectionReader, err := seekPtauSection(reader, sections, id, int64(N)*ptauG2Size)
+	if err != nil {
+		return err
+	}
+	read := ptauG2Reader(sectionReader)
+
+	// Allocate batch with smallest of (N, batchSize)
+	var initialSize = int(math.Min(float64(N), float64(batchSize)))
+	buff := make([]bn254.G2Affine, initialSize)
+
+	remaining := N
+	for remaining > 0 {
+		// Read batch
+		readCount := int(math.Min(float64(remaining), float64(batchSize)))
+		if err := read(buff[:readCount]); err != nil {
+			return fmt.Errorf("section %d: %w", id, err)
+		}
+
+		// Write the batch
+		for i := 0; i < readCount; i++ {
+			if err := enc.Encode(&buff[i]); err != nil {
+				return err
+			}
+		}
+
+		// Update remaining
+		remaining -= readCount
+	}
+	return nil
+}
+
+// Read the first n points of a section holding N points
+func readPtauG1(reader io.ReadSeeker, sections map[uint32]ptauSection, id uint32, N, n int) ([]bn254.G1Affine, error) {
+	sectionReader, err := seekPtauSection(reader, sections, id, int64(N)*ptauG1Size)
+	if err != nil {
+		return nil, err
+	}
+	buff := make([]bn254.G1Affine, n)
+	return buff, ptauG1Reader(sectionReader)(buff)
+}
+
+func readPtauG2(reader io.ReadSeeker, sections map[uint32]ptauSection, id uint32, N, n int) ([]bn254.G2Affine, error) {
+	sectionReader, err := seekPtauSection(reader, sections, id, int64(N)*ptauG2Size)
+	if err != nil {
+		return nil, err
+	}
+	buff := make([]bn254.G2Affine, n)
+	return buff, ptauG2Reader(sectionReader)(buff)
+}
+
+// hashPtauSections writes the points of the file to h in the order and the uncompressed encoding snarkjs hashes them
+func hashPtauSections(h io.Writer, reader io.ReadSeeker, sections map[uint32]ptauSection, N int) error {
+	for _, section := range []struct {
+		id        uint32
+		n, size   int
+		transform func(out, in []byte)
+	}{
+		{ptauTauG1Section, 2*N - 1, ptauG1Size, uncompressedG1FromPtau},
+		{ptauTauG2Section, N, ptauG2Size, uncompressedG2FromPtau},
+		{ptauAlphaTauG1Section, N, ptauG1Size, uncompressedG1FromPtau},
+		{ptauBetaTauG1Section, N, ptauG1Size, uncompressedG1FromPtau},
+		{ptauBetaG2Section, 1, ptauG2Size, uncompressedG2FromPtau},
+	} {
+		sectionReader, err := seekPtauSection(reader, sections, section.id, int64(section.n)*int64(section.size))
+		if err != nil {
+			return err
+		}
+		batch := int(math.Min(float64(section.n), float64(batchSize)))
+		raw := make([]byte, batch*section.size)
+		out := make([]byte, batch*section.size)
+		for remaining := section.n; remaining > 0; remaining -= batch {
+			batch = int(math.Min(float64(remaining), float64(batchSize)))
+			if _, err := io.ReadFull(sectionReader, raw[:batch*section.size]); err != nil {
+				return err
+			}
+			size, transform := section.size, section.transform
+			common.Parallelize(batch, func(start, end int) {
+				for i := start; i < end; i++ {
+					transform(out[i*size:(i+1)*size], raw[i*size:])
+				}
+			})
+			if _, err := h.Write(out[:batch*section.size]); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func uncompressedG1FromPtau(out, in []byte) {
+	var p bn254.G1Affine
+	elementFromPtau(&p.X, in[:32])
+	elementFromPtau(&p.Y, in[32:64])
+	copy(out, ptauUncompressedG1(&p))
+}
+
+func uncompressedG2FromPtau(out, in []byte) {
+	var p bn254.G2Affine
+	elementFromPtau(&p.X.A0, in[:32])
+	elementFromPtau(&p.X.A1, in[32:64])
+	elementFromPtau(&p.Y.A0, in[64:96])
+	elementFromPtau(&p.Y.A1, in[96:128])
+	copy(out, ptauUncompressedG2(&p))
+}
+
+// Returns a function filling batches with the next points of a section, converted in parallel
+func ptauG1Reader(reader io.Reader) func([]bn254.G1Affine) error {
+	var raw []byte
+	return func(buff []bn254.G1Affine) error {
+		if len(raw) < len(buff)*ptauG1Size {
+			raw = make([]byte, len(buff)*ptauG1Size)
+		}
+		if _, err := io.ReadFull(reader, raw[:len(buff)*ptauG1Size]); err != nil {
+			return err
+		}
+		var invalid atomic.Bool
+		common.Parallelize(len(buff), func(start, end int) {
+			for i := start; i < end; i++ {
+				if !g1FromPtau(&buff[i], raw[i*ptauG1Size:]) {
+					invalid.Store(true)
+				}
+			}
+		})
+		if invalid.Load() {
+			return errors.New("point isn't on the curve")
+		}
+		return nil
+	}
+}
+
+func ptauG2Reader(reader io.Reader) func([]bn254.G2Affine) error {
+	var raw []byte
+	return func(buff []bn254.G2Affine) error {
+		if len(raw) < len(buff)*ptauG2Size {
+			raw = make([]byte, len(buff)*ptauG2Size)
+		}
+		if _, err := io.ReadFull(reader, raw[:len(buff)*ptauG2Size]); err != nil {
+			return err
+		}
+		var invalid atomic.Bool
+		common.Parallelize(len(buff), func(start, end int) {
+			for i := start; i < end; i++ {
+				if !g2FromPtau(&buff[i], raw[i*ptauG2Size:]) {
+					invalid.Store(true)
+				}
+			}
+		})
+		if invalid.Load() {
+			return errors.New("point isn't in the subgroup")
+		}
+		return nil
+	}
+}
+
+// Points are also written to h in the encoding snarkjs hashes them
+func exportG1(reader io.ReadSeeker, writer, h io.Writer, id uint32, position int64, N int) error {
+	if _, err := reader.Seek(position, io.SeekStart); err != nil {
+		return err
+	}
+	dec := bn254.NewDecoder(bufio.NewReaderSize(reader, int(math.Pow(2, 20))))
+	if err := writePtauSectionHeader(writer, id, int64(N)*ptauG1Size); err != nil {
+		return err
+	}
+
+	var g1 bn254.G1Affine
+	raw := make([]byte, ptauG1Size)
+	for i := 0; i < N; i++ {
+		if err := dec.Decode(&g1); err != nil {
+			return err
+		}
+		g1ToPtau(raw, &g1)
+		if _, err := writer.Write(raw); err != nil {
+			return err
+		}
+		if _, err := h.Write(ptauUncompressedG1(&g1)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func exportG2(reader io.ReadSeeker, writer, h io.Writer, id uint32, position int64, N int) error {
+	if _, err := reader.Seek(position, io.SeekStart); err != nil {
+		return err
+	}
+	dec := bn254.NewDecoder(bufio.NewReaderSize(reader, int(math.Pow(2, 20))))
+	if err := writePtauSectionHeader(writer, id, int64(N)*ptauG2Size); err != nil {
+		return err
+	}
+
+	var g2 bn254.G2Affine
+	raw := make([]byte, ptauG2Size)
+	for i := 0; i < N; i++ {
+		if err := dec.Decode(&g2); err != nil {
+			return err
+		}
+		g2ToPtau(raw, &g2)
+		if _, err := writer.Write(raw); err != nil {
+			return err
+		}
+		if _, err := h.Write(ptauUncompressedG2(&g2)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Contribution record of snarkjs as
+// [τ]₁, [τ]₂, [α]₁, [β]₁, [β]₂, {sτ₁, sxτ₁, sα₁, sxα₁, sβ₁, sxβ₁, spxτ₂, spxα₂, spxβ₂},
+// partialHash, nextChallenge, type and parameters
+const ptauContributionSize = 3*ptauG1Size + 2*ptauG2Size +
+	6*ptauG1Size + 3*ptauG2Size +
+	ptauPartialHashSize + ptauChallengeHashSize + 4 + 4
+
+// writePtauContribution writes a record without parameters
+func writePtauContribution(writer io.Writer, c *ptauContribution) error {
+	buff := make([]byte, ptauContributionSize)
+	pos := 0
+	g1 := func(p *bn254.G1Affine) {
+		g1ToPtau(buff[pos:], p)
+		pos += ptauG1Size
+	}
+	g2 := func(p *bn254.G2Affine) {
+		g2ToPtau(buff[pos:], p)
+		pos += ptauG2Size
+	}
+	g1(&c.G1.Tau)
+	g2(&c.G2.Tau)
+	g1(&c.G1.Alpha)
+	g1(&c.G1.Beta)
+	g2(&c.G2.Beta)
+	g1(&c.PublicKeys.Tau.S)
+	g1(&c.PublicKeys.Tau.SX)
+	g1(&c.PublicKeys.Alpha.S)
+	g1(&c.PublicKeys.Alpha.SX)
+	g1(&c.PublicKeys.Beta.S)
+	g1(&c.PublicKeys.Beta.SX)
+	g2(&c.PublicKeys.Tau.SPX)
+	g2(&c.PublicKeys.Alpha.SPX)
+	g2(&c.PublicKeys.Beta.SPX)
+	pos += copy(buff[pos:], c.PartialHash)
+	pos += copy(buff[pos:], c.NextChallenge)
+	binary.LittleEndian.PutUint32(buff[pos:], c.Type)
+	_, err := writer.Write(buff)
+	return err
+}
+
+func writePtauSectionHeader(writer io.Writer, id uint32, size int64) error {
+	if err := writeULE32(writer, id); err != nil {
+		return err
+	}
+	buff := make([]byte, 8)
+	binary.LittleEndian.PutUint64(buff, uint64(size))
+	_, err := writer.Write(buff)
+	return err
+}
+
+func writeULE32(writer io.Writer, v uint32) error {
+	buff := make([]byte, 4)
+	binary.LittleEndian.PutUint32(buff, v)
+	_, err := writer.Write(buff)
+	return err
+}
+
+// Montgomery limbs are stored as-is in little-endian order
+func elementFromPtau(e *fp.Element, b []byte) {
+	for i := 0; i < len(e); i++ {
+		e[i] = binary.LittleEndian.Uint64(b[8*i:])
+	}
+}
+
+// Point at infinity is encoded as zero coordinates in both formats
+// G1 has a cofactor of 1, being on the curve implies being in the subgroup
+func g1FromPtau(p *bn254.G1Affine, b []byte) bool {
+	elementFromPtau(&p.X, b[:32])
+	elementFromPtau(&p.Y, b[32:64])
+	return p.IsOnCurve()
+}
+
+func g2FromPtau(p *bn254.G2Affine, b []byte) bool {
+	elementFromPtau(&p.X.A0, b[:32])
+	elementFromPtau(&p.X.A1, b[32:64])
+	elementFromPtau(&p.Y.A0, b[64:96])
+	elementFromPtau(&p.Y.A1, b[96:128])
+	// G2 has a cofactor, so points of untrusted files must be checked against the subgroup
+	return p.IsInSubGroup()
+}
+
+func elementToPtau(b []byte, e *fp.Element) {
+	for i := 0; i < len(e); i++ {
+		binary.LittleEndian.PutUint64(b[8*i:], e[i])
+	}
+}
+
+func g1ToPtau(b []byte, p *bn254.G1Affine) {
+	elementToPtau(b[:32], &p.X)
+	elementToPtau(b[32:64], &p.Y)
+}
+
+func g2ToPtau(b []byte, p *bn254.G2Affine) {
+	elementToPtau(b[:32], &p.X.A0)
+	elementToPtau(b[32:64], &p.X.A1)
+	elementToPtau(b[64:96], &p.Y.A0)
+	elementToPtau(b[96:128], &p.Y.A1)
+}
+
+func reverse(b []byte) []byte {
+	r := make([]byte, len(b))
+	for i := range b {
+		r[len(b)-1-i] = b[i]
+	}
+	return r
+}
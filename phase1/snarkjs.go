@@ -0,0 +1,349 @@
+package phase1
+
+import (
+	"crypto/sha256"
+	"encoding"
+	"encoding/binary"
+	"errors"
+	"hash"
+	"math/big"
+
+	"github.com/consensys/gnark-crypto/ecc/bn254"
+	"github.com/consensys/gnark-crypto/ecc/bn254/fp"
+	"github.com/consensys/gnark-crypto/ecc/bn254/fr"
+	"github.com/worldcoin/semaphore-mtb-setup/common"
+	"golang.org/x/crypto/blake2b"
+	"golang.org/x/crypto/chacha20"
+)
+
+// Proofs of knowledge of snarkjs (and of the PPoT ceremony it imported) use SP in G₂ as
+// HashToG2(Blake2b(personalization, challenge, [s]₁, [sx]₁)), where HashToG2 samples
+// a point from a ChaCha20 stream seeded with the hash, then clears the cofactor
+
+// Cofactor of G₂ = 2q - r
+var g2Cofactor, _ = new(big.Int).SetString("21888242871839275222246405745257275088844257914179612981679871602714643921549", 10)
+
+// Personalization of τ, α, β in snarkjs
+const (
+	ptauTauPersonalization   = 0
+	ptauAlphaPersonalization = 1
+	ptauBetaPersonalization  = 2
+)
+
+type chachaRng struct {
+	cipher *chacha20.Cipher
+	buff   [4]byte
+}
+
+// The seed is read as big-endian words, which are the key words of ChaCha20 with zero nonce and counter
+func newChachaRng(seed []byte) *chachaRng {
+	key := make([]byte, chacha20.KeySize)
+	for i := 0; i < 8; i++ {
+		binary.LittleEndian.PutUint32(key[4*i:], binary.BigEndian.Uint32(seed[4*i:]))
+	}
+	cipher, err := chacha20.NewUnauthenticatedCipher(key, make([]byte, chacha20.NonceSize))
+	if err != nil {
+		panic(err)
+	}
+	return &chachaRng{cipher: cipher}
+}
+
+func (r *chachaRng) nextU32() uint32 {
+	r.buff = [4]byte{}
+	r.cipher.XORKeyStream(r.buff[:], r.buff[:])
+	return binary.LittleEndian.Uint32(r.buff[:])
+}
+
+// The first word is the most significant one
+func (r *chachaRng) nextU64() uint64 {
+	hi := uint64(r.nextU32())
+	lo := uint64(r.nextU32())
+	return hi<<32 | lo
+}
+
+func (r *chachaRng) nextBool() bool {
+	return r.nextU32()&1 == 1
+}
+
+// Limbs are sampled in Montgomery form, and rejected above the modulus
+func (r *chachaRng) nextElement(e *fp.Element) {
+	for {
+		for i := 0; i < len(e); i++ {
+			e[i] = r.nextU64()
+		}
+		e[3] &= 0xffffffffffffffff >> 2
+		if isReduced(e) {
+			return
+		}
+	}
+}
+
+// Scalars are sampled the same way, below r
+func (r *chachaRng) nextScalar(e *fr.Element) {
+	for {
+		for i := 0; i < len(e); i++ {
+			e[i] = r.nextU64()
+		}
+		e[3] &= 0xffffffffffffffff >> 2
+		if isBelow(e[:], rLimbs[:]) {
+			return
+		}
+	}
+}
+
+// Points of G₁ are sampled as points of G₂ in hashToG2, G₁ having no cofactor
+func (r *chachaRng) nextG1() bn254.G1Affine {
+	var p bn254.G1Affine
+	var b, x3b fp.Element
+	b.SetUint64(3)
+	var greatest bool
+	for {
+		r.nextElement(&p.X)
+		greatest = r.nextBool()
+		x3b.Square(&p.X).Mul(&x3b, &p.X).Add(&x3b, &b)
+		if x3b.Legendre() == 1 {
+			break
+		}
+	}
+	p.Y.Sqrt(&x3b)
+	if greatest != p.Y.LexicographicallyLargest() {
+		p.Y.Neg(&p.Y)
+	}
+	return p
+}
+
+// Limbs of a modulus, least significant first
+func modulusLimbs(modulus *big.Int) (limbs [4]uint64) {
+	var buff [32]byte
+	modulus.FillBytes(buff[:])
+	for i := 0; i < len(limbs); i++ {
+		limbs[i] = binary.BigEndian.Uint64(buff[32-8*(i+1):])
+	}
+	return
+}
+
+var (
+	qLimbs = modulusLimbs(fp.Modulus())
+	rLimbs = modulusLimbs(fr.Modulus())
+)
+
+func isReduced(e *fp.Element) bool {
+	return isBelow(e[:], qLimbs[:])
+}
+
+func isBelow(e, modulus []uint64) bool {
+	for i := len(modulus) - 1; i >= 0; i-- {
+		if e[i] != modulus[i] {
+			return e[i] < modulus[i]
+		}
+	}
+	return false
+}
+
+func hashToG2(hash []byte) bn254.G2Affine {
+	rng := newChachaRng(hash)
+	var p bn254.G2Affine
+
+	// b' = 3/(9+u)
+	bTwist := p.X
+	bTwist.SetOne()
+	bTwist.MulBybTwistCurveCoeff(&bTwist)
+
+	var greatest bool
+	x3b := p.X
+	for {
+		rng.nextElement(&p.X.A0)
+		rng.nextElement(&p.X.A1)
+		greatest = rng.nextBool()
+		x3b.Square(&p.X).Mul(&x3b, &p.X).Add(&x3b, &bTwist)
+		if x3b.Legendre() == 1 {
+			break
+		}
+	}
+	p.Y.Sqrt(&x3b)
+
+	// Pick the root that is the greatest one when greatest is set,
+	// comparing the imaginary part first
+	var largest bool
+	if p.Y.A1.IsZero() {
+		largest = p.Y.A0.LexicographicallyLargest()
+	} else {
+		largest = p.Y.A1.LexicographicallyLargest()
+	}
+	if greatest != largest {
+		p.Y.Neg(&p.Y)
+	}
+
+	// Clear the cofactor by plain double and add, since GLV only holds in the subgroup
+	var base, res bn254.G2Jac
+	base.FromAffine(&p)
+	for i := g2Cofactor.BitLen() - 1; i >= 0; i-- {
+		res.DoubleAssign()
+		if g2Cofactor.Bit(i) == 1 {
+			res.AddAssign(&base)
+		}
+	}
+	p.FromJacobian(&res)
+	return p
+}
+
+func getG2SP(personalization byte, challenge []byte, s, sx *bn254.G1Affine) bn254.G2Affine {
+	h, _ := blake2b.New512(nil)
+	h.Write([]byte{personalization})
+	h.Write(challenge)
+	h.Write(ptauUncompressedG1(s))
+	h.Write(ptauUncompressedG1(sx))
+	return hashToG2(h.Sum(nil))
+}
+
+// Challenge of the first contribution, as the hash of the initial accumulator
+// of the ceremony with τ = α = β = 1
+func firstChallengeHash(ceremonyPower uint32) []byte {
+	_, _, g1, g2 := bn254.Generators()
+	vG1 := ptauUncompressedG1(&g1)
+	vG2 := ptauUncompressedG2(&g2)
+
+	h, _ := blake2b.New512(nil)
+	empty := blake2b.Sum512(nil)
+	h.Write(empty[:])
+
+	N := uint64(1) << ceremonyPower
+	hashBlock := func(point []byte, n uint64) {
+		const blockSize = 1 << 14
+		block := make([]byte, 0, blockSize*len(point))
+		for i := 0; i < blockSize; i++ {
+			block = append(block, point...)
+		}
+		for ; n >= blockSize; n -= blockSize {
+			h.Write(block)
+		}
+		h.Write(block[:n*uint64(len(point))])
+	}
+	hashBlock(vG1, 2*N-1)
+	hashBlock(vG2, N)
+	hashBlock(vG1, N)
+	hashBlock(vG1, N)
+	h.Write(vG2)
+	return h.Sum(nil)
+}
+
+// Uncompressed big-endian encoding of the PPoT ceremony, where infinity is flagged in the first byte
+func ptauUncompressedG1(p *bn254.G1Affine) []byte {
+	if p.IsInfinity() {
+		res := make([]byte, bn254.SizeOfG1AffineUncompressed)
+		res[0] = 1 << 6
+		return res
+	}
+	res := p.RawBytes()
+	return res[:]
+}
+
+func ptauUncompressedG2(p *bn254.G2Affine) []byte {
+	if p.IsInfinity() {
+		res := make([]byte, bn254.SizeOfG2AffineUncompressed)
+		res[0] = 1 << 6
+		return res
+	}
+	res := p.RawBytes()
+	return res[:]
+}
+
+// Keys of a beacon contribution are sampled as snarkjs does, from a ChaCha20 stream seeded with
+// the beacon hashed 2^numIterationsExp times with SHA256: τ, α and β first, then s of each key
+func ptauBeaconKeys(challenge, beaconHash []byte, numIterationsExp byte) (tau, alpha, beta common.PublicKey, err error) {
+	if numIterationsExp > 63 {
+		return tau, alpha, beta, errors.New("beacon has too many iterations")
+	}
+	digest := beaconHash
+	for i := uint64(0); i < uint64(1)<<numIterationsExp; i++ {
+		sum := sha256.Sum256(digest)
+		digest = sum[:]
+	}
+	rng := newChachaRng(digest)
+
+	var x [3]fr.Element
+	for i := range x {
+		rng.nextScalar(&x[i])
+	}
+	key := func(personalization byte, x *fr.Element) common.PublicKey {
+		var k common.PublicKey
+		var xBi big.Int
+		x.BigInt(&xBi)
+		k.S = rng.nextG1()
+		k.SX.ScalarMultiplication(&k.S, &xBi)
+		sp := getG2SP(personalization, challenge, &k.S, &k.SX)
+		k.SPX.ScalarMultiplication(&sp, &xBi)
+		return k
+	}
+	tau = key(ptauTauPersonalization, &x[0])
+	alpha = key(ptauAlphaPersonalization, &x[1])
+	beta = key(ptauBetaPersonalization, &x[2])
+	return tau, alpha, beta, nil
+}
+
+// snarkjs records the state of Blake2b-512 as laid out by blake2b-wasm, in little-endian words:
+// the buffered block, h, the counter t of the compressed bytes and the length of the buffered block
+const (
+	partialHashH = 128
+	partialHashT = 192
+	partialHashC = 208
+)
+
+// blake2bFromPartialHash resumes the Blake2b-512 state recorded by snarkjs
+func blake2bFromPartialHash(partialHash []byte) (hash.Hash, error) {
+	if len(partialHash) != ptauPartialHashSize {
+		return nil, errors.New("invalid partial hash size")
+	}
+	c := binary.LittleEndian.Uint32(partialHash[partialHashC:])
+	if c > blake2b.BlockSize {
+		return nil, errors.New("invalid partial hash")
+	}
+
+	// State marshalled by golang.org/x/crypto/blake2b as magic, h, t, size, block and length of the block
+	state := []byte("b2b")
+	for i := 0; i < 8; i++ {
+		state = binary.BigEndian.AppendUint64(state, binary.LittleEndian.Uint64(partialHash[partialHashH+8*i:]))
+	}
+	state = binary.BigEndian.AppendUint64(state, binary.LittleEndian.Uint64(partialHash[partialHashT:]))
+	state = binary.BigEndian.AppendUint64(state, binary.LittleEndian.Uint64(partialHash[partialHashT+8:]))
+	state = append(state, blake2b.Size)
+	state = append(state, partialHash[:blake2b.BlockSize]...)
+	state = append(state, byte(c))
+
+	h, _ := blake2b.New512(nil)
+	if err := h.(encoding.BinaryUnmarshaler).UnmarshalBinary(state); err != nil {
+		return nil, err
+	}
+	return h, nil
+}
+
+// partialHashFromBlake2b records a Blake2b-512 state the way snarkjs does
+func partialHashFromBlake2b(h hash.Hash) ([]byte, error) {
+	state, err := h.(encoding.BinaryMarshaler).MarshalBinary()
+	if err != nil {
+		return nil, err
+	}
+	state = state[len("b2b"):]
+	partialHash := make([]byte, ptauPartialHashSize)
+	for i := 0; i < 8; i++ {
+		binary.LittleEndian.PutUint64(partialHash[partialHashH+8*i:], binary.BigEndian.Uint64(state[8*i:]))
+	}
+	binary.LittleEndian.PutUint64(partialHash[partialHashT:], binary.BigEndian.Uint64(state[64:]))
+	binary.LittleEndian.PutUint64(partialHash[partialHashT+8:], binary.BigEndian.Uint64(state[72:]))
+	copy(partialHash, state[81:81+blake2b.BlockSize])
+	binary.LittleEndian.PutUint32(partialHash[partialHashC:], uint32(state[81+blake2b.BlockSize]))
+	return partialHash, nil
+}
+
+// ptauPublicKeys encodes the keys of a contribution as snarkjs hashes them in its response
+func ptauPublicKeys(c *Contribution) []byte {
+	var b []byte
+	for _, k := range []*common.PublicKey{&c.PublicKeys.Tau, &c.PublicKeys.Alpha, &c.PublicKeys.Beta} {
+		b = append(b, ptauUncompressedG1(&k.S)...)
+		b = append(b, ptauUncompressedG1(&k.SX)...)
+	}
+	for _, k := range []*common.PublicKey{&c.PublicKeys.Tau, &c.PublicKeys.Alpha, &c.PublicKeys.Beta} {
+		b = append(b, ptauUncompressedG2(&k.SPX)...)
+	}
+	return b
+}
@@ -162,30 +162,51 @@ func randomize(r []fr.Element) {
 }
 
 func linearCombinationG1(dec *bn254.Decoder, N int) (bn254.G1Affine, bn254.G1Affine, error) {
-	// Allocate batch with smallest of (N, batchSize)
-	var initialSize = int(math.Min(float64(N), float64(batchSize)))
-	buff := make([]bn254.G1Affine, initialSize)
+	return linearCombinationG1From(func(buff []bn254.G1Affine) error {
+		for i := range buff {
+			if err := dec.Decode(&buff[i]); err != nil {
+				return err
+			}
+		}
+		return nil
+	}, N)
+}
+
+// Computes L₁ = ∑ rᵢPᵢ and L₂ = ∑ rᵢPᵢ₊₁ for random rᵢ, so that L₂ = τL₁ iff Pᵢ₊₁ = τPᵢ
+// The last point of a batch is carried over as the first point of the next batch
+func linearCombinationG1From(read func([]bn254.G1Affine) error, N int) (bn254.G1Affine, bn254.G1Affine, error) {
+	// Allocate batch with smallest of (N-1, batchSize)
+	var initialSize = int(math.Min(float64(N-1), float64(batchSize)))
+	buff := make([]bn254.G1Affine, initialSize+1)
 	r := make([]fr.Element, initialSize)
 	var L1, L2, tmpL1, tmpL2 bn254.G1Affine
 
-	remaining := N
+	// Read first point
+	if err := read(buff[:1]); err != nil {
+		return L1, L2, err
+	}
+
+	remaining := N - 1
 	for remaining > 0 {
 		// Read batch
 		readCount := int(math.Min(float64(remaining), float64(batchSize)))
-		for i := 0; i < readCount; i++ {
-			if err := dec.Decode(&buff[i]); err != nil {
-				return L1, L2, err
-			}
+		if err := read(buff[1 : readCount+1]); err != nil {
+			return L1, L2, err
 		}
 
 		// Generate randomness
-		randomize(r)
+		randomize(r[:readCount])
 
 		// Process the batch
-		tmpL1.MultiExp(buff[:readCount-1], r, ecc.MultiExpConfig{})
-		tmpL2.MultiExp(buff[1:readCount], r, ecc.MultiExpConfig{})
+		if _, err := tmpL1.MultiExp(buff[:readCount], r[:readCount], ecc.MultiExpConfig{}); err != nil {
+			return L1, L2, err
+		}
+		if _, err := tmpL2.MultiExp(buff[1:readCount+1], r[:readCount], ecc.MultiExpConfig{}); err != nil {
+			return L1, L2, err
+		}
 		L1.Add(&L1, &tmpL1)
 		L2.Add(&L2, &tmpL2)
+		buff[0] = buff[readCount]
 
 		// Update remaining
 		remaining -= readCount
@@ -194,30 +215,49 @@ func linearCombinationG1(dec *bn254.Decoder, N int) (bn254.G1Affine, bn254.G1Aff
 }
 
 func linearCombinationG2(dec *bn254.Decoder, N int) (bn254.G2Affine, bn254.G2Affine, error) {
-	// Allocate batch with smallest of (N, batchSize)
-	var initialSize = int(math.Min(float64(N), float64(batchSize)))
-	buff := make([]bn254.G2Affine, initialSize)
+	return linearCombinationG2From(func(buff []bn254.G2Affine) error {
+		for i := range buff {
+			if err := dec.Decode(&buff[i]); err != nil {
+				return err
+			}
+		}
+		return nil
+	}, N)
+}
+
+func linearCombinationG2From(read func([]bn254.G2Affine) error, N int) (bn254.G2Affine, bn254.G2Affine, error) {
+	// Allocate batch with smallest of (N-1, batchSize)
+	var initialSize = int(math.Min(float64(N-1), float64(batchSize)))
+	buff := make([]bn254.G2Affine, initialSize+1)
 	r := make([]fr.Element, initialSize)
 	var L1, L2, tmpL1, tmpL2 bn254.G2Affine
 
-	remaining := N
+	// Read first point
+	if err := read(buff[:1]); err != nil {
+		return L1, L2, err
+	}
+
+	remaining := N - 1
 	for remaining > 0 {
 		// Read batch
 		readCount := int(math.Min(float64(remaining), float64(batchSize)))
-		for i := 0; i < readCount; i++ {
-			if err := dec.Decode(&buff[i]); err != nil {
-				return L1, L2, err
-			}
+		if err := read(buff[1 : readCount+1]); err != nil {
+			return L1, L2, err
 		}
 
 		// Generate randomness
-		randomize(r)
+		randomize(r[:readCount])
 
 		// Process the batch
-		tmpL1.MultiExp(buff[:readCount-1], r, ecc.MultiExpConfig{})
-		tmpL2.MultiExp(buff[1:readCount], r, ecc.MultiExpConfig{})
+		if _, err := tmpL1.MultiExp(buff[:readCount], r[:readCount], ecc.MultiExpConfig{}); err != nil {
+			return L1, L2, err
+		}
+		if _, err := tmpL2.MultiExp(buff[1:readCount+1], r[:readCount], ecc.MultiExpConfig{}); err != nil {
+			return L1, L2, err
+		}
 		L1.Add(&L1, &tmpL1)
 		L2.Add(&L2, &tmpL2)
+		buff[0] = buff[readCount]
 
 		// Update remaining
 		remaining -= readCount
@@ -268,6 +308,39 @@ func verifyContribution(current, prev Contribution) error {
 	return nil
 }
 
+// checkDigest checks the digest of files that end with one, then seeks right after the header
+func checkDigest(file *os.File, header *Header) error {
+	if !header.HasDigest() {
+		return nil
+	}
+	if err := common.CheckDigest(file); err != nil {
+		return err
+	}
+	_, err := file.Seek(header.Size(), io.SeekStart)
+	return err
+}
+
+// Digest returns the SHA256 of a phase 1 file without its trailing digest, if any,
+// then seeks back to the beginning of the file
+func Digest(file *os.File) ([]byte, error) {
+	if _, err := file.Seek(0, io.SeekStart); err != nil {
+		return nil, err
+	}
+	var header Header
+	if _, err := header.ReadFrom(file); err != nil {
+		return nil, err
+	}
+	return common.ContentDigest(file, header.HasDigest())
+}
+
+func copySection(inputFile *os.File, writer io.Writer, position, size int64) error {
+	if _, err := inputFile.Seek(position, io.SeekStart); err != nil {
+		return err
+	}
+	_, err := io.CopyN(writer, inputFile, size)
+	return err
+}
+
 func transformG1(inputFile, outputFile *os.File, position int64, size int) error {
 	var g1 bn254.G1Affine
 	if _, err := inputFile.Seek(position, io.SeekStart); err != nil {
@@ -0,0 +1,48 @@
+package phase2
+
+import (
+	"crypto/sha256"
+	"errors"
+	"math/big"
+
+	"github.com/consensys/gnark-crypto/ecc/bn254"
+	"github.com/consensys/gnark-crypto/ecc/bn254/fr"
+)
+
+// beaconDelta derives δ from the beacon value hashed iteratively with SHA256.
+// The digest is then expanded to 64 bytes before reduction to avoid a bias modulo r
+func beaconDelta(beacon []byte, iterations int) (fr.Element, error) {
+	var delta fr.Element
+	digest := sha256.Sum256(beacon)
+	for i := 1; i < iterations; i++ {
+		digest = sha256.Sum256(digest[:])
+	}
+
+	expanded := make([]byte, 0, 2*sha256.Size)
+	for i := byte(0); i < 2; i++ {
+		h := sha256.Sum256(append(digest[:], i))
+		expanded = append(expanded, h[:]...)
+	}
+	delta.SetBytes(expanded)
+	if delta.IsZero() {
+		return delta, errors.New("beacon derives a zero Delta")
+	}
+	return delta, nil
+}
+
+// verifyBeacon checks that [δ]₁ of the contribution is the previous one scaled by δ of the beacon
+func verifyBeacon(c *Contribution, prevDelta bn254.G1Affine, beacon []byte, iterations int) error {
+	delta, err := beaconDelta(beacon, iterations)
+	if err != nil {
+		return err
+	}
+	var deltaBI big.Int
+	delta.BigInt(&deltaBI)
+
+	var expected bn254.G1Affine
+	expected.ScalarMultiplication(&prevDelta, &deltaBI)
+	if !expected.Equal(&c.Delta) {
+		return errors.New("delta of beacon contribution doesn't match the beacon")
+	}
+	return nil
+}
@@ -0,0 +1,400 @@
+package phase2
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+
+	"github.com/consensys/gnark-crypto/ecc"
+	"github.com/worldcoin/semaphore-mtb-setup/common"
+)
+
+// Magic tag of bundles, which hold the whole state of the ceremony of a circuit in a single file
+var bundleMagic = [4]byte{0x93, 'B', 'N', 'D'}
+
+const bundleVersion byte = 1
+
+// Magic, version, curve ID and number of sections preceding the index
+const bundlePrefixSize = len(bundleMagic) + 1 + 2 + 2
+
+// Each entry of the index holds the tag, offset, size and SHA256 of a section
+const bundleEntrySize = 4 + 8 + 8 + common.DigestSize
+
+// BundleSection is the tag of a section of a bundle
+type BundleSection [4]byte
+
+var (
+	// Phase 2 file from Initialize
+	SectionOrigin = BundleSection{'O', 'R', 'I', 'G'}
+	// Evaluations from Initialize
+	SectionEvals = BundleSection{'E', 'V', 'L', 'S'}
+	// SHA256 of the Lagrange SRS from Initialize
+	SectionLagDigest = BundleSection{'L', 'A', 'G', 'D'}
+	// Phase 2 file of the latest contribution
+	SectionLatest = BundleSection{'L', 'A', 'S', 'T'}
+)
+
+var bundleSections = []BundleSection{SectionOrigin, SectionEvals, SectionLagDigest, SectionLatest}
+
+type bundleEntry struct {
+	section BundleSection
+	offset  int64
+	size    int64
+	digest  []byte
+}
+
+// SectionFile is a whole file, or a section of a bundle
+type SectionFile struct {
+	*io.SectionReader
+	file *os.File
+}
+
+func (f *SectionFile) Close() error {
+	return f.file.Close()
+}
+
+// IsBundle reports whether the file is a bundle
+func IsBundle(path string) (bool, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return false, err
+	}
+	defer file.Close()
+	var buff [len(bundleMagic)]byte
+	if _, err := io.ReadFull(file, buff[:]); err != nil {
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			return false, nil
+		}
+		return false, err
+	}
+	return buff == bundleMagic, nil
+}
+
+// OpenSection opens a section of a bundle, or the whole file if it isn't a bundle
+func OpenSection(path string, section BundleSection) (*SectionFile, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+	isBundle, err := IsBundle(path)
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+	if !isBundle {
+		return &SectionFile{io.NewSectionReader(file, 0, info.Size()), file}, nil
+	}
+
+	entry, err := findSection(file, section)
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+	return &SectionFile{io.NewSectionReader(file, entry.offset, entry.size), file}, nil
+}
+
+func readBundleIndex(file *os.File) ([]bundleEntry, error) {
+	prefix := make([]byte, bundlePrefixSize)
+	if _, err := file.ReadAt(prefix, 0); err != nil {
+		return nil, err
+	}
+	if !bytes.Equal(prefix[:len(bundleMagic)], bundleMagic[:]) {
+		return nil, errors.New("not a bundle")
+	}
+	pos := len(bundleMagic)
+	if prefix[pos] != bundleVersion {
+		return nil, fmt.Errorf("unsupported bundle version %d", prefix[pos])
+	}
+	if curve := ecc.ID(binary.BigEndian.Uint16(prefix[pos+1:])); curve != ecc.BN254 {
+		return nil, fmt.Errorf("unsupported curve %s", curve)
+	}
+	count := int(binary.BigEndian.Uint16(prefix[pos+3:]))
+
+	index := make([]byte, count*bundleEntrySize)
+	if _, err := file.ReadAt(index, int64(bundlePrefixSize)); err != nil {
+		return nil, err
+	}
+	entries := make([]bundleEntry, count)
+	for i := range entries {
+		buff := index[i*bundleEntrySize:]
+		copy(entries[i].section[:], buff[:4])
+		entries[i].offset = int64(binary.BigEndian.Uint64(buff[4:]))
+		entries[i].size = int64(binary.BigEndian.Uint64(buff[12:]))
+		entries[i].digest = buff[20:bundleEntrySize]
+	}
+	return entries, nil
+}
+
+func findSection(file *os.File, section BundleSection) (*bundleEntry, error) {
+	entries, err := readBundleIndex(file)
+	if err != nil {
+		return nil, err
+	}
+	for i := range entries {
+		if entries[i].section == section {
+			return &entries[i], nil
+		}
+	}
+	return nil, fmt.Errorf("bundle has no %s section", section[:])
+}
+
+// A section to write in a bundle
+type bundleSource struct {
+	section BundleSection
+	reader  io.Reader
+	size    int64
+	// SHA256 the section must have, if known
+	digest []byte
+}
+
+// writeBundle writes the sections after the index, then fills in their digest
+// The bundle is written to a temporary file of the same directory, then renamed,
+// so that its sources may include the bundle being replaced
+func writeBundle(bundlePath string, sources []bundleSource) error {
+	file, err := os.CreateTemp(filepath.Dir(bundlePath), filepath.Base(bundlePath)+".tmp*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(file.Name())
+	defer file.Close()
+	if err := file.Chmod(0644); err != nil {
+		return err
+	}
+	writer := bufio.NewWriter(file)
+
+	header := make([]byte, bundlePrefixSize, bundlePrefixSize+len(sources)*bundleEntrySize)
+	copy(header, bundleMagic[:])
+	header[len(bundleMagic)] = bundleVersion
+	binary.BigEndian.PutUint16(header[len(bundleMagic)+1:], uint16(ecc.BN254))
+	binary.BigEndian.PutUint16(header[len(bundleMagic)+3:], uint16(len(sources)))
+	offset := int64(bundlePrefixSize + len(sources)*bundleEntrySize)
+	for _, source := range sources {
+		header = append(header, source.section[:]...)
+		header = binary.BigEndian.AppendUint64(header, uint64(offset))
+		header = binary.BigEndian.AppendUint64(header, uint64(source.size))
+		header = append(header, make([]byte, common.DigestSize)...)
+		offset += source.size
+	}
+	if _, err := writer.Write(header); err != nil {
+		return err
+	}
+
+	for i, source := range sources {
+		sha := sha256.New()
+		if _, err := io.CopyN(io.MultiWriter(writer, sha), source.reader, source.size); err != nil {
+			return err
+		}
+		digest := sha.Sum(nil)
+		if source.digest != nil && !bytes.Equal(source.digest, digest) {
+			return fmt.Errorf("digest of the %s section doesn't match its content, it may be corrupted", source.section[:])
+		}
+		copy(header[bundlePrefixSize+i*bundleEntrySize+20:], digest)
+	}
+	if err := writer.Flush(); err != nil {
+		return err
+	}
+	if _, err := file.WriteAt(header, 0); err != nil {
+		return err
+	}
+	if err := file.Close(); err != nil {
+		return err
+	}
+	return os.Rename(file.Name(), bundlePath)
+}
+
+// CreateBundle writes a bundle from the files of the initialization and the phase 2 file of the latest
+// contribution, which is the origin if latestPath is empty
+func CreateBundle(originPath, evalsPath, lagPath, latestPath, bundlePath string) error {
+	if latestPath == "" {
+		latestPath = originPath
+	}
+	files := make([]*os.File, 0, 4)
+	defer func() {
+		for _, f := range files {
+			f.Close()
+		}
+	}()
+	open := func(path string) (*os.File, int64, error) {
+		f, err := os.Open(path)
+		if err != nil {
+			return nil, 0, err
+		}
+		files = append(files, f)
+		info, err := f.Stat()
+		if err != nil {
+			return nil, 0, err
+		}
+		return f, info.Size(), nil
+	}
+
+	originFile, originSize, err := open(originPath)
+	if err != nil {
+		return err
+	}
+	evalsFile, evalsSize, err := open(evalsPath)
+	if err != nil {
+		return err
+	}
+	lagFile, _, err := open(lagPath)
+	if err != nil {
+		return err
+	}
+	latestFile, latestSize, err := open(latestPath)
+	if err != nil {
+		return err
+	}
+
+	// All files must come from the same initialization
+	var origin, latest Header
+	if err := readHeader(originFile, bufio.NewReader(originFile), &origin); err != nil {
+		return err
+	}
+	if origin.Contributions != 0 {
+		return fmt.Errorf("%s has %d contributions, it isn't an initial file", originPath, origin.Contributions)
+	}
+	if err := readHeader(latestFile, bufio.NewReader(latestFile), &latest); err != nil {
+		return err
+	}
+	if !latest.Equal(&origin) {
+		return fmt.Errorf("%s and %s were initialized differently", latestPath, originPath)
+	}
+	evalsHeader, err := ReadEvalsHeader(bufio.NewReader(evalsFile))
+	if err != nil {
+		return err
+	}
+	if evalsHeader != nil && !evalsHeader.Matches(&origin) {
+		return fmt.Errorf("%s weren't computed for %s", evalsPath, originPath)
+	}
+
+	fmt.Println("Hashing the Lagrange SRS")
+	lagDigest, err := common.ContentDigest(lagFile, false)
+	if err != nil {
+		return err
+	}
+
+	for _, f := range []*os.File{originFile, evalsFile, latestFile} {
+		if _, err := f.Seek(0, io.SeekStart); err != nil {
+			return err
+		}
+	}
+	fmt.Println("Writing the bundle")
+	return writeBundle(bundlePath, []bundleSource{
+		{section: SectionOrigin, reader: originFile, size: originSize},
+		{section: SectionEvals, reader: evalsFile, size: evalsSize},
+		{section: SectionLagDigest, reader: bytes.NewReader(lagDigest), size: int64(len(lagDigest))},
+		{section: SectionLatest, reader: latestFile, size: latestSize},
+	})
+}
+
+// SplitBundle writes the phase 2 file of the latest contribution of a bundle, as sent to contributors
+func SplitBundle(bundlePath, phase2Path string) error {
+	bundleFile, err := os.Open(bundlePath)
+	if err != nil {
+		return err
+	}
+	defer bundleFile.Close()
+	entry, err := findSection(bundleFile, SectionLatest)
+	if err != nil {
+		return err
+	}
+
+	phase2File, err := os.Create(phase2Path)
+	if err != nil {
+		return err
+	}
+	defer phase2File.Close()
+	writer := bufio.NewWriter(phase2File)
+	sha := sha256.New()
+	if _, err := io.Copy(io.MultiWriter(writer, sha), io.NewSectionReader(bundleFile, entry.offset, entry.size)); err != nil {
+		return err
+	}
+	if !bytes.Equal(sha.Sum(nil), entry.digest) {
+		return errors.New("digest of the latest section doesn't match its content, it may be corrupted")
+	}
+	return writer.Flush()
+}
+
+// RebuildBundle writes a bundle whose latest contribution is the one of phase2Path, which must belong to
+// the same ceremony and have at least as many contributions as the previous bundle
+func RebuildBundle(bundlePath, phase2Path, outputPath string) error {
+	bundleFile, err := os.Open(bundlePath)
+	if err != nil {
+		return err
+	}
+	defer bundleFile.Close()
+	entries, err := readBundleIndex(bundleFile)
+	if err != nil {
+		return err
+	}
+	bySection := make(map[BundleSection]*bundleEntry)
+	for i := range entries {
+		bySection[entries[i].section] = &entries[i]
+	}
+	for _, section := range bundleSections {
+		if bySection[section] == nil {
+			return fmt.Errorf("bundle has no %s section", section[:])
+		}
+	}
+
+	phase2File, err := os.Open(phase2Path)
+	if err != nil {
+		return err
+	}
+	defer phase2File.Close()
+	info, err := phase2File.Stat()
+	if err != nil {
+		return err
+	}
+
+	latest := bySection[SectionLatest]
+	previous, previousContributions, err := readContributions(io.NewSectionReader(bundleFile, latest.offset, latest.size))
+	if err != nil {
+		return err
+	}
+	current, currentContributions, err := readContributions(phase2File)
+	if err != nil {
+		return err
+	}
+	if !current.Equal(previous) {
+		return fmt.Errorf("%s isn't part of the ceremony of the bundle", phase2Path)
+	}
+	if current.Contributions < previous.Contributions {
+		return fmt.Errorf("%s has fewer contributions than the bundle", phase2Path)
+	}
+	// The contributions of the bundle must be the first ones of the new file, which rejects forks
+	for i := range previousContributions {
+		if !bytes.Equal(previousContributions[i].Hash, currentContributions[i].Hash) {
+			return fmt.Errorf("contribution %d of %s differs from the one of the bundle, it forks from the ceremony", i+1, phase2Path)
+		}
+	}
+	if _, err := phase2File.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+
+	source := func(section BundleSection) bundleSource {
+		entry := bySection[section]
+		return bundleSource{
+			section: section,
+			reader:  io.NewSectionReader(bundleFile, entry.offset, entry.size),
+			size:    entry.size,
+			digest:  entry.digest,
+		}
+	}
+	return writeBundle(outputPath, []bundleSource{
+		source(SectionOrigin),
+		source(SectionEvals),
+		source(SectionLagDigest),
+		{section: SectionLatest, reader: phase2File, size: info.Size()},
+	})
+}
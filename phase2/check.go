@@ -0,0 +1,253 @@
+package phase2
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/worldcoin/semaphore-mtb-setup/common"
+)
+
+// A section of a file, made of count points of pointSize bytes each after prefix bytes of length,
+// or of the rest of the file if count is negative
+type section struct {
+	name      string
+	prefix    int64
+	count     int64
+	pointSize int64
+}
+
+// CheckInitialization re-runs the initialization of phase 2 from the phase 1 file and the R1CS into a temporary
+// directory, then compares the result with published files section by section and reports the first divergence.
+// The Lagrange SRS and the evaluations are skipped if their path is empty, and the run is then reported as partial
+func CheckInitialization(phase1Path, r1csPath, phase2Path, lagPath, evalsPath string) error {
+	tmpDir, err := os.MkdirTemp("", "phase2-check")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(tmpDir)
+
+	fmt.Println("Reproducing the initialization of phase 2 ...")
+	reproducedPhase2 := filepath.Join(tmpDir, "c0.ph2")
+	reproducedLag := filepath.Join(tmpDir, "srs.lag")
+	reproducedEvals := filepath.Join(tmpDir, "evals")
+	if err := Initialize(phase1Path, r1csPath, reproducedPhase2, reproducedLag, reproducedEvals); err != nil {
+		return err
+	}
+
+	fmt.Printf("Comparing %s\n", phase2Path)
+	header, err := checkPhase2(phase2Path, reproducedPhase2)
+	if err != nil {
+		return err
+	}
+	if lagPath != "" {
+		fmt.Printf("Comparing %s\n", lagPath)
+		if err := checkLagrange(lagPath, reproducedLag, header); err != nil {
+			return err
+		}
+	}
+	if evalsPath != "" {
+		fmt.Printf("Comparing %s\n", evalsPath)
+		if err := checkEvals(evalsPath, reproducedEvals, header); err != nil {
+			return err
+		}
+	}
+
+	var skipped []string
+	if lagPath == "" {
+		skipped = append(skipped, "the Lagrange SRS")
+	}
+	if evalsPath == "" {
+		skipped = append(skipped, "the evaluations")
+	}
+	if len(skipped) > 0 {
+		fmt.Printf("Initialization has been partially reproduced, %s weren't compared\n", strings.Join(skipped, " and "))
+		return nil
+	}
+	fmt.Println("Initialization has been reproduced successfully")
+	return nil
+}
+
+func checkPhase2(publishedPath, reproducedPath string) (*Header, error) {
+	publishedFile, err := os.Open(publishedPath)
+	if err != nil {
+		return nil, err
+	}
+	defer publishedFile.Close()
+	reproducedFile, err := os.Open(reproducedPath)
+	if err != nil {
+		return nil, err
+	}
+	defer reproducedFile.Close()
+	publishedReader := bufio.NewReader(publishedFile)
+	reproducedReader := bufio.NewReader(reproducedFile)
+
+	var published, reproduced Header
+	if err := readHeader(publishedFile, publishedReader, &published); err != nil {
+		return nil, err
+	}
+	if err := reproduced.Read(reproducedReader); err != nil {
+		return nil, err
+	}
+	if published.Contributions != 0 {
+		return nil, fmt.Errorf("%s has %d contributions, it isn't an initial file", publishedPath, published.Contributions)
+	}
+	// Legacy files don't record the digests nor the format flags
+	sameHeader := published.Equal(&reproduced)
+	if !published.HasDigest() {
+		shape := reproduced
+		shape.R1CSDigest, shape.Phase1Digest = nil, nil
+		shape.Transcript, shape.Metadata = false, false
+		sameHeader = published.Equal(&shape)
+	}
+	if !sameHeader {
+		return nil, fmt.Errorf("%s diverges in section Header", publishedPath)
+	}
+	fmt.Printf("%s: Header matches\n", publishedPath)
+
+	sections := []section{
+		{"Delta", 0, 1, 32 + 64},
+		{"Z", 0, int64(reproduced.Domain), 32},
+		{"PKK", 0, int64(reproduced.Witness), 32},
+	}
+	if err := compareSections(publishedPath, publishedReader, reproducedReader, sections); err != nil {
+		return nil, err
+	}
+
+	// Nothing but the digest may follow the parameters
+	rest, err := io.ReadAll(publishedReader)
+	if err != nil {
+		return nil, err
+	}
+	if published.HasDigest() && len(rest) != common.DigestSize || !published.HasDigest() && len(rest) != 0 {
+		return nil, fmt.Errorf("%s has unexpected data after the parameters", publishedPath)
+	}
+	return &reproduced, nil
+}
+
+func checkLagrange(publishedPath, reproducedPath string, header *Header) error {
+	publishedFile, err := os.Open(publishedPath)
+	if err != nil {
+		return err
+	}
+	defer publishedFile.Close()
+	reproducedFile, err := os.Open(reproducedPath)
+	if err != nil {
+		return err
+	}
+	defer reproducedFile.Close()
+
+	// Slices are prefixed by their uint32 length
+	domain := int64(header.Domain)
+	sections := []section{
+		{"TauG1", 4, domain, 32},
+		{"AlphaTauG1", 4, domain, 32},
+		{"BetaTauG1", 4, domain, 32},
+		{"TauG2", 4, domain, 64},
+		{"End", 0, -1, 0},
+	}
+	return compareSections(publishedPath, bufio.NewReader(publishedFile), bufio.NewReader(reproducedFile), sections)
+}
+
+func checkEvals(publishedPath, reproducedPath string, header *Header) error {
+	publishedFile, err := os.Open(publishedPath)
+	if err != nil {
+		return err
+	}
+	defer publishedFile.Close()
+	reproducedFile, err := os.Open(reproducedPath)
+	if err != nil {
+		return err
+	}
+	defer reproducedFile.Close()
+	publishedReader := bufio.NewReader(publishedFile)
+	reproducedReader := bufio.NewReader(reproducedFile)
+
+	// Legacy evaluations don't have a header
+	published, err := ReadEvalsHeader(publishedReader)
+	if err != nil {
+		return err
+	}
+	reproduced, err := ReadEvalsHeader(reproducedReader)
+	if err != nil {
+		return err
+	}
+	if reproduced == nil {
+		return errors.New("reproduced evaluations don't have a header")
+	}
+	if published != nil {
+		if !published.Matches(header) {
+			return fmt.Errorf("%s diverges in section Header", publishedPath)
+		}
+		fmt.Printf("%s: Header matches\n", publishedPath)
+	}
+
+	// Slices are prefixed by their uint32 length
+	wires := int64(header.Wires)
+	sections := []section{
+		{"Alpha and Beta", 0, 1, 32 + 32 + 64},
+		{"A", 4, wires, 32},
+		{"B1", 4, wires, 32},
+		{"B2", 4, wires, 64},
+		{"VKK", 4, int64(header.Public), 32},
+		{"CKK", 4, int64(header.PrivateCommitted), 32},
+		{"CommitmentInfo", 0, -1, 0},
+	}
+	return compareSections(publishedPath, publishedReader, reproducedReader, sections)
+}
+
+// compareSections compares the successive sections of a published and a reproduced file
+func compareSections(name string, published, reproduced io.Reader, sections []section) error {
+	const chunkSize = 1 << 16
+	buffPublished := make([]byte, chunkSize)
+	buffReproduced := make([]byte, chunkSize)
+	for _, s := range sections {
+		if s.count < 0 {
+			restPublished, err := io.ReadAll(published)
+			if err != nil {
+				return err
+			}
+			restReproduced, err := io.ReadAll(reproduced)
+			if err != nil {
+				return err
+			}
+			if !bytes.Equal(restPublished, restReproduced) {
+				return fmt.Errorf("%s diverges in section %s", name, s.name)
+			}
+			fmt.Printf("%s: %s matches\n", name, s.name)
+			continue
+		}
+
+		var offset int64
+		for remaining := s.prefix + s.count*s.pointSize; remaining > 0; {
+			n := int64(chunkSize)
+			if remaining < n {
+				n = remaining
+			}
+			if _, err := io.ReadFull(published, buffPublished[:n]); err != nil {
+				return fmt.Errorf("%s is truncated in section %s: %w", name, s.name, err)
+			}
+			if _, err := io.ReadFull(reproduced, buffReproduced[:n]); err != nil {
+				return err
+			}
+			for i := int64(0); i < n; i++ {
+				if buffPublished[i] == buffReproduced[i] {
+					continue
+				}
+				if offset+i < s.prefix {
+					return fmt.Errorf("%s diverges in the length of section %s", name, s.name)
+				}
+				return fmt.Errorf("%s diverges in section %s at point %d", name, s.name, (offset+i-s.prefix)/s.pointSize)
+			}
+			offset += n
+			remaining -= n
+		}
+		fmt.Printf("%s: %s matches\n", name, s.name)
+	}
+	return nil
+}
@@ -0,0 +1,194 @@
+package phase2
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"encoding/gob"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/consensys/gnark-crypto/ecc/bn254"
+	"github.com/worldcoin/semaphore-mtb-setup/common"
+)
+
+// Magic tag of verification checkpoints
+var checkpointMagic = [4]byte{0x93, 'C', 'K', 'P'}
+
+const checkpointVersion byte = 1
+
+const checkpointSeedSize = 32
+
+// Without a transcript, nothing binds the contributions that checkpoint and incremental verification skip
+var errNoTranscript = errors.New("the contribution hashes of the file don't chain, verify it from the origin with p2v")
+
+// Checkpoint is the state of a verified phase 2 file, from which later files can be verified without the origin.
+// Z and PKK are committed to by random linear combinations whose coefficients are derived from Seed,
+// so the checkpoint must stay private to the verifier: a contributor knowing the seed could forge parameters
+// that pass the check
+type Checkpoint struct {
+	Header Header
+	Seed   []byte
+
+	// Parameters and hash of the last contribution
+	Delta1 bn254.G1Affine
+	Delta2 bn254.G2Affine
+	Hash   []byte
+
+	ZCommitment   bn254.G1Affine
+	PKKCommitment bn254.G1Affine
+}
+
+func (c *Checkpoint) write(path string) error {
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	writer := bufio.NewWriter(file)
+
+	if _, err := writer.Write(append(checkpointMagic[:], checkpointVersion)); err != nil {
+		return err
+	}
+	if err := gob.NewEncoder(writer).Encode(c); err != nil {
+		return err
+	}
+	return writer.Flush()
+}
+
+func ReadCheckpoint(path string) (*Checkpoint, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	reader := bufio.NewReader(file)
+
+	prefix := make([]byte, len(checkpointMagic)+1)
+	if _, err := io.ReadFull(reader, prefix); err != nil {
+		return nil, err
+	}
+	if !bytes.Equal(prefix[:len(checkpointMagic)], checkpointMagic[:]) {
+		return nil, errors.New("not a phase 2 checkpoint")
+	}
+	if prefix[len(checkpointMagic)] != checkpointVersion {
+		return nil, fmt.Errorf("unsupported checkpoint version %d", prefix[len(checkpointMagic)])
+	}
+	var c Checkpoint
+	if err := gob.NewDecoder(reader).Decode(&c); err != nil {
+		return nil, err
+	}
+	if len(c.Seed) != checkpointSeedSize {
+		return nil, errors.New("invalid checkpoint seed")
+	}
+	return &c, nil
+}
+
+// VerifyFromCheckpoint verifies the contributions made since a checkpoint, reading the input file only.
+// A new checkpoint of the input is written to nextCheckpointPath unless it's empty
+func VerifyFromCheckpoint(inputPath, checkpointPath, nextCheckpointPath string) error {
+	checkpoint, err := ReadCheckpoint(checkpointPath)
+	if err != nil {
+		return err
+	}
+
+	// Input file
+	inputFile, err := os.Open(inputPath)
+	if err != nil {
+		return err
+	}
+	defer inputFile.Close()
+
+	// The digest is checked once the file has been read
+	inputDigest := common.NewDigestReader(inputFile)
+	inputReader := bufio.NewReader(inputDigest)
+	// Parameters are hashed along the way for the transcript
+	paramsSha := sha256.New()
+	inputDec := bn254.NewDecoder(io.TeeReader(inputReader, paramsSha))
+
+	// Read header
+	var curHeader Header
+	if err := curHeader.Read(inputReader); err != nil {
+		return err
+	}
+	if !curHeader.Equal(&checkpoint.Header) {
+		return fmt.Errorf("there is a mismatch between checkpoint and current headers for phase 2")
+	}
+	if checkpoint.Header.Beacon != nil {
+		return fmt.Errorf("checkpoint is closed by a beacon contribution")
+	}
+	if !checkpoint.Header.Transcript {
+		return errNoTranscript
+	}
+	if curHeader.Contributions <= checkpoint.Header.Contributions {
+		return fmt.Errorf("there are no contributions since the checkpoint")
+	}
+
+	// Read [δ]₁ and [δ]₂
+	var d1 bn254.G1Affine
+	var d2 bn254.G2Affine
+	if err := inputDec.Decode(&d1); err != nil {
+		return err
+	}
+	if err := inputDec.Decode(&d2); err != nil {
+		return err
+	}
+
+	// Check δ₁ and δ₂ are updated by the same factor
+	if !common.SameRatio(checkpoint.Delta1, d1, d2, checkpoint.Delta2) {
+		return fmt.Errorf("deltaG1 and deltaG2 aren't consistent")
+	}
+
+	// Combine Z and PKK with the coefficients of the checkpoint
+	coefficients, err := common.NewCoefficientsReader(checkpoint.Seed)
+	if err != nil {
+		return err
+	}
+	fmt.Println("Verifying update of Z")
+	z, err := aggregate(coefficients, curHeader.Domain, inputDec)
+	if err != nil {
+		return err
+	}
+	if !common.SameRatio(z[0], checkpoint.ZCommitment, d2, checkpoint.Delta2) {
+		return fmt.Errorf("inconsistent update to Z")
+	}
+	fmt.Println("Verifying update of PKK")
+	pkk, err := aggregate(coefficients, curHeader.Witness, inputDec)
+	if err != nil {
+		return err
+	}
+	if !common.SameRatio(pkk[0], checkpoint.PKKCommitment, d2, checkpoint.Delta2) {
+		return fmt.Errorf("inconsistent update to PKK")
+	}
+
+	// Verify contributions since the checkpoint
+	fmt.Printf("#Contributions := %d, %d since the checkpoint\n", curHeader.Contributions, curHeader.Contributions-checkpoint.Header.Contributions)
+	c, err := verifyContributions(inputReader, &curHeader, checkpoint.Header.Contributions, checkpoint.Delta1, checkpoint.Hash, &d1, paramsSha.Sum(nil))
+	if err != nil {
+		return err
+	}
+	if err := checkDigest(inputReader, inputDigest, &curHeader); err != nil {
+		return err
+	}
+	fmt.Println("Contributions verification has been successful")
+
+	if nextCheckpointPath == "" {
+		return nil
+	}
+	next := Checkpoint{
+		Header:        curHeader,
+		Seed:          checkpoint.Seed,
+		Delta1:        d1,
+		Delta2:        d2,
+		Hash:          c.Hash,
+		ZCommitment:   z[0],
+		PKKCommitment: pkk[0],
+	}
+	if err := next.write(nextCheckpointPath); err != nil {
+		return err
+	}
+	fmt.Printf("Checkpoint has been written to %s\n", nextCheckpointPath)
+	return nil
+}
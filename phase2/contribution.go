@@ -1,8 +1,11 @@
 package phase2
 
 import (
+	"bufio"
 	"crypto/sha256"
+	"encoding/binary"
 	"io"
+	"os"
 
 	"github.com/consensys/gnark-crypto/ecc/bn254"
 	"github.com/worldcoin/semaphore-mtb-setup/common"
@@ -11,10 +14,14 @@ import (
 type Contribution struct {
 	Delta     bn254.G1Affine
 	PublicKey common.PublicKey
-	Hash      []byte
+	// Identity of the contributor, only recorded in ceremonies with metadata
+	Metadata *Metadata
+	// SHA256 of the parameters produced by the contribution, only in transcript mode
+	ParamsDigest []byte
+	Hash         []byte
 }
 
-func (c *Contribution) writeTo(writer io.Writer) (int64, error) {
+func (c *Contribution) writeTo(writer io.Writer, header *Header) (int64, error) {
 	toEncode := []interface{}{
 		&c.Delta,
 		&c.PublicKey.S,
@@ -28,11 +35,19 @@ func (c *Contribution) writeTo(writer io.Writer) (int64, error) {
 			return enc.BytesWritten(), err
 		}
 	}
+	if header.Metadata {
+		if err := writeMetadata(writer, c.Metadata); err != nil {
+			return enc.BytesWritten(), err
+		}
+	}
+	if _, err := writer.Write(c.ParamsDigest); err != nil {
+		return enc.BytesWritten(), err
+	}
 	nBytes, err := writer.Write(c.Hash)
 	return int64(nBytes), err
 }
 
-func (c *Contribution) readFrom(reader io.Reader) (int64, error) {
+func (c *Contribution) readFrom(reader io.Reader, header *Header) (int64, error) {
 	toDecode := []interface{}{
 		&c.Delta,
 		&c.PublicKey.S,
@@ -46,13 +61,66 @@ func (c *Contribution) readFrom(reader io.Reader) (int64, error) {
 			return dec.BytesRead(), err
 		}
 	}
+	c.Metadata = nil
+	if header.Metadata {
+		var err error
+		if c.Metadata, err = readMetadata(reader); err != nil {
+			return dec.BytesRead(), err
+		}
+	}
+	c.ParamsDigest = nil
+	if header.Transcript {
+		c.ParamsDigest = make([]byte, sha256.Size)
+		if _, err := io.ReadFull(reader, c.ParamsDigest); err != nil {
+			return dec.BytesRead(), err
+		}
+	}
 	c.Hash = make([]byte, 32)
 	nBytes, err := io.ReadFull(reader, c.Hash)
 	return int64(nBytes), err
 }
 
-func computeHash(c *Contribution) []byte {
+// ReadContributions reads the header and the contributions of a phase 2 file, skipping its parameters
+func ReadContributions(inputPath string) (*Header, []Contribution, error) {
+	inputFile, err := os.Open(inputPath)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer inputFile.Close()
+	return readContributions(inputFile)
+}
+
+func readContributions(file io.ReadSeeker) (*Header, []Contribution, error) {
+	reader := bufio.NewReader(file)
+	var header Header
+	if err := readHeader(file, reader, &header); err != nil {
+		return nil, nil, err
+	}
+
+	// Skip [δ]₁, [δ]₂, Z and PKK in compressed representation
+	const G1Size = 32
+	const G2Size = 64
+	paramsSize := G1Size + G2Size + G1Size*(header.Domain+header.Witness)
+	if _, err := reader.Discard(paramsSize); err != nil {
+		return nil, nil, err
+	}
+
+	contributions := make([]Contribution, header.Contributions)
+	for i := range contributions {
+		if _, err := contributions[i].readFrom(reader, &header); err != nil {
+			return nil, nil, err
+		}
+	}
+	return &header, contributions, nil
+}
+
+// In transcript mode, the hash also covers the previous hash and the digest of the parameters
+// The hash of a beacon contribution also covers the beacon and its iterations recorded in the header
+func computeHash(c *Contribution, prevHash []byte, header *Header, beacon bool) []byte {
 	sha := sha256.New()
+	if header.Transcript {
+		sha.Write(prevHash)
+	}
 	toEncode := []interface{}{
 		&c.Delta,
 		&c.PublicKey.S,
@@ -64,6 +132,29 @@ func computeHash(c *Contribution) []byte {
 	for _, v := range toEncode {
 		enc.Encode(v)
 	}
+	if header.Metadata {
+		writeMetadata(sha, c.Metadata)
+	}
+	sha.Write(c.ParamsDigest)
+	if beacon {
+		sha.Write(header.Beacon)
+		binary.Write(sha, binary.BigEndian, int64(header.BeaconIterations))
+	}
 
 	return sha.Sum(nil)
 }
+
+// readContributionsSection reads the raw contributions, which follow the parameters up to the trailing digest
+func readContributionsSection(reader io.Reader, header *Header) ([]byte, error) {
+	section, err := io.ReadAll(reader)
+	if err != nil {
+		return nil, err
+	}
+	if header.HasDigest() {
+		if len(section) < common.DigestSize {
+			return nil, io.ErrUnexpectedEOF
+		}
+		section = section[:len(section)-common.DigestSize]
+	}
+	return section, nil
+}
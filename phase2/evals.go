@@ -0,0 +1,72 @@
+package phase2
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/worldcoin/semaphore-mtb-setup/common"
+)
+
+// Magic tag of evaluation files, its first byte is below the flags of compressed points
+// so it can't be mistaken for [α]₁ at the beginning of legacy evaluation files
+var evalsMagic = [4]byte{0x13, 'E', 'V', 'L'}
+
+const evalsVersion byte = 1
+
+// EvalsHeaderSize is the number of bytes preceding [α]₁ in evaluation files with a header
+const EvalsHeaderSize = len(evalsMagic) + 1 + 2*common.DigestSize
+
+// EvalsHeader binds an evaluation file to the circuit and phase 1 file it was computed from
+type EvalsHeader struct {
+	R1CSDigest   []byte
+	Phase1Digest []byte
+}
+
+func (h *EvalsHeader) write(writer io.Writer) error {
+	if _, err := writer.Write(evalsMagic[:]); err != nil {
+		return err
+	}
+	if _, err := writer.Write([]byte{evalsVersion}); err != nil {
+		return err
+	}
+	if _, err := writer.Write(h.R1CSDigest); err != nil {
+		return err
+	}
+	_, err := writer.Write(h.Phase1Digest)
+	return err
+}
+
+// ReadEvalsHeader reads the header of an evaluation file, it returns nil for legacy files without header
+func ReadEvalsHeader(reader *bufio.Reader) (*EvalsHeader, error) {
+	first, err := reader.Peek(1)
+	if err != nil {
+		return nil, err
+	}
+	if first[0] != evalsMagic[0] {
+		return nil, nil
+	}
+
+	buff := make([]byte, EvalsHeaderSize)
+	if _, err := io.ReadFull(reader, buff); err != nil {
+		return nil, err
+	}
+	if !bytes.Equal(buff[:len(evalsMagic)], evalsMagic[:]) {
+		return nil, errors.New("not an evaluation file")
+	}
+	if buff[len(evalsMagic)] != evalsVersion {
+		return nil, fmt.Errorf("unsupported evaluation format version %d", buff[len(evalsMagic)])
+	}
+	pos := len(evalsMagic) + 1
+	return &EvalsHeader{
+		R1CSDigest:   buff[pos : pos+common.DigestSize],
+		Phase1Digest: buff[pos+common.DigestSize:],
+	}, nil
+}
+
+// Matches reports whether the evaluations were computed for the circuit and phase 1 file of the phase 2 header
+func (h *EvalsHeader) Matches(header *Header) bool {
+	return bytes.Equal(h.R1CSDigest, header.R1CSDigest) && bytes.Equal(h.Phase1Digest, header.Phase1Digest)
+}
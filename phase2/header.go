@@ -1,10 +1,29 @@
 package phase2
 
 import (
+	"bytes"
+	"encoding/binary"
 	"encoding/gob"
+	"errors"
+	"fmt"
 	"io"
+
+	"github.com/consensys/gnark-crypto/ecc"
 )
 
+// Magic tag of .ph2 files, its first byte can't be mistaken for the beginning of a legacy gob header
+var magic = [4]byte{0x93, 'P', 'H', '2'}
+
+const (
+	// Legacy files only hold the gob header, without magic, curve nor digest
+	legacyVersion byte = 0
+	// Files with magic, version, curve ID and a trailing SHA256 of the whole file
+	currentVersion byte = 1
+)
+
+// Magic, version and curve ID preceding the gob header
+const prefixSize = len(magic) + 1 + 2
+
 type Header struct {
 	Wires            int
 	Witness          int
@@ -13,17 +32,73 @@ type Header struct {
 	Constraints      int
 	Domain           int
 	Contributions    int
+
+	// Parameters of the beacon the last contribution is derived from, if any
+	Beacon           []byte
+	BeaconIterations int
+
+	// SHA256 of the R1CS and of the phase 1 file (without trailing digest) used in the initialization
+	R1CSDigest   []byte
+	Phase1Digest []byte
+
+	// Contribution hashes chain the previous hash and the digest of the parameters produced
+	Transcript bool
+	// Contributions hold a length-prefixed metadata record
+	Metadata bool
+
+	version byte
 }
 
 func (h *Header) Read(reader io.Reader) error {
-	dec := gob.NewDecoder(reader)
+	// gob reads exactly the header from readers implementing io.ByteReader
+	scanner, ok := reader.(scanReader)
+	if !ok {
+		scanner = &byteScanner{reader: reader}
+	}
+
+	first, err := scanner.ReadByte()
+	if err != nil {
+		return err
+	}
+	h.version = legacyVersion
+	if first == magic[0] {
+		// Read the rest of magic, version, and curve ID
+		buff := make([]byte, prefixSize-1)
+		if _, err := io.ReadFull(scanner, buff); err != nil {
+			return err
+		}
+		if [4]byte{first, buff[0], buff[1], buff[2]} != magic {
+			return errors.New("not a phase 2 file")
+		}
+		if buff[3] != currentVersion {
+			return fmt.Errorf("unsupported phase 2 format version %d", buff[3])
+		}
+		if curve := ecc.ID(binary.BigEndian.Uint16(buff[4:])); curve != ecc.BN254 {
+			return fmt.Errorf("unsupported curve %s", curve)
+		}
+		h.version = currentVersion
+	} else if err := scanner.UnreadByte(); err != nil {
+		return err
+	}
+
+	dec := gob.NewDecoder(scanner)
 	if err := dec.Decode(h); err != nil {
 		return err
 	}
 	return nil
 }
 
+// write always writes the current version, whatever the version of the header read
 func (h *Header) write(writer io.Writer) error {
+	// Write Magic, Version, and Curve ID
+	buff := make([]byte, prefixSize)
+	copy(buff, magic[:])
+	buff[4] = currentVersion
+	binary.BigEndian.PutUint16(buff[5:], uint16(ecc.BN254))
+	if _, err := writer.Write(buff); err != nil {
+		return err
+	}
+
 	enc := gob.NewEncoder(writer)
 	if err := enc.Encode(*h); err != nil {
 		return err
@@ -31,14 +106,67 @@ func (h *Header) write(writer io.Writer) error {
 	return nil
 }
 
+// HasDigest reports whether the file the header was read from ends with a digest
+func (h *Header) HasDigest() bool {
+	return h.version != legacyVersion
+}
+
 func (h *Header) Equal(h2 *Header) bool {
 	if h.Wires == h2.Wires &&
 		h.Witness == h2.Witness &&
 		h.Public == h2.Public &&
 		h.PrivateCommitted == h2.PrivateCommitted &&
 		h.Constraints == h2.Constraints &&
-		h.Domain == h2.Domain {
+		h.Domain == h2.Domain &&
+		h.Transcript == h2.Transcript &&
+		h.Metadata == h2.Metadata &&
+		bytes.Equal(h.R1CSDigest, h2.R1CSDigest) &&
+		bytes.Equal(h.Phase1Digest, h2.Phase1Digest) {
 		return true
 	}
 	return false
 }
+
+type scanReader interface {
+	io.Reader
+	io.ByteScanner
+}
+
+// byteScanner reads one byte at a time so that nothing is read past the header
+type byteScanner struct {
+	reader io.Reader
+	last   []byte
+	unread bool
+}
+
+func (s *byteScanner) Read(p []byte) (int, error) {
+	if len(p) == 0 {
+		return 0, nil
+	}
+	b, err := s.ReadByte()
+	if err != nil {
+		return 0, err
+	}
+	p[0] = b
+	return 1, nil
+}
+
+func (s *byteScanner) ReadByte() (byte, error) {
+	if s.unread {
+		s.unread = false
+		return s.last[0], nil
+	}
+	s.last = make([]byte, 1)
+	if _, err := io.ReadFull(s.reader, s.last); err != nil {
+		return 0, err
+	}
+	return s.last[0], nil
+}
+
+func (s *byteScanner) UnreadByte() error {
+	if s.last == nil || s.unread {
+		return errors.New("no byte to unread")
+	}
+	s.unread = true
+	return nil
+}
@@ -0,0 +1,96 @@
+package phase2
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+)
+
+// Upper bound of metadata records, so that a corrupted length doesn't exhaust memory
+const maxMetadataSize = 1 << 16
+
+// Metadata identifies a contributor, it is covered by the contribution hash
+type Metadata struct {
+	Name        string
+	Comment     string
+	Timestamp   time.Time
+	ToolVersion string
+}
+
+// Encoded as Name, Comment, ToolVersion each prefixed by their uint32 length, then Timestamp as uint64 Unix seconds
+func (m *Metadata) marshal() []byte {
+	var buff []byte
+	for _, s := range []string{m.Name, m.Comment, m.ToolVersion} {
+		buff = binary.BigEndian.AppendUint32(buff, uint32(len(s)))
+		buff = append(buff, s...)
+	}
+	return binary.BigEndian.AppendUint64(buff, uint64(m.Timestamp.Unix()))
+}
+
+func (m *Metadata) unmarshal(buff []byte) error {
+	var fields [3]string
+	for i := range fields {
+		if len(buff) < 4 {
+			return errors.New("metadata record is truncated")
+		}
+		size := binary.BigEndian.Uint32(buff)
+		buff = buff[4:]
+		if uint32(len(buff)) < size {
+			return errors.New("metadata record is truncated")
+		}
+		fields[i] = string(buff[:size])
+		buff = buff[size:]
+	}
+	if len(buff) != 8 {
+		return errors.New("metadata record has an invalid size")
+	}
+	m.Name, m.Comment, m.ToolVersion = fields[0], fields[1], fields[2]
+	m.Timestamp = time.Unix(int64(binary.BigEndian.Uint64(buff)), 0).UTC()
+	return nil
+}
+
+// The record is the metadata prefixed by its uint32 length, which is 0 without metadata
+func writeMetadata(writer io.Writer, m *Metadata) error {
+	var record []byte
+	if m != nil {
+		record = m.marshal()
+	}
+	if len(record) > maxMetadataSize {
+		return fmt.Errorf("metadata can't exceed %d bytes", maxMetadataSize)
+	}
+	buff := binary.BigEndian.AppendUint32(nil, uint32(len(record)))
+	if _, err := writer.Write(append(buff, record...)); err != nil {
+		return err
+	}
+	return nil
+}
+
+func readMetadata(reader io.Reader) (*Metadata, error) {
+	buff := make([]byte, 4)
+	if _, err := io.ReadFull(reader, buff); err != nil {
+		return nil, err
+	}
+	size := binary.BigEndian.Uint32(buff)
+	if size == 0 {
+		return nil, nil
+	}
+	if size > maxMetadataSize {
+		return nil, fmt.Errorf("metadata record of %d bytes exceeds %d bytes", size, maxMetadataSize)
+	}
+	record := make([]byte, size)
+	if _, err := io.ReadFull(reader, record); err != nil {
+		return nil, err
+	}
+	var m Metadata
+	if err := m.unmarshal(record); err != nil {
+		return nil, err
+	}
+	return &m, nil
+}
+
+func (m *Metadata) String() string {
+	return fmt.Sprintf("Name := %q, Comment := %q, Timestamp := %s, Tool := %q",
+		m.Name, m.Comment, m.Timestamp.Format(time.RFC3339), m.ToolVersion)
+}
@@ -0,0 +1,209 @@
+package phase2
+
+import (
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/consensys/gnark-crypto/ecc/bn254/fr"
+	"github.com/worldcoin/semaphore-mtb-setup/common"
+)
+
+// How often the progress of a contribution to several files is printed
+const progressInterval = 5 * time.Second
+
+// ContributionFiles are the input and output of a contribution to one of several files
+type ContributionFiles struct {
+	Input  string
+	Output string
+}
+
+// FileContribution is the contribution made to one of several files
+type FileContribution struct {
+	Input        string `json:"input"`
+	Output       string `json:"output"`
+	Contribution int    `json:"contribution"`
+	Hash         string `json:"hash"`
+}
+
+// MultiReceipt lists the contributions made to several files in a single run
+type MultiReceipt struct {
+	Name          string             `json:"name,omitempty"`
+	Timestamp     time.Time          `json:"timestamp"`
+	Contributions []FileContribution `json:"contributions"`
+}
+
+func (r *MultiReceipt) String() string {
+	var b strings.Builder
+	if r.Name != "" {
+		fmt.Fprintf(&b, "Name := %q\n", r.Name)
+	}
+	fmt.Fprintf(&b, "Timestamp := %s\n", r.Timestamp.Format(time.RFC3339))
+	for _, c := range r.Contributions {
+		fmt.Fprintf(&b, "%s -> %s: contribution %d with Hash := %s\n", c.Input, c.Output, c.Contribution, c.Hash)
+	}
+	return b.String()
+}
+
+// progress counts the bytes written to the output of a contribution
+type progress struct {
+	written int64
+	// Size of the input, which the output exceeds by the new contribution only
+	size int64
+}
+
+func (p *progress) Write(b []byte) (int, error) {
+	atomic.AddInt64(&p.written, int64(len(b)))
+	return len(b), nil
+}
+
+func (p *progress) percent() int64 {
+	if p.size == 0 {
+		return 100
+	}
+	percent := 100 * atomic.LoadInt64(&p.written) / p.size
+	if percent > 100 {
+		percent = 100
+	}
+	return percent
+}
+
+// ContributeMulti contributes to several files at once, with a fresh δ for each. The δ are sampled from rand
+// in the order of the files, which are then processed by as many workers as GOMAXPROCS, largest first.
+// If some of the files fail, their outputs are removed and the receipt of the others is returned along with the error
+func ContributeMulti(files []ContributionFiles, rand io.Reader, metadata *Metadata) (*MultiReceipt, error) {
+	if len(files) == 0 {
+		return nil, errors.New("there are no files to contribute to")
+	}
+	// An output can't be read as the input of another file while it is being written
+	inputs := make(map[string]bool)
+	for _, f := range files {
+		inputs[filepath.Clean(f.Input)] = true
+	}
+	outputs := make(map[string]bool)
+	for _, f := range files {
+		output := filepath.Clean(f.Output)
+		if inputs[output] || outputs[output] {
+			return nil, fmt.Errorf("%s is written twice or overwrites an input", f.Output)
+		}
+		outputs[output] = true
+	}
+
+	fmt.Printf("Sampling toxic parameters Delta for %d files\n", len(files))
+	deltas := make([]fr.Element, len(files))
+	rands := make([]io.Reader, len(files))
+	progresses := make([]*progress, len(files))
+	for i, f := range files {
+		var err error
+		if deltas[i], err = common.SampleElement(rand); err != nil {
+			return nil, err
+		}
+		if rands[i], err = common.NewForkedReader(rand); err != nil {
+			return nil, err
+		}
+		info, err := os.Stat(f.Input)
+		if err != nil {
+			return nil, err
+		}
+		progresses[i] = &progress{size: info.Size()}
+	}
+
+	// Timestamps are recorded in seconds in the metadata
+	timestamp := time.Now().UTC().Truncate(time.Second)
+	if metadata != nil {
+		timestamp = metadata.Timestamp.UTC().Truncate(time.Second)
+	}
+
+	// Decoding points is sequential in each file, so files are processed side by side while scaling
+	// is parallelized within each of them. The largest files start first, so that they don't end up last alone
+	order := make([]int, len(files))
+	for i := range order {
+		order[i] = i
+	}
+	sort.SliceStable(order, func(a, b int) bool { return progresses[order[a]].size > progresses[order[b]].size })
+	jobs := make(chan int, len(files))
+	for _, i := range order {
+		jobs <- i
+	}
+	close(jobs)
+	workers := runtime.GOMAXPROCS(0)
+	if workers > len(files) {
+		workers = len(files)
+	}
+
+	contributions := make([]FileContribution, len(files))
+	errs := make([]error, len(files))
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range jobs {
+				// contribute removes the output of a failed contribution
+				header, contribution, err := contribute(files[i].Input, files[i].Output, &deltas[i], nil, 0, rands[i], metadata, progresses[i])
+				if err != nil {
+					errs[i] = err
+					continue
+				}
+				contributions[i] = FileContribution{
+					Input:        files[i].Input,
+					Output:       files[i].Output,
+					Contribution: header.Contributions,
+					Hash:         hex.EncodeToString(contribution.Hash),
+				}
+			}
+		}()
+	}
+
+	done := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(done)
+	}()
+	ticker := time.NewTicker(progressInterval)
+	defer ticker.Stop()
+	for running := true; running; {
+		select {
+		case <-done:
+			running = false
+		case <-ticker.C:
+			printProgress(files, progresses)
+		}
+	}
+	printProgress(files, progresses)
+
+	receipt := &MultiReceipt{Timestamp: timestamp}
+	if metadata != nil {
+		receipt.Name = metadata.Name
+	}
+	var failures []string
+	for i, err := range errs {
+		if err != nil {
+			failures = append(failures, fmt.Sprintf("%s: %s", files[i].Input, err))
+			continue
+		}
+		receipt.Contributions = append(receipt.Contributions, contributions[i])
+	}
+	if len(failures) != 0 {
+		return receipt, fmt.Errorf("contributions to %d out of %d files have failed and their outputs have been removed:\n%s",
+			len(failures), len(files), strings.Join(failures, "\n"))
+	}
+	return receipt, nil
+}
+
+func printProgress(files []ContributionFiles, progresses []*progress) {
+	parts := make([]string, len(files))
+	for i, f := range files {
+		parts[i] = fmt.Sprintf("%s %d%%", f.Output, progresses[i].percent())
+	}
+	fmt.Printf("Progress: %s\n", strings.Join(parts, ", "))
+}
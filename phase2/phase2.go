@@ -2,8 +2,13 @@ package phase2
 
 import (
 	"bufio"
+	"bytes"
+	crand "crypto/rand"
+	"crypto/sha256"
 	"encoding/hex"
+	"errors"
 	"fmt"
+	"io"
 	"math/big"
 	"os"
 
@@ -12,7 +17,9 @@ import (
 	"github.com/worldcoin/semaphore-mtb-setup/common"
 )
 
-func Initialize(phase1Path, r1csPath, phase2Path string) error {
+// Initialize writes the initial phase 2 file, along with the Lagrange SRS and the evaluations
+// the keys are later extracted from
+func Initialize(phase1Path, r1csPath, phase2Path, lagPath, evalsPath string) error {
 	phase1File, err := os.Open(phase1Path)
 	if err != nil {
 		return err
@@ -32,12 +39,12 @@ func Initialize(phase1Path, r1csPath, phase2Path string) error {
 	}
 
 	// 2. Convert phase 1 SRS to Lagrange basis
-	if err := processLagrange(header1, header2, phase1File, phase2File); err != nil {
+	if err := processLagrange(header1, header2, phase1File, lagPath); err != nil {
 		return err
 	}
 
 	// 3. Process evaluation
-	if err := processEvaluations(header1, header2, r1csPath, phase1File); err != nil {
+	if err := processEvaluations(header1, header2, r1csPath, phase1File, lagPath, evalsPath); err != nil {
 		return err
 	}
 
@@ -47,7 +54,11 @@ func Initialize(phase1Path, r1csPath, phase2Path string) error {
 	}
 
 	// Process parameters
-	if err := processPVCKK(header1, header2, r1csPath, phase2File); err != nil {
+	if err := processPVCKK(header1, header2, r1csPath, phase2File, lagPath, evalsPath); err != nil {
+		return err
+	}
+
+	if err := common.AppendDigest(phase2File); err != nil {
 		return err
 	}
 
@@ -56,88 +67,207 @@ func Initialize(phase1Path, r1csPath, phase2Path string) error {
 }
 
 func Contribute(inputPath, outputPath string) error {
+	return ContributeWithEntropy(inputPath, outputPath, nil)
+}
+
+// ContributeWithEntropy samples toxic parameters from OS randomness mixed with the given entropy
+func ContributeWithEntropy(inputPath, outputPath string, entropy []byte) error {
+	rand, err := common.NewEntropyReader(entropy)
+	if err != nil {
+		return err
+	}
+	return ContributeWithRand(inputPath, outputPath, rand, nil)
+}
+
+// ContributeWithRand reads toxic parameters and proofs of knowledge from the given source of randomness,
+// and records the metadata of the contributor if not nil
+func ContributeWithRand(inputPath, outputPath string, rand io.Reader, metadata *Metadata) error {
+	// Sample toxic parameters
+	fmt.Println("Sampling toxic parameters Delta")
+	// Sample toxic δ
+	delta, err := common.SampleElement(rand)
+	if err != nil {
+		return err
+	}
+
+	_, _, err = contribute(inputPath, outputPath, &delta, nil, 0, rand, metadata, nil)
+	return err
+}
+
+// Beacon closes the ceremony with a contribution whose δ is derived from a public random beacon,
+// the beacon parameters are recorded in the header so that δ can be recomputed by verifiers
+func Beacon(inputPath, outputPath string, beacon []byte, iterations int) error {
+	if len(beacon) == 0 {
+		return errors.New("beacon value is empty")
+	}
+	if iterations < 1 {
+		return errors.New("number of iterations must be positive")
+	}
+
+	fmt.Printf("Deriving Delta from beacon with %d iterations\n", iterations)
+	delta, err := beaconDelta(beacon, iterations)
+	if err != nil {
+		return err
+	}
+
+	// Only δ must be derived from the beacon
+	rand, err := common.NewEntropyReader(nil)
+	if err != nil {
+		return err
+	}
+
+	_, _, err = contribute(inputPath, outputPath, &delta, beacon, iterations, rand, nil, nil)
+	return err
+}
+
+// ContributeStream contributes to the phase 2 file read from input and writes the result to output without seeking
+// in either of them, so that neither has to be stored on disk. Messages are printed to stderr since output may be stdout
+func ContributeStream(input io.Reader, output io.Writer, rand io.Reader, metadata *Metadata) error {
+	fmt.Fprintln(os.Stderr, "Sampling toxic parameters Delta")
+	delta, err := common.SampleElement(rand)
+	if err != nil {
+		return err
+	}
+	_, _, err = contributeStream(input, output, &delta, nil, 0, rand, metadata, os.Stderr)
+	return err
+}
+
+// contribute returns the header of the output and the new contribution. Messages are only printed without progress,
+// which otherwise counts the bytes written to the output
+func contribute(inputPath, outputPath string, delta *fr.Element, beacon []byte, iterations int, rand io.Reader, metadata *Metadata, progress *progress) (*Header, *Contribution, error) {
+	var log io.Writer = os.Stdout
+	if progress != nil {
+		log = io.Discard
+	}
+
 	// Input file
 	inputFile, err := os.Open(inputPath)
 	if err != nil {
-		return err
+		return nil, nil, err
 	}
 	defer inputFile.Close()
-	reader := bufio.NewReader(inputFile)
-	dec := bn254.NewDecoder(reader)
 
 	// Output file
 	outputFile, err := os.Create(outputPath)
 	if err != nil {
-		return err
+		return nil, nil, err
 	}
 	defer outputFile.Close()
-	writer := bufio.NewWriter(outputFile)
-	defer writer.Flush()
-	enc := bn254.NewEncoder(writer)
+	var output io.Writer = outputFile
+	if progress != nil {
+		output = io.MultiWriter(outputFile, progress)
+	}
+
+	// The digest of the input is only checked once it has been read, so the output of a corrupted input is removed
+	header, contribution, err := contributeStream(inputFile, output, delta, beacon, iterations, rand, metadata, log)
+	if err != nil {
+		outputFile.Close()
+		os.Remove(outputPath)
+		return nil, nil, err
+	}
+	return header, contribution, nil
+}
+
+// contributeStream reads the input and writes the output sequentially, the digest of the input is checked
+// once it has been read and the one of the output is computed along the way
+func contributeStream(input io.Reader, output io.Writer, delta *fr.Element, beacon []byte, iterations int, rand io.Reader, metadata *Metadata, log io.Writer) (*Header, *Contribution, error) {
+	inputDigest := common.NewDigestReader(input)
+	reader := bufio.NewReader(inputDigest)
+	dec := bn254.NewDecoder(reader)
 
-	// Read/Write header with extra contribution
+	// Read header
 	var header Header
 	if err := header.Read(reader); err != nil {
-		return err
+		return nil, nil, err
+	}
+	fmt.Fprintf(log, "Current #Contributions := %d\n", header.Contributions)
+	if header.Beacon != nil {
+		return nil, nil, errors.New("ceremony has already been closed with a beacon contribution")
 	}
-	fmt.Printf("Current #Contributions := %d\n", header.Contributions)
+	if metadata != nil && !header.Metadata {
+		return nil, nil, errors.New("metadata can't be recorded in ceremonies initialized without metadata")
+	}
+	hasDigest := header.HasDigest()
+
+	writer := bufio.NewWriter(output)
+	outputSha := sha256.New()
+	content := io.MultiWriter(writer, outputSha)
+	// Parameters are hashed along the way for the transcript
+	paramsSha := sha256.New()
+	enc := bn254.NewEncoder(io.MultiWriter(content, paramsSha))
+
+	// Write header with extra contribution
 	header.Contributions++
-	if err := header.write(writer); err != nil {
-		return err
+	header.Beacon = beacon
+	header.BeaconIterations = iterations
+	if err := header.write(content); err != nil {
+		return nil, nil, err
 	}
 
-	// Sample toxic parameters
-	fmt.Println("Sampling toxic parameters Delta")
-	// Sample toxic δ
-	var delta, deltaInv fr.Element
+	var deltaInv fr.Element
 	var deltaBI, deltaInvBI big.Int
-	delta.SetRandom()
-	deltaInv.Inverse(&delta)
+	deltaInv.Inverse(delta)
 
 	delta.BigInt(&deltaBI)
 	deltaInv.BigInt(&deltaInvBI)
 
 	// Process δ₁
-	fmt.Println("Processing DeltaG1 and DeltaG2")
+	fmt.Fprintln(log, "Processing DeltaG1 and DeltaG2")
 	var delta1 bn254.G1Affine
 	if err := dec.Decode(&delta1); err != nil {
-		return err
+		return nil, nil, err
 	}
 	delta1.ScalarMultiplication(&delta1, &deltaBI)
 	if err := enc.Encode(&delta1); err != nil {
-		return err
+		return nil, nil, err
 	}
 
 	// Process δ₂
 	var delta2 bn254.G2Affine
 	if err := dec.Decode(&delta2); err != nil {
-		return err
+		return nil, nil, err
 	}
 	delta2.ScalarMultiplication(&delta2, &deltaBI)
 	if err := enc.Encode(&delta2); err != nil {
-		return err
+		return nil, nil, err
 	}
 
 	// Process Z using δ⁻¹
-	if err = scale(dec, enc, header.Domain, &deltaInvBI); err != nil {
-		return err
+	if err := scale(dec, enc, header.Domain, &deltaInvBI); err != nil {
+		return nil, nil, err
 	}
 
 	// Process PKK using δ⁻¹
-	if err = scale(dec, enc, header.Witness, &deltaInvBI); err != nil {
-		return err
+	if err := scale(dec, enc, header.Witness, &deltaInvBI); err != nil {
+		return nil, nil, err
 	}
 
 	// Copy old contributions
 	nExistingContributions := header.Contributions - 1
 	var c Contribution
 	for i := 0; i < nExistingContributions; i++ {
-		if _, err := c.readFrom(reader); err != nil {
-			return err
+		if _, err := c.readFrom(reader, &header); err != nil {
+			return nil, nil, err
 		}
-		if _, err := c.writeTo(writer); err != nil {
-			return err
+		if _, err := c.writeTo(content, &header); err != nil {
+			return nil, nil, err
+		}
+	}
+
+	// Only the digest may follow the contributions
+	rest, err := io.ReadAll(io.LimitReader(reader, common.DigestSize+1))
+	if err != nil {
+		return nil, nil, err
+	}
+	if hasDigest {
+		if len(rest) != common.DigestSize {
+			return nil, nil, errors.New("input doesn't end with a digest")
+		}
+		if err := inputDigest.Check(); err != nil {
+			return nil, nil, err
 		}
+	} else if len(rest) != 0 {
+		return nil, nil, errors.New("input has trailing data")
 	}
 
 	// Get hash of previous contribution
@@ -150,99 +280,231 @@ func Contribute(inputPath, outputPath string) error {
 
 	var contribution Contribution
 	contribution.Delta.Set(&delta1)
-	contribution.PublicKey = common.GenPublicKey(delta, prevHash, 1)
-	contribution.Hash = computeHash(&contribution)
+	if contribution.PublicKey, err = common.GenPublicKey(*delta, prevHash, 1, rand); err != nil {
+		return nil, nil, err
+	}
+	if header.Transcript {
+		contribution.ParamsDigest = paramsSha.Sum(nil)
+	}
+	contribution.Metadata = metadata
+	contribution.Hash = computeHash(&contribution, prevHash, &header, beacon != nil)
 
-	// Write the contribution
-	contribution.writeTo(writer)
+	// Write the contribution, then the digest of the whole output
+	if _, err := contribution.writeTo(content, &header); err != nil {
+		return nil, nil, err
+	}
+	if _, err := writer.Write(outputSha.Sum(nil)); err != nil {
+		return nil, nil, err
+	}
+	if err := writer.Flush(); err != nil {
+		return nil, nil, err
+	}
 
-	fmt.Println("Contirbution has been successful!")
-	fmt.Println("Contribution Hash := ", hex.EncodeToString(contribution.Hash))
+	fmt.Fprintln(log, "Contirbution has been successful!")
+	fmt.Fprintln(log, "Contribution Hash := ", hex.EncodeToString(contribution.Hash))
 
-	return nil
+	return &header, &contribution, nil
 }
 
 func Verify(inputPath, originPath string) error {
-	// Input file
-	inputFile, err := os.Open(inputPath)
+	_, err := verify(inputPath, originPath)
+	return err
+}
+
+// VerifyWithCheckpoint verifies a phase 2 file from the origin, then writes a checkpoint from which
+// later files can be verified without the origin
+func VerifyWithCheckpoint(inputPath, originPath, checkpointPath string) error {
+	// Refuse before replaying the whole ceremony
+	inputFile, err := OpenSection(inputPath, SectionLatest)
 	if err != nil {
 		return err
 	}
+	var header Header
+	err = header.Read(bufio.NewReader(inputFile))
+	inputFile.Close()
+	if err != nil {
+		return err
+	}
+	if !header.Transcript {
+		return errNoTranscript
+	}
+
+	checkpoint, err := verify(inputPath, originPath)
+	if err != nil {
+		return err
+	}
+	if err := checkpoint.write(checkpointPath); err != nil {
+		return err
+	}
+	fmt.Printf("Checkpoint has been written to %s\n", checkpointPath)
+	return nil
+}
+
+// Both the input and the origin can be bundles, in which case their latest and origin sections are verified.
+// The origin defaults to the one of the input bundle if its path is empty
+func verify(inputPath, originPath string) (*Checkpoint, error) {
+	if originPath == "" {
+		isBundle, err := IsBundle(inputPath)
+		if err != nil {
+			return nil, err
+		}
+		if !isBundle {
+			return nil, errors.New("the origin is required unless the input is a bundle")
+		}
+		originPath = inputPath
+	}
+
+	// Input file
+	inputFile, err := OpenSection(inputPath, SectionLatest)
+	if err != nil {
+		return nil, err
+	}
 	defer inputFile.Close()
 
 	// Origin file from Phase2.Initialize
-	originFile, err := os.Open(originPath)
+	originFile, err := OpenSection(originPath, SectionOrigin)
 	if err != nil {
-		return err
+		return nil, err
 	}
 	defer originFile.Close()
 
-	inputReader := bufio.NewReader(inputFile)
-	inputDec := bn254.NewDecoder(inputReader)
-	originReader := bufio.NewReader(originFile)
+	// Digests are checked once the files have been read
+	inputDigest := common.NewDigestReader(inputFile)
+	inputReader := bufio.NewReader(inputDigest)
+	// Parameters are hashed along the way for the transcript
+	paramsSha := sha256.New()
+	inputDec := bn254.NewDecoder(io.TeeReader(inputReader, paramsSha))
+	originDigest := common.NewDigestReader(originFile)
+	originReader := bufio.NewReader(originDigest)
 	originDec := bn254.NewDecoder(originReader)
 
 	// Read curHeader
 	var curHeader, orgHeader Header
 	if err := curHeader.Read(inputReader); err != nil {
-		return err
+		return nil, err
 	}
 
 	if err := orgHeader.Read(originReader); err != nil {
-		return err
+		return nil, err
 	}
 	if curHeader.Contributions == 0 {
-		return fmt.Errorf("there are no contributions to verify")
+		return nil, fmt.Errorf("there are no contributions to verify")
+	}
+	if !bytes.Equal(curHeader.R1CSDigest, orgHeader.R1CSDigest) || !bytes.Equal(curHeader.Phase1Digest, orgHeader.Phase1Digest) {
+		return nil, fmt.Errorf("origin and current files were initialized from different circuits or phase 1 files")
 	}
 	if !curHeader.Equal(&orgHeader) {
-		return fmt.Errorf("there is a mismatch between origin and curren headers for phase 2")
+		return nil, fmt.Errorf("there is a mismatch between origin and curren headers for phase 2")
+	}
+	if curHeader.R1CSDigest != nil {
+		fmt.Printf("R1CS Digest := %s\n", hex.EncodeToString(curHeader.R1CSDigest))
+		fmt.Printf("Phase 1 Digest := %s\n", hex.EncodeToString(curHeader.Phase1Digest))
 	}
 
 	// Read [δ]₁ and [δ]₂
 	var d1, g1 bn254.G1Affine
 	var d2, g2 bn254.G2Affine
 	if err := originDec.Decode(&g1); err != nil {
-		return err
+		return nil, err
 	}
 	if err := originDec.Decode(&g2); err != nil {
-		return err
+		return nil, err
 	}
 	if err := inputDec.Decode(&d1); err != nil {
-		return err
+		return nil, err
 	}
 	if err := inputDec.Decode(&d2); err != nil {
-		return err
+		return nil, err
 	}
 
 	// Check δ₁ and δ₂ are consistent
 	if !common.SameRatio(g1, d1, d2, g2) {
-		return fmt.Errorf("deltaG1 and deltaG2 aren't consistent")
+		return nil, fmt.Errorf("deltaG1 and deltaG2 aren't consistent")
+	}
+
+	// Coefficients of the random linear combinations are derived from a fresh seed,
+	// which the checkpoint keeps to commit to Z and PKK of the input
+	seed := make([]byte, checkpointSeedSize)
+	if _, err := crand.Read(seed); err != nil {
+		return nil, err
+	}
+	coefficients, err := common.NewCoefficientsReader(seed)
+	if err != nil {
+		return nil, err
 	}
 
 	// Check Z is updated correctly from origin to the latest state
 	fmt.Println("Verifying update of Z")
-	if err := verifyParameter(&d2, &g2, inputDec, originDec, curHeader.Domain, "Z"); err != nil {
-		return err
+	z, err := verifyParameter(&d2, &g2, inputDec, originDec, curHeader.Domain, "Z", coefficients)
+	if err != nil {
+		return nil, err
 	}
 
 	// Check PKK is updated correctly from origin to the latest state
 	fmt.Println("Verifying update of PKK")
-	if err := verifyParameter(&d2, &g2, inputDec, originDec, curHeader.Witness, "PKK"); err != nil {
-		return err
+	pkk, err := verifyParameter(&d2, &g2, inputDec, originDec, curHeader.Witness, "PKK", coefficients)
+	if err != nil {
+		return nil, err
 	}
 
 	// Verify contributions
 	fmt.Printf("#Contributions := %d\n", curHeader.Contributions)
-	var prevDelta = g1
-	var prevHash []byte = nil
+	c, err := verifyContributions(inputReader, &curHeader, 0, g1, nil, &d1, paramsSha.Sum(nil))
+	if err != nil {
+		return nil, err
+	}
+	if err := checkDigest(inputReader, inputDigest, &curHeader); err != nil {
+		return nil, err
+	}
+	if err := checkDigest(originReader, originDigest, &orgHeader); err != nil {
+		return nil, err
+	}
+
+	fmt.Println("Contributions verification has been successful")
+	return &Checkpoint{
+		Header:        curHeader,
+		Seed:          seed,
+		Delta1:        d1,
+		Delta2:        d2,
+		Hash:          c.Hash,
+		ZCommitment:   *z,
+		PKKCommitment: *pkk,
+	}, nil
+}
+
+// verifyContributions reads the contributions of a file and verifies those from index start on,
+// the first of them being based on prevDelta and prevHash, then checks the last one against the parameters.
+// Skipping contributions requires a transcript, as the hashes don't chain otherwise
+func verifyContributions(reader io.Reader, header *Header, start int, prevDelta bn254.G1Affine, prevHash []byte, d1 *bn254.G1Affine, paramsDigest []byte) (*Contribution, error) {
+	if start > 0 && !header.Transcript {
+		return nil, errNoTranscript
+	}
 	var c Contribution
-	for i := 0; i < curHeader.Contributions; i++ {
-		if _, err := c.readFrom(inputReader); err != nil {
-			return err
+	for i := 0; i < header.Contributions; i++ {
+		if _, err := c.readFrom(reader, header); err != nil {
+			return nil, err
+		}
+		if i < start {
+			// Earlier contributions are pinned down by the hash chain of the transcript
+			if i == start-1 && !bytes.Equal(c.Hash, prevHash) {
+				return nil, fmt.Errorf("hash of contribution %d doesn't match", i+1)
+			}
+			continue
 		}
 		fmt.Printf("Verifying contribution %d with Hash := %s\n", i+1, hex.EncodeToString(c.Hash))
-		if err := verifyContribution(&c, prevDelta, prevHash); err != nil {
-			return err
+		if c.Metadata != nil {
+			fmt.Println(c.Metadata)
+		}
+		// The beacon contribution is the last one
+		isBeacon := header.Beacon != nil && i == header.Contributions-1
+		if err := verifyContribution(&c, prevDelta, prevHash, header, isBeacon); err != nil {
+			return nil, err
+		}
+		if isBeacon {
+			fmt.Println("Verifying Delta of beacon contribution")
+			if err := verifyBeacon(&c, prevDelta, header.Beacon, header.BeaconIterations); err != nil {
+				return nil, err
+			}
 		}
 		prevDelta = c.Delta
 		prevHash = c.Hash
@@ -250,10 +512,166 @@ func Verify(inputPath, originPath string) error {
 
 	// Verify last contribution has the same delta in parameters
 	fmt.Println("Verifying Delta of last contribution")
+	if !c.Delta.Equal(d1) {
+		return nil, fmt.Errorf("delta of last contribution delta isn't the same as in parameters")
+	}
+
+	// Verify last contribution pins down the parameters
+	if header.Transcript {
+		fmt.Println("Verifying parameters digest of last contribution")
+		if !bytes.Equal(c.ParamsDigest, paramsDigest) {
+			return nil, fmt.Errorf("parameters digest of last contribution doesn't match the parameters")
+		}
+	}
+	return &c, nil
+}
+
+// VerifyIncremental verifies a single new contribution on top of an already verified previous file,
+// without replaying the ceremony from the origin
+func VerifyIncremental(inputPath, prevPath string) error {
+	// Input file
+	inputFile, err := os.Open(inputPath)
+	if err != nil {
+		return err
+	}
+	defer inputFile.Close()
+
+	// Previous file, assumed verified
+	prevFile, err := os.Open(prevPath)
+	if err != nil {
+		return err
+	}
+	defer prevFile.Close()
+
+	// Digests are checked once the files have been read
+	inputDigest := common.NewDigestReader(inputFile)
+	inputReader := bufio.NewReader(inputDigest)
+	// Parameters are hashed along the way for the transcript
+	paramsSha := sha256.New()
+	inputDec := bn254.NewDecoder(io.TeeReader(inputReader, paramsSha))
+	prevDigest := common.NewDigestReader(prevFile)
+	prevReader := bufio.NewReader(prevDigest)
+	prevDec := bn254.NewDecoder(prevReader)
+
+	// Read headers
+	var curHeader, prevHeader Header
+	if err := curHeader.Read(inputReader); err != nil {
+		return err
+	}
+	if err := prevHeader.Read(prevReader); err != nil {
+		return err
+	}
+	if !curHeader.Equal(&prevHeader) {
+		return fmt.Errorf("there is a mismatch between previous and current headers for phase 2")
+	}
+	if prevHeader.Beacon != nil {
+		return fmt.Errorf("previous file is closed by a beacon contribution")
+	}
+	if curHeader.Contributions != prevHeader.Contributions+1 {
+		return fmt.Errorf("expected %d contributions, got %d", prevHeader.Contributions+1, curHeader.Contributions)
+	}
+	if !curHeader.Transcript {
+		return errNoTranscript
+	}
+
+	// Read [δ]₁ and [δ]₂
+	var d1, prevD1 bn254.G1Affine
+	var d2, prevD2 bn254.G2Affine
+	if err := prevDec.Decode(&prevD1); err != nil {
+		return err
+	}
+	if err := prevDec.Decode(&prevD2); err != nil {
+		return err
+	}
+	if err := inputDec.Decode(&d1); err != nil {
+		return err
+	}
+	if err := inputDec.Decode(&d2); err != nil {
+		return err
+	}
+
+	// Check δ₁ and δ₂ are updated by the same factor
+	if !common.SameRatio(prevD1, d1, d2, prevD2) {
+		return fmt.Errorf("deltaG1 and deltaG2 aren't consistent")
+	}
+
+	// Coefficients of the random linear combinations
+	coefficients, err := common.NewEntropyReader(nil)
+	if err != nil {
+		return err
+	}
+
+	// Check Z is updated correctly from the previous state
+	fmt.Println("Verifying update of Z")
+	if _, err := verifyParameter(&d2, &prevD2, inputDec, prevDec, curHeader.Domain, "Z", coefficients); err != nil {
+		return err
+	}
+
+	// Check PKK is updated correctly from the previous state
+	fmt.Println("Verifying update of PKK")
+	if _, err := verifyParameter(&d2, &prevD2, inputDec, prevDec, curHeader.Witness, "PKK", coefficients); err != nil {
+		return err
+	}
+
+	// Check earlier contributions are untouched
+	prevContributions, err := readContributionsSection(prevReader, &prevHeader)
+	if err != nil {
+		return err
+	}
+	curContributions, err := readContributionsSection(inputReader, &curHeader)
+	if err != nil {
+		return err
+	}
+	if err := checkDigest(prevReader, prevDigest, &prevHeader); err != nil {
+		return err
+	}
+	if err := checkDigest(inputReader, inputDigest, &curHeader); err != nil {
+		return err
+	}
+	fmt.Printf("Verifying %d earlier contributions are unchanged\n", prevHeader.Contributions)
+	if !bytes.HasPrefix(curContributions, prevContributions) {
+		return fmt.Errorf("earlier contributions were modified")
+	}
+
+	// Hash of the last earlier contribution chains into the new one
+	var prevHash []byte = nil
+	var c Contribution
+	prevContributionsReader := bytes.NewReader(prevContributions)
+	for i := 0; i < prevHeader.Contributions; i++ {
+		if _, err := c.readFrom(prevContributionsReader, &prevHeader); err != nil {
+			return err
+		}
+		prevHash = c.Hash
+	}
+
+	// Verify the new contribution
+	if _, err := c.readFrom(bytes.NewReader(curContributions[len(prevContributions):]), &curHeader); err != nil {
+		return err
+	}
+	fmt.Printf("Verifying contribution %d with Hash := %s\n", curHeader.Contributions, hex.EncodeToString(c.Hash))
+	if c.Metadata != nil {
+		fmt.Println(c.Metadata)
+	}
+	if err := verifyContribution(&c, prevD1, prevHash, &curHeader, curHeader.Beacon != nil); err != nil {
+		return err
+	}
+	if curHeader.Beacon != nil {
+		fmt.Println("Verifying Delta of beacon contribution")
+		if err := verifyBeacon(&c, prevD1, curHeader.Beacon, curHeader.BeaconIterations); err != nil {
+			return err
+		}
+	}
+	fmt.Println("Verifying Delta of new contribution")
 	if !c.Delta.Equal(&d1) {
-		return fmt.Errorf("delta of last contribution delta isn't the same as in parameters")
+		return fmt.Errorf("delta of new contribution isn't the same as in parameters")
+	}
+	if curHeader.Transcript {
+		fmt.Println("Verifying parameters digest of new contribution")
+		if !bytes.Equal(c.ParamsDigest, paramsSha.Sum(nil)) {
+			return fmt.Errorf("parameters digest of new contribution doesn't match the parameters")
+		}
 	}
 
-	fmt.Println("Contributions verification has been successful")
+	fmt.Println("Contribution verification has been successful")
 	return nil
 }
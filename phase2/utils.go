@@ -21,6 +21,48 @@ import (
 	"github.com/worldcoin/semaphore-mtb-setup/phase1"
 )
 
+// readHeader reads the header of a phase 2 file, after checking the digest of files that end with one
+func readHeader(file io.ReadSeeker, reader *bufio.Reader, header *Header) error {
+	if err := header.Read(reader); err != nil {
+		return err
+	}
+	if !header.HasDigest() {
+		return nil
+	}
+	if err := common.CheckDigest(file); err != nil {
+		return err
+	}
+	reader.Reset(file)
+	return header.Read(reader)
+}
+
+// checkDigest reads a phase 2 file to its end, then checks its digest, if any, against the content
+// hashed along the way, so that files are only read once
+func checkDigest(reader io.Reader, digest *common.DigestReader, header *Header) error {
+	if !header.HasDigest() {
+		return nil
+	}
+	if _, err := io.Copy(io.Discard, reader); err != nil {
+		return err
+	}
+	return digest.Check()
+}
+
+// Digest returns the SHA256 of a phase 2 file without its trailing digest, if any
+func Digest(inputPath string) ([]byte, error) {
+	inputFile, err := os.Open(inputPath)
+	if err != nil {
+		return nil, err
+	}
+	defer inputFile.Close()
+
+	var header Header
+	if err := header.Read(bufio.NewReader(inputFile)); err != nil {
+		return nil, err
+	}
+	return common.ContentDigest(inputFile, header.HasDigest())
+}
+
 func nextPowerofTwo(number int) int {
 	res := 2
 	for i := 1; i < 28; i++ { // max power is 28
@@ -53,8 +95,20 @@ func processHeader(r1csPath string, phase1File, phase2File *os.File) (*phase1.He
 	header2.Constraints = r1cs.GetNbConstraints()
 	header2.Domain = nextPowerofTwo(header2.Constraints)
 
+	// New ceremonies chain contribution hashes and record metadata
+	header2.Transcript = true
+	header2.Metadata = true
+
+	// Bind the R1CS and phase 1 file to phase 2
+	if header2.R1CSDigest, err = common.ContentDigest(r1csFile, false); err != nil {
+		return nil, nil, err
+	}
+	if header2.Phase1Digest, err = phase1.Digest(phase1File); err != nil {
+		return nil, nil, err
+	}
+
 	// Check if phase 1 power can support the current #Constraints
-	if err := header1.ReadFrom(phase1File); err != nil {
+	if _, err := header1.ReadFrom(phase1File); err != nil {
 		return nil, nil, err
 	}
 	N := int(math.Pow(2, float64(header1.Power)))
@@ -81,12 +135,12 @@ func processHeader(r1csPath string, phase1File, phase2File *os.File) (*phase1.He
 	return &header1, &header2, nil
 }
 
-func processLagrange(header1 *phase1.Header, header2 *Header, phase1File, phase2File *os.File) error {
+func processLagrange(header1 *phase1.Header, header2 *Header, phase1File *os.File, lagPath string) error {
 	fmt.Println("Converting to Lagrange basis ...")
 	domain := fft.NewDomain(uint64(header2.Domain))
 	N := int(math.Pow(2, float64(header1.Power)))
 
-	lagFile, err := os.Create("srs.lag")
+	lagFile, err := os.Create(lagPath)
 	if err != nil {
 		return err
 	}
@@ -94,7 +148,7 @@ func processLagrange(header1 *phase1.Header, header2 *Header, phase1File, phase2
 
 	// TauG1
 	fmt.Println("Converting TauG1")
-	pos := int64(3)
+	pos := header1.Size()
 	if err := lagrangeG1(phase1File, lagFile, pos, domain); err != nil {
 		return err
 	}
@@ -122,23 +176,29 @@ func processLagrange(header1 *phase1.Header, header2 *Header, phase1File, phase2
 	return nil
 }
 
-func processEvaluations(header1 *phase1.Header, header2 *Header, r1csPath string, phase1File *os.File) error {
+func processEvaluations(header1 *phase1.Header, header2 *Header, r1csPath string, phase1File *os.File, lagPath, evalsPath string) error {
 	fmt.Println("Processing evaluation of [A]₁, [B]₁, [B]₂")
 
-	lagFile, err := os.Open("srs.lag")
+	lagFile, err := os.Open(lagPath)
 	if err != nil {
 		return err
 	}
 	defer lagFile.Close()
 
-	evalFile, err := os.Create("evals")
+	evalFile, err := os.Create(evalsPath)
 	if err != nil {
 		return err
 	}
 	defer evalFile.Close()
 
+	// Write the digests the evaluations are computed from
+	evalsHeader := EvalsHeader{R1CSDigest: header2.R1CSDigest, Phase1Digest: header2.Phase1Digest}
+	if err := evalsHeader.write(evalFile); err != nil {
+		return err
+	}
+
 	// Read [α]₁ , [β]₁ , [β]₂  from phase1 (Check Phase 1 file format for reference)
-	alpha, beta1, beta2, err := readPhase1(phase1File, header1.Power)
+	alpha, beta1, beta2, err := readPhase1(phase1File, header1)
 	if err != nil {
 		return err
 	}
@@ -242,7 +302,7 @@ func processDeltaAndZ(header1 *phase1.Header, header2 *Header, phase1File, phase
 	}
 
 	// Seek to TauG1
-	var pos int64 = 3
+	pos := header1.Size()
 	if _, err := phase1File.Seek(pos, io.SeekStart); err != nil {
 		return err
 	}
@@ -273,9 +333,9 @@ func processDeltaAndZ(header1 *phase1.Header, header2 *Header, phase1File, phase
 	return nil
 }
 
-func processPVCKK(header1 *phase1.Header, header2 *Header, r1csPath string, phase2File *os.File) error {
+func processPVCKK(header1 *phase1.Header, header2 *Header, r1csPath string, phase2File *os.File, lagPath, evalsPath string) error {
 	fmt.Println("Processing PKK, VKK, and CKK")
-	lagFile, err := os.Open("srs.lag")
+	lagFile, err := os.Open(lagPath)
 	if err != nil {
 		return err
 	}
@@ -345,7 +405,7 @@ func processPVCKK(header1 *phase1.Header, header2 *Header, r1csPath string, phas
 	}
 
 	// VKK
-	evalFile, err := os.OpenFile("evals", os.O_APPEND|os.O_WRONLY, 0644)
+	evalFile, err := os.OpenFile(evalsPath, os.O_APPEND|os.O_WRONLY, 0644)
 	if err != nil {
 		return err
 	}
@@ -448,7 +508,7 @@ func scale(dec *bn254.Decoder, enc *bn254.Encoder, N int, delta *big.Int) error
 	return nil
 }
 
-func verifyContribution(c *Contribution, prevDelta bn254.G1Affine, prevHash []byte) error {
+func verifyContribution(c *Contribution, prevDelta bn254.G1Affine, prevHash []byte, header *Header, beacon bool) error {
 	// Compute SP for δ
 	deltaSP := common.GenSP(c.PublicKey.S, c.PublicKey.SX, prevHash, 1)
 
@@ -462,7 +522,7 @@ func verifyContribution(c *Contribution, prevDelta bn254.G1Affine, prevHash []by
 		return errors.New("couldn't verify that [δ]₁ is based on previous contribution")
 	}
 	// Verify contribution hash
-	b := computeHash(c)
+	b := computeHash(c, prevHash, header, beacon)
 	if !bytes.Equal(c.Hash, b) {
 		return fmt.Errorf("contribution hash is invalid")
 	}
@@ -470,68 +530,61 @@ func verifyContribution(c *Contribution, prevDelta bn254.G1Affine, prevHash []by
 	return nil
 }
 
-func verifyParameter(delta, g *bn254.G2Affine, inputDecoder, originDecoder *bn254.Decoder, size int, field string) error {
-	// aggregate points
-	if in, or, err := aggregate(inputDecoder, originDecoder, size); err != nil {
-		return nil
-	} else {
-		if !common.SameRatio(*in, *or, *delta, *g) {
-			return fmt.Errorf("inconsistent update to %s", field)
-		}
+// verifyParameter checks the update of a parameter from origin to input by random linear combinations
+// with coefficients read from rand, and returns the combination of the input
+func verifyParameter(delta, g *bn254.G2Affine, inputDecoder, originDecoder *bn254.Decoder, size int, field string, rand io.Reader) (*bn254.G1Affine, error) {
+	aggregates, err := aggregate(rand, size, inputDecoder, originDecoder)
+	if err != nil {
+		return nil, err
 	}
-	return nil
+	if !common.SameRatio(aggregates[0], aggregates[1], *delta, *g) {
+		return nil, fmt.Errorf("inconsistent update to %s", field)
+	}
+	return &aggregates[0], nil
 }
 
-func aggregate(inputDecoder, originDecoder *bn254.Decoder, size int) (*bn254.G1Affine, *bn254.G1Affine, error) {
-	var inG, orG, tmp bn254.G1Affine
+// aggregate combines the next size points of each decoder with the same coefficients read from rand
+func aggregate(rand io.Reader, size int, decoders ...*bn254.Decoder) ([]bn254.G1Affine, error) {
+	res := make([]bn254.G1Affine, len(decoders))
+	var tmp bn254.G1Affine
 	// Allocate batch with smallest of (N, batchSize)
 	const batchSize = 1048576 // 2^20
 	var initialSize = int(math.Min(float64(size), float64(batchSize)))
 	buff := make([]bn254.G1Affine, initialSize)
-	r := make([]fr.Element, size)
+	r := make([]fr.Element, initialSize)
 
 	remaining := size
 	for remaining > 0 {
-
-		// generate randomness
-		common.Parallelize(len(r), func(start, end int) {
-			for i := start; i < end; i++ {
-				r[i].SetRandom()
-			}
-		})
-
-		// Read from input
 		readCount := int(math.Min(float64(remaining), float64(batchSize)))
+
+		// Read coefficients
 		for i := 0; i < readCount; i++ {
-			if err := inputDecoder.Decode(&buff[i]); err != nil {
-				return nil, nil, err
+			var err error
+			if r[i], err = common.SampleElement(rand); err != nil {
+				return nil, err
 			}
 		}
 
-		// Aggregate input
-		if _, err := tmp.MultiExp(buff[:readCount], r[:readCount], ecc.MultiExpConfig{}); err != nil {
-			return nil, nil, err
-		}
-		inG.Add(&inG, &tmp)
-
-		// Read from origin
-		for i := 0; i < readCount; i++ {
-			if err := originDecoder.Decode(&buff[i]); err != nil {
-				return nil, nil, err
+		for j, dec := range decoders {
+			// Read points
+			for i := 0; i < readCount; i++ {
+				if err := dec.Decode(&buff[i]); err != nil {
+					return nil, err
+				}
 			}
-		}
 
-		// Aggregate origin
-		if _, err := tmp.MultiExp(buff[:readCount], r[:readCount], ecc.MultiExpConfig{}); err != nil {
-			return nil, nil, err
+			// Aggregate points
+			if _, err := tmp.MultiExp(buff[:readCount], r[:readCount], ecc.MultiExpConfig{}); err != nil {
+				return nil, err
+			}
+			res[j].Add(&res[j], &tmp)
 		}
-		orG.Add(&orG, &tmp)
 
 		// Update remaining
 		remaining -= readCount
 	}
 
-	return &inG, &orG, nil
+	return res, nil
 }
 
 func filterL(L []bn254.G1Affine, header2 *Header, cmtInfo *constraint.Commitment) ([]bn254.G1Affine, []bn254.G1Affine, []bn254.G1Affine) {
@@ -557,12 +610,11 @@ func filterL(L []bn254.G1Affine, header2 *Header, cmtInfo *constraint.Commitment
 	return pkk, vkk, ckk
 }
 
-func readPhase1(phase1File *os.File, power byte) (*bn254.G1Affine, *bn254.G1Affine, *bn254.G2Affine, error) {
+func readPhase1(phase1File *os.File, header1 *phase1.Header) (*bn254.G1Affine, *bn254.G1Affine, *bn254.G2Affine, error) {
 	var alpha, beta1 bn254.G1Affine
 	var beta2 bn254.G2Affine
-	N := int64(math.Pow(2, float64(power)))
-	const HeaderSize = 3
-	posAlpha := HeaderSize + 32*(2*N-1)
+	N := int64(math.Pow(2, float64(header1.Power)))
+	posAlpha := header1.Size() + 32*(2*N-1)
 	posBeta1 := posAlpha + 32*N
 	posBeta2 := posBeta1 + 96*N
 
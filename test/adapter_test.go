@@ -1,12 +1,83 @@
 package test
 
 import (
+	"bufio"
+	"math/big"
+	"os"
 	"testing"
 
+	"github.com/consensys/gnark-crypto/ecc/bn254"
+	"github.com/consensys/gnark-crypto/ecc/bn254/fr"
 	"github.com/worldcoin/semaphore-mtb-setup/phase1"
 )
 
+// writeChallenge writes a PPoT challenge file of the given power for random τ, α and β
+// Formatted as
+// Hash, 2^(2n)-1[TauG1], 2^n[TauG2], 2^n[AlphaG1], 2^n[BetaG1], BetaG2
+func writeChallenge(path string, power byte) error {
+	file, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	writer := bufio.NewWriter(file)
+	defer writer.Flush()
+
+	var tau, alpha, beta fr.Element
+	tau.SetRandom()
+	alpha.SetRandom()
+	beta.SetRandom()
+	N := 1 << power
+
+	// Scalars
+	taus := make([]fr.Element, 2*N-1)
+	taus[0].SetOne()
+	for i := 1; i < len(taus); i++ {
+		taus[i].Mul(&taus[i-1], &tau)
+	}
+	alphaTaus := make([]fr.Element, N)
+	betaTaus := make([]fr.Element, N)
+	for i := 0; i < N; i++ {
+		alphaTaus[i].Mul(&taus[i], &alpha)
+		betaTaus[i].Mul(&taus[i], &beta)
+	}
+
+	_, _, g1, g2 := bn254.Generators()
+	var hash [64]byte
+	if _, err := writer.Write(hash[:]); err != nil {
+		return err
+	}
+	enc := bn254.NewEncoder(writer, bn254.RawEncoding())
+	for _, p := range bn254.BatchScalarMultiplicationG1(&g1, taus) {
+		if err := enc.Encode(&p); err != nil {
+			return err
+		}
+	}
+	for _, p := range bn254.BatchScalarMultiplicationG2(&g2, taus[:N]) {
+		if err := enc.Encode(&p); err != nil {
+			return err
+		}
+	}
+	for _, p := range bn254.BatchScalarMultiplicationG1(&g1, alphaTaus) {
+		if err := enc.Encode(&p); err != nil {
+			return err
+		}
+	}
+	for _, p := range bn254.BatchScalarMultiplicationG1(&g1, betaTaus) {
+		if err := enc.Encode(&p); err != nil {
+			return err
+		}
+	}
+	var betaG2 bn254.G2Affine
+	var bi big.Int
+	betaG2.ScalarMultiplication(&g2, beta.BigInt(&bi))
+	return enc.Encode(&betaG2)
+}
+
 func TestTransform(t *testing.T) {
+	if err := writeChallenge("new_challenge", 10); err != nil {
+		t.Fatal(err)
+	}
 	if err := phase1.Transform("new_challenge", "0.ph1", 10, 8); err != nil {
 		t.Error(err)
 	}
@@ -20,3 +91,21 @@ func TestTransform(t *testing.T) {
 		t.Error(err)
 	}
 }
+
+func TestTruncate(t *testing.T) {
+	if err := phase1.Initialize(9, "trunc0.ph1"); err != nil {
+		t.Fatal(err)
+	}
+	if err := phase1.Contribute("trunc0.ph1", "trunc1.ph1"); err != nil {
+		t.Fatal(err)
+	}
+	if err := phase1.Truncate("trunc1.ph1", "trunc2.ph1", 8); err != nil {
+		t.Fatal(err)
+	}
+	if err := phase1.Verify("trunc2.ph1", ""); err != nil {
+		t.Error(err)
+	}
+	if err := phase1.Truncate("trunc2.ph1", "trunc3.ph1", 9); err == nil {
+		t.Error("truncating to a higher power should fail")
+	}
+}
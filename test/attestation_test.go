@@ -0,0 +1,222 @@
+package test
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/pem"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+
+	"github.com/worldcoin/semaphore-mtb-setup/attestation"
+	"github.com/worldcoin/semaphore-mtb-setup/phase2"
+	"golang.org/x/crypto/ssh"
+)
+
+// writeKey writes a new ed25519 private key to path and returns its authorized_keys line
+func writeKey(t *testing.T, path, name string) []byte {
+	publicKey, privateKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	der, err := x509.MarshalPKCS8PrivateKey(privateKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	block := &pem.Block{Type: "PRIVATE KEY", Bytes: der}
+	if err := os.WriteFile(path, pem.EncodeToMemory(block), 0600); err != nil {
+		t.Fatal(err)
+	}
+	sshPublicKey, err := ssh.NewPublicKey(publicKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	line := ssh.MarshalAuthorizedKey(sshPublicKey)
+	return append(line[:len(line)-1], " "+name+"\n"...)
+}
+
+func TestAttestation(t *testing.T) {
+	dir := t.TempDir()
+	alice := writeKey(t, filepath.Join(dir, "alice"), "alice")
+	bob := writeKey(t, filepath.Join(dir, "bob"), "bob")
+	knownKeys := filepath.Join(dir, "known_keys")
+	if err := os.WriteFile(knownKeys, append(alice, bob...), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	initializePhase2(t, "at_0.ph2")
+	sigDir := filepath.Join(dir, "sigs")
+	if err := os.Mkdir(sigDir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	for i, key := range []string{"alice", "bob"} {
+		in := fmt.Sprintf("at_%d.ph2", i)
+		out := fmt.Sprintf("at_%d.ph2", i+1)
+		if err := phase2.Contribute(in, out); err != nil {
+			t.Fatal(err)
+		}
+		signer, err := attestation.LoadSigner(filepath.Join(dir, key), nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		a, err := attestation.Sign(in, out, signer)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := a.Write(filepath.Join(sigDir, out+attestation.Extension)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := attestation.Verify("at_2.ph2", sigDir, knownKeys); err != nil {
+		t.Error(err)
+	}
+
+	// Signatures of unknown keys are refused
+	if err := os.WriteFile(knownKeys, alice, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := attestation.Verify("at_2.ph2", sigDir, knownKeys); err == nil {
+		t.Error("verification with an unknown key should fail")
+	}
+	if err := os.WriteFile(knownKeys, append(alice, bob...), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	// A signature over other digests is refused
+	sigPath := filepath.Join(sigDir, "at_2.ph2"+attestation.Extension)
+	a, err := attestation.Read(sigPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	a.InputDigest = a.OutputDigest
+	if err := a.Write(sigPath); err != nil {
+		t.Fatal(err)
+	}
+	if err := attestation.Verify("at_2.ph2", sigDir, knownKeys); err == nil {
+		t.Error("verification of a tampered attestation should fail")
+	}
+}
+
+func TestEncryptedKey(t *testing.T) {
+	if _, err := exec.LookPath("ssh-keygen"); err != nil {
+		t.Skip("ssh-keygen isn't available")
+	}
+	keyPath := filepath.Join(t.TempDir(), "key")
+	if out, err := exec.Command("ssh-keygen", "-q", "-t", "ed25519", "-N", "secret", "-f", keyPath).CombinedOutput(); err != nil {
+		t.Fatalf("%v: %s", err, out)
+	}
+	passphrase := func(secret string) func() ([]byte, error) {
+		return func() ([]byte, error) { return []byte(secret), nil }
+	}
+
+	if _, err := attestation.LoadSigner(keyPath, nil); err == nil {
+		t.Error("loading an encrypted key without passphrase should fail")
+	}
+	if _, err := attestation.LoadSigner(keyPath, passphrase("wrong")); err == nil {
+		t.Error("loading an encrypted key with a wrong passphrase should fail")
+	}
+	if _, err := attestation.LoadSigner(keyPath, passphrase("secret")); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestAttestationSSHKeygen(t *testing.T) {
+	if _, err := exec.LookPath("ssh-keygen"); err != nil {
+		t.Skip("ssh-keygen isn't available")
+	}
+	dir := t.TempDir()
+	keyPath := filepath.Join(dir, "carol")
+	if out, err := exec.Command("ssh-keygen", "-q", "-t", "ed25519", "-N", "", "-C", "carol", "-f", keyPath).CombinedOutput(); err != nil {
+		t.Fatalf("%v: %s", err, out)
+	}
+	publicKey, err := os.ReadFile(keyPath + ".pub")
+	if err != nil {
+		t.Fatal(err)
+	}
+	knownKeys := filepath.Join(dir, "known_keys")
+	if err := os.WriteFile(knownKeys, publicKey, 0644); err != nil {
+		t.Fatal(err)
+	}
+	allowedSigners := filepath.Join(dir, "allowed_signers")
+	if err := os.WriteFile(allowedSigners, append([]byte("carol "), publicKey...), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	initializePhase2(t, "atk_0.ph2")
+	if err := phase2.Contribute("atk_0.ph2", "atk_1.ph2"); err != nil {
+		t.Fatal(err)
+	}
+	signer, err := attestation.LoadSigner(keyPath, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	a, err := attestation.Sign("atk_0.ph2", "atk_1.ph2", signer)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sigDir := filepath.Join(dir, "sigs")
+	if err := os.Mkdir(sigDir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	sigPath := filepath.Join(sigDir, "atk_1.ph2"+attestation.Extension)
+	if err := a.Write(sigPath); err != nil {
+		t.Fatal(err)
+	}
+
+	// ssh-keygen accepts the signature in the namespace of the tool only
+	messagePath := filepath.Join(dir, "message")
+	if err := os.WriteFile(messagePath, []byte(a.Message), 0644); err != nil {
+		t.Fatal(err)
+	}
+	signaturePath := filepath.Join(dir, "signature")
+	if err := os.WriteFile(signaturePath, []byte(a.Signature), 0644); err != nil {
+		t.Fatal(err)
+	}
+	keygenVerify := func(namespace string) error {
+		message, err := os.Open(messagePath)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer message.Close()
+		cmd := exec.Command("ssh-keygen", "-Y", "verify", "-f", allowedSigners, "-I", "carol", "-n", namespace, "-s", signaturePath)
+		cmd.Stdin = message
+		if out, err := cmd.CombinedOutput(); err != nil {
+			return fmt.Errorf("%v: %s", err, out)
+		}
+		return nil
+	}
+	if err := keygenVerify(attestation.Namespace); err != nil {
+		t.Error(err)
+	}
+	if err := keygenVerify("file"); err == nil {
+		t.Error("ssh-keygen should refuse the signature in another namespace")
+	}
+
+	// Signatures of ssh-keygen are accepted in the namespace of the tool only
+	for _, namespace := range []string{attestation.Namespace, "file"} {
+		if out, err := exec.Command("ssh-keygen", "-Y", "sign", "-f", keyPath, "-n", namespace, messagePath).CombinedOutput(); err != nil {
+			t.Fatalf("%v: %s", err, out)
+		}
+		signature, err := os.ReadFile(messagePath + ".sig")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := os.Remove(messagePath + ".sig"); err != nil {
+			t.Fatal(err)
+		}
+		a.Signature = string(signature)
+		if err := a.Write(sigPath); err != nil {
+			t.Fatal(err)
+		}
+		err = attestation.Verify("atk_1.ph2", sigDir, knownKeys)
+		if namespace == attestation.Namespace && err != nil {
+			t.Error(err)
+		}
+		if namespace != attestation.Namespace && err == nil {
+			t.Error("a signature in another namespace should be refused")
+		}
+	}
+}
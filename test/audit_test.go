@@ -0,0 +1,154 @@
+package test
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/worldcoin/semaphore-mtb-setup/audit"
+	"github.com/worldcoin/semaphore-mtb-setup/common"
+	"github.com/worldcoin/semaphore-mtb-setup/phase2"
+)
+
+func TestAudit(t *testing.T) {
+	dir := t.TempDir()
+	file := func(n int) string {
+		return filepath.Join(dir, fmt.Sprintf("aud_c%02d.ph2", n))
+	}
+	origin := filepath.Join(dir, "aud_c0.ph2")
+	initializePhase2(t, origin)
+
+	names := []string{"alice", "bob", "carol"}
+	for i, name := range names {
+		in := file(i)
+		if i == 0 {
+			in = origin
+		}
+		rand, err := common.NewEntropyReader(nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		metadata := &phase2.Metadata{Name: name, Timestamp: time.Now()}
+		if err := phase2.ContributeWithRand(in, file(i+1), rand, metadata); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	report, err := audit.Run(dir, origin)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := report.Err(); err != nil {
+		t.Fatal(err)
+	}
+	if len(report.Steps) != len(names) {
+		t.Fatalf("expected %d steps, got %d", len(names), len(report.Steps))
+	}
+	_, contributions, err := phase2.ReadContributions(file(len(names)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, step := range report.Steps {
+		if step.Contribution != i+1 || step.Name != names[i] || step.Hash != fmt.Sprintf("%x", contributions[i].Hash) {
+			t.Errorf("unexpected step %+v", step)
+		}
+	}
+
+	// A file forking from an earlier one is detected
+	if err := phase2.Contribute(file(1), file(3)); err != nil {
+		t.Fatal(err)
+	}
+	report, err = audit.Run(dir, origin)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.Valid || report.Steps[2].Valid || !strings.Contains(report.Steps[2].Error, "replaced") {
+		t.Errorf("fork isn't detected: %+v", report.Steps[2])
+	}
+	if !report.Steps[0].Valid || !report.Steps[1].Valid {
+		t.Error("files before the fork should be valid")
+	}
+
+	// A file after the fork is compared to the last valid file rather than to the fork
+	if err := phase2.Contribute(file(2), file(4)); err != nil {
+		t.Fatal(err)
+	}
+	report, err = audit.Run(dir, origin)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !report.Steps[3].Valid {
+		t.Errorf("file built on the last valid one should be valid: %+v", report.Steps[3])
+	}
+
+	// A file after missing ones is verified from the origin
+	if err := os.Remove(file(3)); err != nil {
+		t.Fatal(err)
+	}
+	report, err = audit.Run(dir, origin)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := report.Err(); err != nil {
+		t.Fatal(err)
+	}
+	if last := report.Steps[2]; last.Missing != 1 || last.Contribution != 3 {
+		t.Errorf("unexpected step after a missing file %+v", last)
+	}
+	content, err := os.ReadFile(file(4))
+	if err != nil {
+		t.Fatal(err)
+	}
+	content[len(content)-1] ^= 1
+	if err := os.WriteFile(file(4), content, 0644); err != nil {
+		t.Fatal(err)
+	}
+	report, err = audit.Run(dir, origin)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.Valid || report.Steps[2].Valid {
+		t.Error("corrupted file after a missing one should be invalid")
+	}
+}
+
+func TestCheckHashes(t *testing.T) {
+	dir := t.TempDir()
+	origin := filepath.Join(dir, "h_0.ph2")
+	initializePhase2(t, origin)
+	if err := phase2.Contribute(origin, filepath.Join(dir, "h_1.ph2")); err != nil {
+		t.Fatal(err)
+	}
+	final := filepath.Join(dir, "h_2.ph2")
+	if err := phase2.Contribute(filepath.Join(dir, "h_1.ph2"), final); err != nil {
+		t.Fatal(err)
+	}
+	_, contributions, err := phase2.ReadContributions(final)
+	if err != nil {
+		t.Fatal(err)
+	}
+	hash1 := fmt.Sprintf("%x", contributions[0].Hash)
+	hash2 := fmt.Sprintf("%x", contributions[1].Hash)
+	other := strings.Repeat("ab", 32)
+
+	expectedPath := filepath.Join(dir, "hashes.txt")
+	for _, tc := range []struct {
+		expected string
+		valid    bool
+	}{
+		{"# b10\n" + hash1 + " alice\n\nbob `" + hash2 + "`\n", true},
+		{hash1 + "\n", false},
+		{hash1 + "\n" + other + "\n", false},
+		{hash1 + "\n" + hash2 + "\n" + other + "\n", false},
+	} {
+		if err := os.WriteFile(expectedPath, []byte(tc.expected), 0644); err != nil {
+			t.Fatal(err)
+		}
+		if err := audit.CheckHashes(final, expectedPath); (err == nil) != tc.valid {
+			t.Errorf("unexpected result %v for %q", err, tc.expected)
+		}
+	}
+}
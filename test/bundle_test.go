@@ -0,0 +1,112 @@
+package test
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/worldcoin/semaphore-mtb-setup/keys"
+	"github.com/worldcoin/semaphore-mtb-setup/phase2"
+)
+
+func TestBundle(t *testing.T) {
+	dir := t.TempDir()
+	bundle0 := filepath.Join(dir, "0.bundle")
+	bundle1 := filepath.Join(dir, "1.bundle")
+	split := filepath.Join(dir, "split.ph2")
+
+	initializePhase2(t, "bnd_0.ph2")
+	if err := phase2.CreateBundle("bnd_0.ph2", "evals", "srs.lag", "", bundle0); err != nil {
+		t.Fatal(err)
+	}
+
+	// Contributors work on the phase 2 file split from the bundle
+	if err := phase2.SplitBundle(bundle0, split); err != nil {
+		t.Fatal(err)
+	}
+	origin, err := os.ReadFile("bnd_0.ph2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := os.ReadFile(split)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, origin) {
+		t.Fatal("split phase 2 file doesn't match the origin")
+	}
+	if err := phase2.Contribute(split, "bnd_1.ph2"); err != nil {
+		t.Fatal(err)
+	}
+	if err := phase2.RebuildBundle(bundle0, "bnd_1.ph2", bundle1); err != nil {
+		t.Fatal(err)
+	}
+
+	// Bundles are verified and keys extracted without the other files
+	if err := phase2.Verify(bundle1, ""); err != nil {
+		t.Error(err)
+	}
+	if err := phase2.Verify("bnd_1.ph2", bundle1); err != nil {
+		t.Error(err)
+	}
+	if err := phase2.Verify(bundle0, ""); err == nil {
+		t.Error("verification of a bundle without contributions should fail")
+	}
+	if err := keys.ExtractKeys(bundle1, bundle1, filepath.Join(dir, "pk"), filepath.Join(dir, "vk")); err != nil {
+		t.Fatal(err)
+	}
+	if err := keys.ExtractKeys("bnd_1.ph2", "evals", "bnd_pk", "bnd_vk"); err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"pk", "vk"} {
+		fromBundle, err := os.ReadFile(filepath.Join(dir, name))
+		if err != nil {
+			t.Fatal(err)
+		}
+		fromFiles, err := os.ReadFile("bnd_" + name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(fromBundle, fromFiles) {
+			t.Errorf("%s extracted from the bundle doesn't match", name)
+		}
+	}
+
+	// Corrupted sections are detected when rebuilding
+	content, err := os.ReadFile(bundle1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The origin is the first section after the index
+	const indexSize = 9 + 4*52
+	content[indexSize+len(origin)/2] ^= 1
+	corrupted := filepath.Join(dir, "corrupted.bundle")
+	if err := os.WriteFile(corrupted, content, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := phase2.RebuildBundle(corrupted, "bnd_1.ph2", filepath.Join(dir, "2.bundle")); err == nil {
+		t.Error("rebuilding a corrupted bundle should fail")
+	}
+	// A bundle can be rebuilt in place
+	if err := phase2.Contribute("bnd_1.ph2", "bnd_2.ph2"); err != nil {
+		t.Fatal(err)
+	}
+	if err := phase2.RebuildBundle(bundle1, "bnd_2.ph2", bundle1); err != nil {
+		t.Fatal(err)
+	}
+	if err := phase2.Verify(bundle1, ""); err != nil {
+		t.Error(err)
+	}
+	if err := phase2.Verify("bnd_2.ph2", bundle1); err != nil {
+		t.Error(err)
+	}
+
+	// Files forking from the latest contribution of the bundle are rejected
+	if err := phase2.Contribute("bnd_1.ph2", "bnd_fork.ph2"); err != nil {
+		t.Fatal(err)
+	}
+	if err := phase2.RebuildBundle(bundle1, "bnd_fork.ph2", filepath.Join(dir, "fork.bundle")); err == nil {
+		t.Error("rebuilding a bundle with a fork should fail")
+	}
+}
@@ -0,0 +1,141 @@
+package test
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/worldcoin/semaphore-mtb-setup/ceremony"
+	"github.com/worldcoin/semaphore-mtb-setup/common"
+	"github.com/worldcoin/semaphore-mtb-setup/phase2"
+)
+
+func TestCeremony(t *testing.T) {
+	// Generates p2_circuit.r1cs and p2_1.ph1
+	initializePhase2(t, "cer_0.ph2")
+	r1csPath, err := filepath.Abs("p2_circuit.r1cs")
+	if err != nil {
+		t.Fatal(err)
+	}
+	phase1Path, err := filepath.Abs("p2_1.ph1")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	dir := t.TempDir()
+	manifestPath := filepath.Join(dir, "ceremony.json")
+	manifest := `{
+  "circuits": [
+    {"name": "small", "r1cs": "` + r1csPath + `", "phase1": "` + phase1Path + `"},
+    {"name": "large", "r1cs": "` + r1csPath + `", "phase1": "` + phase1Path + `", "dir": "artifacts/large"}
+  ]
+}`
+	if err := os.WriteFile(manifestPath, []byte(manifest), 0644); err != nil {
+		t.Fatal(err)
+	}
+	m, err := ceremony.ReadManifest(manifestPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := m.Select([]string{"medium"}); err == nil {
+		t.Error("selection of an unknown circuit should fail")
+	}
+
+	if err := ceremony.Init(m); err != nil {
+		t.Fatal(err)
+	}
+	if err := ceremony.Init(m); err == nil {
+		t.Error("initializing a circuit twice should fail")
+	}
+	if err := ceremony.Verify(m); err == nil {
+		t.Error("verification without contributions should fail")
+	}
+	for _, name := range []string{"alice", "bob"} {
+		rand, err := common.NewEntropyReader(nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := ceremony.Contribute(m, rand, &phase2.Metadata{Name: name}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// A single circuit can be contributed to on its own
+	small, err := m.Select([]string{"small"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	rand, err := common.NewEntropyReader(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := ceremony.Contribute(small, rand, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := ceremony.Verify(m); err != nil {
+		t.Fatal(err)
+	}
+	if err := ceremony.ExtractKeys(m); err != nil {
+		t.Fatal(err)
+	}
+	for _, path := range []string{"small/smallc3.ph2", "small/pk", "artifacts/large/largec2.ph2", "artifacts/large/vk"} {
+		if _, err := os.Stat(filepath.Join(dir, path)); err != nil {
+			t.Error(err)
+		}
+	}
+	if _, err := os.Stat(filepath.Join(dir, "artifacts/large/largec3.ph2")); err == nil {
+		t.Error("large shouldn't have a third contribution")
+	}
+
+	// Nothing is added to an invalid file
+	latestPath := filepath.Join(dir, "small/smallc3.ph2")
+	content, err := os.ReadFile(latestPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	content[len(content)-1] ^= 1
+	if err := os.WriteFile(latestPath, content, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := ceremony.Contribute(small, rand, nil); err == nil {
+		t.Error("contribution to an invalid file should fail")
+	}
+	if _, err := os.Stat(filepath.Join(dir, "small/smallc4.ph2")); err == nil {
+		t.Error("small shouldn't have a fourth contribution")
+	}
+
+	// The same manifest can be written in YAML
+	yamlPath := filepath.Join(dir, "ceremony.yaml")
+	yamlManifest := `circuits:
+  - name: small
+    r1cs: ` + r1csPath + `
+    phase1: ` + phase1Path + `
+  - name: large
+    r1cs: ` + r1csPath + `
+    phase1: ` + phase1Path + `
+    dir: artifacts/large
+`
+	if err := os.WriteFile(yamlPath, []byte(yamlManifest), 0644); err != nil {
+		t.Fatal(err)
+	}
+	fromYAML, err := ceremony.ReadManifest(yamlPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(fromYAML, m) {
+		t.Errorf("YAML manifest %+v differs from %+v", fromYAML, m)
+	}
+
+	// Names end up in file paths and can't escape the directory of the manifest
+	for _, name := range []string{"", ".", "..", "../small", "large/small", `large\small`} {
+		invalid := fmt.Sprintf(`{"circuits": [{"name": %q, "r1cs": %q, "phase1": %q}]}`, name, r1csPath, phase1Path)
+		if err := os.WriteFile(manifestPath, []byte(invalid), 0644); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := ceremony.ReadManifest(manifestPath); err == nil {
+			t.Errorf("manifest with circuit name %q should be rejected", name)
+		}
+	}
+}
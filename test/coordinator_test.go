@@ -0,0 +1,285 @@
+package test
+
+import (
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/worldcoin/semaphore-mtb-setup/common"
+	"github.com/worldcoin/semaphore-mtb-setup/coordinator"
+	"github.com/worldcoin/semaphore-mtb-setup/phase2"
+)
+
+func TestCoordinator(t *testing.T) {
+	dir := t.TempDir()
+	stateDir := filepath.Join(dir, "state")
+	originA := filepath.Join(dir, "circac0.ph2")
+	originB := filepath.Join(dir, "circbc0.ph2")
+	initializePhase2(t, originA)
+	b, err := os.ReadFile(originA)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(originB, b, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	const adminToken = "admin"
+	server, err := coordinator.NewServer(stateDir, []string{originA, originB}, adminToken)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ts := httptest.NewServer(server.Handler())
+	admin := coordinator.NewClient(ts.URL, adminToken)
+	if _, err := coordinator.NewClient(ts.URL, "guess").Register("mallory"); err == nil {
+		t.Error("registration without the admin token should fail")
+	}
+	aliceToken, err := admin.Register("alice")
+	if err != nil {
+		t.Fatal(err)
+	}
+	bobToken, err := admin.Register("bob")
+	if err != nil {
+		t.Fatal(err)
+	}
+	alice := coordinator.NewClient(ts.URL, aliceToken)
+	bob := coordinator.NewClient(ts.URL, bobToken)
+
+	// Only the current contributor gets the latest files
+	if err := bob.Download("circa", filepath.Join(dir, "early.ph2")); err == nil {
+		t.Error("download before the turn of the contributor should fail")
+	}
+	turn, err := bob.Turn()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if turn.Position != 1 || len(turn.Pending) != 2 {
+		t.Errorf("unexpected turn of bob %+v", turn)
+	}
+
+	contribute := func(client *coordinator.Client, circuit, name string) (*coordinator.Receipt, error) {
+		in := filepath.Join(dir, name+"_in.ph2")
+		out := filepath.Join(dir, name+"_out.ph2")
+		if err := client.Download(circuit, in); err != nil {
+			return nil, err
+		}
+		if err := phase2.Contribute(in, out); err != nil {
+			return nil, err
+		}
+		return client.Upload(circuit, out)
+	}
+
+	// Uploads are rejected unless they add exactly one contribution
+	if _, err := alice.Upload("circa", originA); err == nil {
+		t.Error("upload without a new contribution should be rejected")
+	}
+	if _, err := contribute(alice, "circa", "alice_a"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := alice.Upload("circa", filepath.Join(dir, "alice_a_out.ph2")); err == nil {
+		t.Error("second contribution to the same circuit should be rejected")
+	}
+
+	// A restarted coordinator resumes the ceremony
+	ts.Close()
+	if _, err := coordinator.NewServer(stateDir, []string{originA}, adminToken); err == nil {
+		t.Error("starting a ceremony twice in the same directory should fail")
+	}
+	server, err = coordinator.NewServer(stateDir, nil, adminToken)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ts = httptest.NewServer(server.Handler())
+	defer ts.Close()
+	alice = coordinator.NewClient(ts.URL, aliceToken)
+	bob = coordinator.NewClient(ts.URL, bobToken)
+
+	if _, err := contribute(alice, "circb", "alice_b"); err != nil {
+		t.Fatal(err)
+	}
+	last, err := contribute(bob, "circa", "bob_a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if last.File != "circac2.ph2" {
+		t.Errorf("unexpected file %s", last.File)
+	}
+	if err := phase2.Verify(filepath.Join(stateDir, last.File), originA); err != nil {
+		t.Error(err)
+	}
+
+	status, err := coordinator.NewClient(ts.URL, "").Status()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if status.Current != "bob" || len(status.Queue) != 1 {
+		t.Errorf("unexpected queue %+v", status)
+	}
+	if len(status.Circuits) != 2 || len(status.Circuits[0].Contributions) != 2 || len(status.Circuits[1].Contributions) != 1 {
+		t.Errorf("unexpected circuits %+v", status.Circuits)
+	}
+	if status.Circuits[0].Contributions[1].Hash != last.Hash {
+		t.Error("status doesn't list the latest contribution")
+	}
+}
+
+func TestContribute(t *testing.T) {
+	dir := t.TempDir()
+	origin := filepath.Join(dir, "circc0.ph2")
+	initializePhase2(t, origin)
+
+	server, err := coordinator.NewServer(filepath.Join(dir, "state"), []string{origin}, "admin")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ts := httptest.NewServer(server.Handler())
+	defer ts.Close()
+	admin := coordinator.NewClient(ts.URL, "admin")
+	status, err := admin.Status()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for i, name := range []string{"alice", "bob"} {
+		token, err := admin.Register(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		rand, err := common.NewEntropyReader(nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		// Each contributor works in their own directory
+		work := filepath.Join(dir, name)
+		if err := os.Mkdir(work, 0755); err != nil {
+			t.Fatal(err)
+		}
+		receipts, err := coordinator.NewClient(ts.URL, token).Contribute(work, rand, &phase2.Metadata{Name: name})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(receipts) != 1 || receipts[0].Contribution != i+1 || receipts[0].Name != name {
+			t.Fatalf("unexpected receipts %+v", receipts)
+		}
+		if err := receipts[0].Verify(status.PublicKey); err != nil {
+			t.Error(err)
+		}
+		if _, err := os.Stat(filepath.Join(work, receipts[0].File+coordinator.ReceiptExtension)); err != nil {
+			t.Error(err)
+		}
+		tampered := *receipts[0]
+		tampered.Contribution++
+		if err := tampered.Verify(status.PublicKey); err == nil {
+			t.Error("verification of a tampered receipt should fail")
+		}
+	}
+
+	if err := phase2.Verify(filepath.Join(dir, "bob", "circc2.ph2"), origin); err != nil {
+		t.Error(err)
+	}
+	status, err = admin.Status()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(status.Queue) != 0 || len(status.Circuits[0].Contributions) != 2 {
+		t.Errorf("unexpected status %+v", status)
+	}
+}
+
+func TestConcurrentUploads(t *testing.T) {
+	dir := t.TempDir()
+	origin := filepath.Join(dir, "circdc0.ph2")
+	initializePhase2(t, origin)
+
+	server, err := coordinator.NewServer(filepath.Join(dir, "state"), []string{origin}, "admin")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ts := httptest.NewServer(server.Handler())
+	defer ts.Close()
+	admin := coordinator.NewClient(ts.URL, "admin")
+	token, err := admin.Register("alice")
+	if err != nil {
+		t.Fatal(err)
+	}
+	alice := coordinator.NewClient(ts.URL, token)
+
+	// Two different contributions to the same file are uploaded at once, only one of them is accepted
+	outputs := []string{filepath.Join(dir, "first.ph2"), filepath.Join(dir, "second.ph2")}
+	for _, output := range outputs {
+		if err := phase2.Contribute(origin, output); err != nil {
+			t.Fatal(err)
+		}
+	}
+	errs := make(chan error, len(outputs))
+	for _, output := range outputs {
+		go func(output string) {
+			_, err := alice.Upload("circd", output)
+			errs <- err
+		}(output)
+	}
+	accepted := 0
+	for range outputs {
+		if err := <-errs; err == nil {
+			accepted++
+		}
+	}
+	if accepted != 1 {
+		t.Errorf("%d concurrent uploads have been accepted", accepted)
+	}
+
+	status, err := admin.Status()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(status.Queue) != 0 || len(status.Circuits[0].Contributions) != 1 {
+		t.Errorf("unexpected status %+v", status)
+	}
+	if err := phase2.Verify(filepath.Join(dir, "state", "circdc1.ph2"), origin); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestUploadNotSaved(t *testing.T) {
+	dir := t.TempDir()
+	stateDir := filepath.Join(dir, "state")
+	origin := filepath.Join(dir, "circec0.ph2")
+	initializePhase2(t, origin)
+
+	server, err := coordinator.NewServer(stateDir, []string{origin}, "admin")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ts := httptest.NewServer(server.Handler())
+	defer ts.Close()
+	token, err := coordinator.NewClient(ts.URL, "admin").Register("alice")
+	if err != nil {
+		t.Fatal(err)
+	}
+	alice := coordinator.NewClient(ts.URL, token)
+	output := filepath.Join(dir, "out.ph2")
+	if err := phase2.Contribute(origin, output); err != nil {
+		t.Fatal(err)
+	}
+
+	// The state can't be saved while a directory takes the place of its temporary file
+	blocker := filepath.Join(stateDir, "state.json.tmp")
+	if err := os.Mkdir(blocker, 0755); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := alice.Upload("circe", output); err == nil {
+		t.Fatal("upload should fail when the state can't be saved")
+	}
+	if _, err := os.Stat(filepath.Join(stateDir, "circec1.ph2")); !os.IsNotExist(err) {
+		t.Error("the file of a contribution that wasn't saved is left behind")
+	}
+
+	// The contributor keeps their turn
+	if err := os.Remove(blocker); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := alice.Upload("circe", output); err != nil {
+		t.Error(err)
+	}
+}
@@ -81,7 +81,7 @@ func TestSetup(t *testing.T) {
 	}
 
 	// Phase 2 initialization
-	if err := phase2.Initialize("4.ph1", "circuit.r1cs", "0.ph2"); err != nil {
+	if err := phase2.Initialize("4.ph1", "circuit.r1cs", "0.ph2", "srs.lag", "evals"); err != nil {
 		t.Error(err)
 	}
 
@@ -103,7 +103,7 @@ func TestSetup(t *testing.T) {
 		t.Error(err)
 	}
 
-	if err := keys.ExtractKeys("3.ph2"); err != nil {
+	if err := keys.ExtractKeys("3.ph2", "evals", "pk", "vk"); err != nil {
 		t.Error(err)
 	}
 }
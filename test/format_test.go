@@ -0,0 +1,167 @@
+package test
+
+import (
+	"bytes"
+	"encoding/gob"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/worldcoin/semaphore-mtb-setup/common"
+	"github.com/worldcoin/semaphore-mtb-setup/phase1"
+	"github.com/worldcoin/semaphore-mtb-setup/phase2"
+)
+
+// toLegacy strips the magic, version and curve ID and the trailing digest of a file
+func toLegacy(inputPath, outputPath string) error {
+	content, err := os.ReadFile(inputPath)
+	if err != nil {
+		return err
+	}
+	const prefixSize = 7
+	return os.WriteFile(outputPath, content[prefixSize:len(content)-common.DigestSize], 0644)
+}
+
+// toPreTranscript rewrites the origin of a ceremony as a legacy file of a ceremony without transcript nor metadata
+func toPreTranscript(inputPath, outputPath string) error {
+	content, err := os.ReadFile(inputPath)
+	if err != nil {
+		return err
+	}
+	reader := bytes.NewReader(content[:len(content)-common.DigestSize])
+	var header phase2.Header
+	if err := header.Read(reader); err != nil {
+		return err
+	}
+	header.Transcript, header.Metadata = false, false
+	var output bytes.Buffer
+	if err := gob.NewEncoder(&output).Encode(header); err != nil {
+		return err
+	}
+	if _, err := reader.WriteTo(&output); err != nil {
+		return err
+	}
+	return os.WriteFile(outputPath, output.Bytes(), 0644)
+}
+
+func TestLegacyFormat(t *testing.T) {
+	// Phase 1
+	if err := phase1.Initialize(8, "fmt_0.ph1"); err != nil {
+		t.Fatal(err)
+	}
+	if err := phase1.Contribute("fmt_0.ph1", "fmt_1.ph1"); err != nil {
+		t.Fatal(err)
+	}
+	if err := toLegacy("fmt_1.ph1", "fmt_legacy_1.ph1"); err != nil {
+		t.Fatal(err)
+	}
+	if err := phase1.Verify("fmt_legacy_1.ph1", ""); err != nil {
+		t.Error(err)
+	}
+	// Legacy files are upgraded by new contributions
+	if err := phase1.Contribute("fmt_legacy_1.ph1", "fmt_2.ph1"); err != nil {
+		t.Fatal(err)
+	}
+	if err := phase1.Verify("fmt_2.ph1", ""); err != nil {
+		t.Error(err)
+	}
+
+	// Phase 2
+	initializePhase2(t, "fmt_0.ph2")
+	if err := phase2.Contribute("fmt_0.ph2", "fmt_1.ph2"); err != nil {
+		t.Fatal(err)
+	}
+	if err := toLegacy("fmt_0.ph2", "fmt_legacy_0.ph2"); err != nil {
+		t.Fatal(err)
+	}
+	if err := toLegacy("fmt_1.ph2", "fmt_legacy_1.ph2"); err != nil {
+		t.Fatal(err)
+	}
+	if err := phase2.Verify("fmt_legacy_1.ph2", "fmt_legacy_0.ph2"); err != nil {
+		t.Error(err)
+	}
+	if err := phase2.Contribute("fmt_legacy_1.ph2", "fmt_2.ph2"); err != nil {
+		t.Fatal(err)
+	}
+	if err := phase2.Verify("fmt_2.ph2", "fmt_legacy_0.ph2"); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestFormatChecks(t *testing.T) {
+	if err := phase1.Initialize(8, "fmt_check.ph1"); err != nil {
+		t.Fatal(err)
+	}
+
+	// A phase 1 file isn't mistaken for a phase 2 one
+	if err := phase2.Contribute("fmt_check.ph1", "fmt_check.ph2"); err == nil {
+		t.Error("contributing to a phase 1 file as phase 2 should fail")
+	}
+
+	// Corruption is detected by the digest
+	content, err := os.ReadFile("fmt_check.ph1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	content[len(content)/2] ^= 1
+	if err := os.WriteFile("fmt_corrupted.ph1", content, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := phase1.Contribute("fmt_corrupted.ph1", "fmt_corrupted_1.ph1"); err == nil {
+		t.Error("contributing to a corrupted file should fail")
+	}
+
+	// Phase 2 digests are checked in the same pass as the rest of the file
+	initializePhase2(t, "fmt_check_0.ph2")
+	if err := phase2.Contribute("fmt_check_0.ph2", "fmt_check_1.ph2"); err != nil {
+		t.Fatal(err)
+	}
+	content, err = os.ReadFile("fmt_check_1.ph2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	content[len(content)-1] ^= 1
+	if err := os.WriteFile("fmt_corrupted.ph2", content, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := phase2.Verify("fmt_corrupted.ph2", "fmt_check_0.ph2"); err == nil {
+		t.Error("verification of a corrupted file should fail")
+	}
+	if err := phase2.VerifyIncremental("fmt_corrupted.ph2", "fmt_check_0.ph2"); err == nil {
+		t.Error("incremental verification of a corrupted file should fail")
+	}
+	if err := phase2.Contribute("fmt_corrupted.ph2", "fmt_corrupted_1.ph2"); err == nil {
+		t.Error("contributing to a corrupted file should fail")
+	}
+	if _, err := os.Stat("fmt_corrupted_1.ph2"); !os.IsNotExist(err) {
+		t.Error("the output of a corrupted file should be removed")
+	}
+}
+
+func TestNoTranscript(t *testing.T) {
+	initializePhase2(t, "nt_origin.ph2")
+	if err := toPreTranscript("nt_origin.ph2", "nt_0.ph2"); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 2; i++ {
+		if err := phase2.Contribute(fmt.Sprintf("nt_%d.ph2", i), fmt.Sprintf("nt_%d.ph2", i+1)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := phase2.Verify("nt_2.ph2", "nt_0.ph2"); err != nil {
+		t.Fatal(err)
+	}
+
+	// Without a transcript, nothing binds the contributions that would be skipped
+	if err := phase2.VerifyIncremental("nt_2.ph2", "nt_1.ph2"); err == nil {
+		t.Error("incremental verification without a transcript should fail")
+	}
+	checkpoint := filepath.Join(t.TempDir(), "nt.ckpt")
+	if err := phase2.VerifyWithCheckpoint("nt_1.ph2", "nt_0.ph2", checkpoint); err == nil {
+		t.Error("writing a checkpoint without a transcript should fail")
+	}
+	if _, err := os.Stat(checkpoint); err == nil {
+		t.Error("a checkpoint was written without a transcript")
+	}
+}
@@ -0,0 +1,99 @@
+package test
+
+import (
+	"bytes"
+	"flag"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/consensys/gnark-crypto/ecc/bn254"
+	"github.com/consensys/gnark/frontend"
+	"github.com/consensys/gnark/frontend/cs/r1cs"
+	"github.com/worldcoin/semaphore-mtb-setup/common"
+	"github.com/worldcoin/semaphore-mtb-setup/keys"
+	"github.com/worldcoin/semaphore-mtb-setup/phase1"
+	"github.com/worldcoin/semaphore-mtb-setup/phase2"
+)
+
+var update = flag.Bool("update", false, "update golden files in testdata/golden")
+
+// TestGolden runs a seeded ceremony and compares its outputs byte by byte to the golden files.
+// Run `go test -run TestGolden -update` to regenerate them after an intended format change
+func TestGolden(t *testing.T) {
+	var myCircuit Circuit
+	ccs, err := frontend.Compile(bn254.ID.ScalarField(), r1cs.NewBuilder, &myCircuit)
+	if err != nil {
+		t.Fatal(err)
+	}
+	writer, err := os.Create("golden.r1cs")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer writer.Close()
+	if _, err := ccs.WriteTo(writer); err != nil {
+		t.Fatal(err)
+	}
+
+	// Phase 1
+	if err := phase1.Initialize(9, "golden_0.ph1"); err != nil {
+		t.Fatal(err)
+	}
+	if err := contributePhase1("golden_0.ph1", "golden_1.ph1", "golden phase 1 contribution 1"); err != nil {
+		t.Fatal(err)
+	}
+	if err := contributePhase1("golden_1.ph1", "golden.ph1", "golden phase 1 contribution 2"); err != nil {
+		t.Fatal(err)
+	}
+
+	// Phase 2
+	if err := phase2.Initialize("golden.ph1", "golden.r1cs", "golden_0.ph2", "srs.lag", "evals"); err != nil {
+		t.Fatal(err)
+	}
+	if err := contributePhase2("golden_0.ph2", "golden_1.ph2", "golden phase 2 contribution 1"); err != nil {
+		t.Fatal(err)
+	}
+	if err := contributePhase2("golden_1.ph2", "golden.ph2", "golden phase 2 contribution 2"); err != nil {
+		t.Fatal(err)
+	}
+	if err := keys.ExtractKeys("golden.ph2", "evals", "pk", "vk"); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, name := range []string{"golden.ph1", "golden.ph2", "pk", "vk"} {
+		got, err := os.ReadFile(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		path := filepath.Join("testdata", "golden", name)
+		if *update {
+			if err := os.WriteFile(path, got, 0644); err != nil {
+				t.Fatal(err)
+			}
+			continue
+		}
+		want, err := os.ReadFile(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(got, want) {
+			t.Errorf("%s doesn't match %s", name, path)
+		}
+	}
+}
+
+func contributePhase1(inputPath, outputPath, seed string) error {
+	rand, err := common.NewSeededReader([]byte(seed))
+	if err != nil {
+		return err
+	}
+	return phase1.ContributeWithRand(inputPath, outputPath, rand)
+}
+
+func contributePhase2(inputPath, outputPath, seed string) error {
+	rand, err := common.NewSeededReader([]byte(seed))
+	if err != nil {
+		return err
+	}
+	return phase2.ContributeWithRand(inputPath, outputPath, rand, nil)
+}
@@ -0,0 +1,139 @@
+package test
+
+import (
+	"bytes"
+	"math"
+	"os"
+	"testing"
+
+	"github.com/worldcoin/semaphore-mtb-setup/common"
+	"github.com/worldcoin/semaphore-mtb-setup/phase1"
+)
+
+func TestPhase1(t *testing.T) {
+	var power byte = 8
+
+	// Initialize, contribute and verify
+	if err := phase1.Initialize(power, "p1_0.ph1"); err != nil {
+		t.Fatal(err)
+	}
+	if err := phase1.Verify("p1_0.ph1", ""); err != nil {
+		t.Error(err)
+	}
+	if err := phase1.Contribute("p1_0.ph1", "p1_1.ph1"); err != nil {
+		t.Fatal(err)
+	}
+	if err := phase1.ContributeWithEntropy("p1_1.ph1", "p1_2.ph1", []byte("some entropy")); err != nil {
+		t.Fatal(err)
+	}
+	if err := phase1.Verify("p1_2.ph1", ""); err != nil {
+		t.Error(err)
+	}
+
+	// Swapping two powers of TauG1 must be detected
+	params, err := os.ReadFile("p1_2.ph1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	const G1Size = 32
+	const headerSize = 10
+	first, second := headerSize+5*G1Size, headerSize+6*G1Size
+	tmp := make([]byte, G1Size)
+	copy(tmp, params[first:first+G1Size])
+	copy(params[first:first+G1Size], params[second:second+G1Size])
+	copy(params[second:second+G1Size], tmp)
+	if err := writeWithDigest("p1_tampered.ph1", params[:len(params)-common.DigestSize]); err != nil {
+		t.Fatal(err)
+	}
+	if err := phase1.Verify("p1_tampered.ph1", ""); err == nil {
+		t.Error("verification of tampered parameters should fail")
+	}
+}
+
+// The linear combinations checked by Verify must cover every power, up to the last one,
+// and TauG2 must be checked in the same direction as TauG1
+func TestPhase1Tampering(t *testing.T) {
+	var power byte = 4
+	N := int(math.Pow(2, float64(power)))
+	if err := phase1.Initialize(power, "p1v_0.ph1"); err != nil {
+		t.Fatal(err)
+	}
+	if err := phase1.Contribute("p1v_0.ph1", "p1v_1.ph1"); err != nil {
+		t.Fatal(err)
+	}
+	if err := phase1.Verify("p1v_1.ph1", ""); err != nil {
+		t.Fatal(err)
+	}
+	params, err := os.ReadFile("p1v_1.ph1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var header phase1.Header
+	if _, err := header.ReadFrom(bytes.NewReader(params)); err != nil {
+		t.Fatal(err)
+	}
+	const G1Size = 32
+	const G2Size = 64
+	posTauG1 := int(header.Size())
+	posTauG2 := posTauG1 + (2*N-1)*G1Size + 2*N*G1Size
+
+	for _, tampering := range []struct {
+		name          string
+		first, second int
+		size          int
+	}{
+		{"last powers of TauG1", posTauG1 + (2*N-3)*G1Size, posTauG1 + (2*N-2)*G1Size, G1Size},
+		{"powers of TauG2", posTauG2 + 2*G2Size, posTauG2 + 3*G2Size, G2Size},
+	} {
+		tampered := make([]byte, len(params)-common.DigestSize)
+		copy(tampered, params)
+		first, second, size := tampering.first, tampering.second, tampering.size
+		copy(tampered[first:first+size], params[second:second+size])
+		copy(tampered[second:second+size], params[first:first+size])
+		if err := writeWithDigest("p1v_tampered.ph1", tampered); err != nil {
+			t.Fatal(err)
+		}
+		if err := phase1.Verify("p1v_tampered.ph1", ""); err == nil {
+			t.Errorf("swapping the %s should fail verification", tampering.name)
+		}
+	}
+}
+
+func TestPhase1Transformed(t *testing.T) {
+	if err := writeChallenge("p1_challenge", 9); err != nil {
+		t.Fatal(err)
+	}
+	if err := phase1.Transform("p1_challenge", "p1t_0.ph1", 9, 8); err != nil {
+		t.Fatal(err)
+	}
+	if err := phase1.Verify("p1t_0.ph1", "p1t_0.ph1"); err != nil {
+		t.Error(err)
+	}
+	if err := phase1.Contribute("p1t_0.ph1", "p1t_1.ph1"); err != nil {
+		t.Fatal(err)
+	}
+	if err := phase1.Contribute("p1t_1.ph1", "p1t_2.ph1"); err != nil {
+		t.Fatal(err)
+	}
+	if err := phase1.Verify("p1t_2.ph1", "p1t_0.ph1"); err != nil {
+		t.Error(err)
+	}
+
+	// Contributions don't build on the generators but on the transformed origin
+	if err := phase1.Verify("p1t_2.ph1", ""); err == nil {
+		t.Error("verification without the transformed origin should fail")
+	}
+}
+
+// writeWithDigest writes the content followed by its digest, as a well-formed file would be
+func writeWithDigest(path string, content []byte) error {
+	file, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	if _, err := file.Write(content); err != nil {
+		return err
+	}
+	return common.AppendDigest(file)
+}
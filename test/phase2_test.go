@@ -0,0 +1,509 @@
+package test
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/gob"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/consensys/gnark-crypto/ecc/bn254"
+	"github.com/consensys/gnark/frontend"
+	"github.com/consensys/gnark/frontend/cs/r1cs"
+	"github.com/worldcoin/semaphore-mtb-setup/common"
+	"github.com/worldcoin/semaphore-mtb-setup/keys"
+	"github.com/worldcoin/semaphore-mtb-setup/phase1"
+	"github.com/worldcoin/semaphore-mtb-setup/phase2"
+)
+
+// initializePhase2 runs a small phase 1 and initializes phase 2 of the example circuit into originPath
+func initializePhase2(t *testing.T, originPath string) {
+	var myCircuit Circuit
+	ccs, err := frontend.Compile(bn254.ID.ScalarField(), r1cs.NewBuilder, &myCircuit)
+	if err != nil {
+		t.Fatal(err)
+	}
+	writer, err := os.Create("p2_circuit.r1cs")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer writer.Close()
+	if _, err := ccs.WriteTo(writer); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := phase1.Initialize(9, "p2_0.ph1"); err != nil {
+		t.Fatal(err)
+	}
+	if err := phase1.Contribute("p2_0.ph1", "p2_1.ph1"); err != nil {
+		t.Fatal(err)
+	}
+	if err := phase2.Initialize("p2_1.ph1", "p2_circuit.r1cs", originPath, "srs.lag", "evals"); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestBeacon(t *testing.T) {
+	initializePhase2(t, "b_0.ph2")
+	if err := phase2.ContributeWithEntropy("b_0.ph2", "b_1.ph2", []byte("some entropy")); err != nil {
+		t.Fatal(err)
+	}
+
+	beacon := []byte("00000000000000000002a7c4c1e48d76c5a37902165a270156b7a8d72728a054")
+	if err := phase2.Beacon("b_1.ph2", "b_2.ph2", beacon, 1024); err != nil {
+		t.Fatal(err)
+	}
+	if err := phase2.Verify("b_2.ph2", "b_0.ph2"); err != nil {
+		t.Error(err)
+	}
+
+	// No contribution is accepted after the beacon
+	if err := phase2.Contribute("b_2.ph2", "b_3.ph2"); err == nil {
+		t.Error("contributing after the beacon should fail")
+	}
+
+	// Recording other beacon parameters must be detected
+	if err := rewriteHeader("b_2.ph2", "b_tampered.ph2", func(h *phase2.Header) {
+		h.BeaconIterations++
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if err := phase2.Verify("b_tampered.ph2", "b_0.ph2"); err == nil {
+		t.Error("verification with mismatching beacon parameters should fail")
+	}
+
+	// The beacon claim is part of the contribution hash, it can't be dropped either
+	if err := rewriteHeader("b_2.ph2", "b_tampered.ph2", func(h *phase2.Header) {
+		h.Beacon = nil
+		h.BeaconIterations = 0
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if err := phase2.Verify("b_tampered.ph2", "b_0.ph2"); err == nil {
+		t.Error("verification without the beacon parameters should fail")
+	}
+}
+
+// rewriteHeader updates the header of a phase 2 file, keeping the file well-formed
+func rewriteHeader(inputPath, outputPath string, update func(*phase2.Header)) error {
+	content, err := os.ReadFile(inputPath)
+	if err != nil {
+		return err
+	}
+	reader := bytes.NewReader(content)
+	var header phase2.Header
+	if err := header.Read(reader); err != nil {
+		return err
+	}
+	update(&header)
+
+	// Magic, version and curve ID are followed by the gob header
+	const prefixSize = 7
+	var buff bytes.Buffer
+	buff.Write(content[:prefixSize])
+	if err := gob.NewEncoder(&buff).Encode(header); err != nil {
+		return err
+	}
+	rest := content[len(content)-reader.Len() : len(content)-common.DigestSize]
+	buff.Write(rest)
+	return writeWithDigest(outputPath, buff.Bytes())
+}
+
+func TestPhase2Binding(t *testing.T) {
+	initializePhase2(t, "bind_0.ph2")
+	if err := phase2.Contribute("bind_0.ph2", "bind_1.ph2"); err != nil {
+		t.Fatal(err)
+	}
+
+	// Same circuit with another phase 1 file, which also overwrites the evaluations
+	if err := phase1.Contribute("p2_1.ph1", "p2_2.ph1"); err != nil {
+		t.Fatal(err)
+	}
+	if err := phase2.Initialize("p2_2.ph1", "p2_circuit.r1cs", "bind_other_0.ph2", "srs.lag", "evals"); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := phase2.Verify("bind_1.ph2", "bind_other_0.ph2"); err == nil {
+		t.Error("verification against an origin from another phase 1 file should fail")
+	}
+	if err := keys.ExtractKeys("bind_1.ph2", "evals", "pk", "vk"); err == nil {
+		t.Error("extracting keys with evaluations from another phase 1 file should fail")
+	}
+}
+
+func TestTranscript(t *testing.T) {
+	initializePhase2(t, "tr_0.ph2")
+	if err := phase2.Contribute("tr_0.ph2", "tr_1.ph2"); err != nil {
+		t.Fatal(err)
+	}
+	if err := phase2.Contribute("tr_1.ph2", "tr_2.ph2"); err != nil {
+		t.Fatal(err)
+	}
+	if err := phase2.Verify("tr_2.ph2", "tr_0.ph2"); err != nil {
+		t.Fatal(err)
+	}
+
+	// Contribution records are δ₁, s, sx, spx, empty metadata, parameters digest, and hash
+	const contributionSize = 32 + 32 + 32 + 64 + 4 + 32 + 32
+	const paramsDigestOffset = 32 + 32 + 32 + 64 + 4
+	final, err := os.ReadFile("tr_2.ph2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	contributions := final[len(final)-common.DigestSize-2*contributionSize : len(final)-common.DigestSize]
+
+	// The first contribution pins down the parameters of the intermediate file
+	intermediate, err := os.ReadFile("tr_1.ph2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	reader := bytes.NewReader(intermediate)
+	var header phase2.Header
+	if err := header.Read(reader); err != nil {
+		t.Fatal(err)
+	}
+	params := intermediate[len(intermediate)-reader.Len() : len(intermediate)-common.DigestSize-contributionSize]
+	digest := sha256.Sum256(params)
+	if !bytes.Equal(digest[:], contributions[paramsDigestOffset:paramsDigestOffset+32]) {
+		t.Error("parameters digest of the first contribution doesn't match the intermediate file")
+	}
+
+	// Altering the digest of a previous contribution breaks the chain
+	tampered := append([]byte{}, final[:len(final)-common.DigestSize]...)
+	tampered[len(tampered)-2*contributionSize+paramsDigestOffset] ^= 1
+	if err := writeWithDigest("tr_tampered.ph2", tampered); err != nil {
+		t.Fatal(err)
+	}
+	if err := phase2.Verify("tr_tampered.ph2", "tr_0.ph2"); err == nil {
+		t.Error("verification of a tampered transcript should fail")
+	}
+}
+
+func TestMetadata(t *testing.T) {
+	initializePhase2(t, "md_0.ph2")
+	rand, err := common.NewEntropyReader(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	metadata := phase2.Metadata{
+		Name:        "Alice",
+		Comment:     "Contributed from an air-gapped laptop",
+		Timestamp:   time.Now(),
+		ToolVersion: "test",
+	}
+	if err := phase2.ContributeWithRand("md_0.ph2", "md_1.ph2", rand, &metadata); err != nil {
+		t.Fatal(err)
+	}
+	if err := phase2.Contribute("md_1.ph2", "md_2.ph2"); err != nil {
+		t.Fatal(err)
+	}
+	if err := phase2.Verify("md_2.ph2", "md_0.ph2"); err != nil {
+		t.Fatal(err)
+	}
+
+	// Metadata is covered by the contribution hash
+	content, err := os.ReadFile("md_2.ph2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	pos := bytes.Index(content, []byte("Alice"))
+	if pos < 0 {
+		t.Fatal("metadata isn't recorded in the file")
+	}
+	content[pos] = 'M'
+	if err := writeWithDigest("md_tampered.ph2", content[:len(content)-common.DigestSize]); err != nil {
+		t.Fatal(err)
+	}
+	if err := phase2.Verify("md_tampered.ph2", "md_0.ph2"); err == nil {
+		t.Error("verification of tampered metadata should fail")
+	}
+	// Ceremonies without metadata reject it before anything is written
+	if err := rewriteHeader("md_0.ph2", "md_none.ph2", func(h *phase2.Header) {
+		h.Metadata = false
+	}); err != nil {
+		t.Fatal(err)
+	}
+	input, err := os.Open("md_none.ph2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer input.Close()
+	var output bytes.Buffer
+	if err := phase2.ContributeStream(input, &output, rand, &metadata); err == nil {
+		t.Error("recording metadata in a ceremony without metadata should fail")
+	}
+	if output.Len() != 0 {
+		t.Errorf("%d bytes were written before rejecting the metadata", output.Len())
+	}
+}
+
+func TestVerifyIncremental(t *testing.T) {
+	initializePhase2(t, "inc_0.ph2")
+	if err := phase2.Contribute("inc_0.ph2", "inc_1.ph2"); err != nil {
+		t.Fatal(err)
+	}
+	if err := phase2.Contribute("inc_1.ph2", "inc_2.ph2"); err != nil {
+		t.Fatal(err)
+	}
+	if err := phase2.VerifyIncremental("inc_1.ph2", "inc_0.ph2"); err != nil {
+		t.Error(err)
+	}
+	if err := phase2.VerifyIncremental("inc_2.ph2", "inc_1.ph2"); err != nil {
+		t.Error(err)
+	}
+
+	// Exactly one new contribution is expected
+	if err := phase2.VerifyIncremental("inc_2.ph2", "inc_0.ph2"); err == nil {
+		t.Error("verification of two new contributions should fail")
+	}
+
+	// Earlier contributions must be untouched
+	const contributionSize = 32 + 32 + 32 + 64 + 4 + 32 + 32
+	content, err := os.ReadFile("inc_2.ph2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	tampered := content[:len(content)-common.DigestSize]
+	tampered[len(tampered)-contributionSize-1] ^= 1
+	if err := writeWithDigest("inc_tampered.ph2", tampered); err != nil {
+		t.Fatal(err)
+	}
+	if err := phase2.VerifyIncremental("inc_tampered.ph2", "inc_1.ph2"); err == nil {
+		t.Error("verification with a modified earlier contribution should fail")
+	}
+}
+
+func TestCheckpoint(t *testing.T) {
+	dir := t.TempDir()
+	checkpoint1 := filepath.Join(dir, "1.ckpt")
+	checkpoint2 := filepath.Join(dir, "2.ckpt")
+
+	initializePhase2(t, "ckpt_0.ph2")
+	for i := 0; i < 3; i++ {
+		if err := phase2.Contribute(fmt.Sprintf("ckpt_%d.ph2", i), fmt.Sprintf("ckpt_%d.ph2", i+1)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := phase2.VerifyWithCheckpoint("ckpt_1.ph2", "ckpt_0.ph2", checkpoint1); err != nil {
+		t.Fatal(err)
+	}
+	if err := phase2.VerifyFromCheckpoint("ckpt_2.ph2", checkpoint1, checkpoint2); err != nil {
+		t.Error(err)
+	}
+	if err := phase2.VerifyFromCheckpoint("ckpt_3.ph2", checkpoint1, ""); err != nil {
+		t.Error(err)
+	}
+	if err := phase2.VerifyFromCheckpoint("ckpt_3.ph2", checkpoint2, ""); err != nil {
+		t.Error(err)
+	}
+
+	// Files without new contributions or from another branch of the ceremony are refused
+	if err := phase2.VerifyFromCheckpoint("ckpt_2.ph2", checkpoint2, ""); err == nil {
+		t.Error("verification without new contributions should fail")
+	}
+	if err := phase2.Contribute("ckpt_1.ph2", "ckpt_fork.ph2"); err != nil {
+		t.Fatal(err)
+	}
+	if err := phase2.VerifyFromCheckpoint("ckpt_fork.ph2", checkpoint2, ""); err == nil {
+		t.Error("verification of another branch of the ceremony should fail")
+	}
+
+	// Parameters must be updated consistently
+	content, err := os.ReadFile("ckpt_3.ph2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	reader := bytes.NewReader(content)
+	var header phase2.Header
+	if err := header.Read(reader); err != nil {
+		t.Fatal(err)
+	}
+	tampered := content[:len(content)-common.DigestSize]
+	// Flip a bit of the first point of Z, after δ₁ and δ₂
+	tampered[len(content)-reader.Len()+32+64+31] ^= 1
+	if err := writeWithDigest("ckpt_tampered.ph2", tampered); err != nil {
+		t.Fatal(err)
+	}
+	if err := phase2.VerifyFromCheckpoint("ckpt_tampered.ph2", checkpoint2, ""); err == nil {
+		t.Error("verification of tampered parameters should fail")
+	}
+}
+
+func TestCheckInitialization(t *testing.T) {
+	initializePhase2(t, "chk_0.ph2")
+	if err := phase2.CheckInitialization("p2_1.ph1", "p2_circuit.r1cs", "chk_0.ph2", "srs.lag", "evals"); err != nil {
+		t.Fatal(err)
+	}
+
+	// The first divergence is reported by section
+	content, err := os.ReadFile("chk_0.ph2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	reader := bytes.NewReader(content)
+	var header phase2.Header
+	if err := header.Read(reader); err != nil {
+		t.Fatal(err)
+	}
+	tampered := content[:len(content)-common.DigestSize]
+	// Flip a bit of the second point of PKK, after δ₁, δ₂ and Z
+	tampered[len(content)-reader.Len()+32+64+32*header.Domain+32+31] ^= 1
+	if err := writeWithDigest("chk_tampered.ph2", tampered); err != nil {
+		t.Fatal(err)
+	}
+	err = phase2.CheckInitialization("p2_1.ph1", "p2_circuit.r1cs", "chk_tampered.ph2", "", "")
+	if err == nil || !strings.Contains(err.Error(), "section PKK at point 1") {
+		t.Errorf("unexpected result %v for tampered PKK", err)
+	}
+
+	evals, err := os.ReadFile("evals")
+	if err != nil {
+		t.Fatal(err)
+	}
+	evals[len(evals)-1] ^= 1
+	if err := os.WriteFile("chk_evals", evals, 0644); err != nil {
+		t.Fatal(err)
+	}
+	err = phase2.CheckInitialization("p2_1.ph1", "p2_circuit.r1cs", "chk_0.ph2", "", "chk_evals")
+	if err == nil || !strings.Contains(err.Error(), "section CommitmentInfo") {
+		t.Errorf("unexpected result %v for tampered evaluations", err)
+	}
+}
+
+func TestContributeMulti(t *testing.T) {
+	initializePhase2(t, "multi_a0.ph2")
+	origin, err := os.ReadFile("multi_a0.ph2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile("multi_b0.ph2", origin, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	files := []phase2.ContributionFiles{
+		{Input: "multi_a0.ph2", Output: "multi_a1.ph2"},
+		{Input: "multi_b0.ph2", Output: "multi_b1.ph2"},
+	}
+	rand, err := common.NewEntropyReader(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := phase2.ContributeMulti([]phase2.ContributionFiles{files[0], files[0]}, rand, nil); err == nil {
+		t.Error("contribution writing the same output twice should fail")
+	}
+	chained := []phase2.ContributionFiles{files[0], {Input: files[0].Output, Output: "multi_a2.ph2"}}
+	if _, err := phase2.ContributeMulti(chained, rand, nil); err == nil {
+		t.Error("contribution reading an output of the same run should fail")
+	}
+	receipt, err := phase2.ContributeMulti(files, rand, &phase2.Metadata{Name: "alice", Timestamp: time.Now()})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var deltas []bn254.G1Affine
+	for i, f := range files {
+		if err := phase2.Verify(f.Output, "multi_a0.ph2"); err != nil {
+			t.Fatal(err)
+		}
+		_, contributions, err := phase2.ReadContributions(f.Output)
+		if err != nil {
+			t.Fatal(err)
+		}
+		last := contributions[len(contributions)-1]
+		c := receipt.Contributions[i]
+		if c.Output != f.Output || c.Contribution != len(contributions) || c.Hash != fmt.Sprintf("%x", last.Hash) {
+			t.Errorf("receipt doesn't match %s: %+v", f.Output, c)
+		}
+		if last.Metadata == nil || last.Metadata.Name != "alice" {
+			t.Errorf("%s doesn't record the metadata", f.Output)
+		}
+		deltas = append(deltas, last.Delta)
+	}
+
+	// Both files start from the same parameters, so they only diverge with distinct δ
+	if deltas[0].Equal(&deltas[1]) {
+		t.Error("files were contributed to with the same δ")
+	}
+
+	// A corrupted input doesn't prevent the contribution to the other files, and its output is removed
+	corrupted := append([]byte(nil), origin...)
+	corrupted[len(corrupted)-1] ^= 1
+	if err := os.WriteFile("multi_c0.ph2", corrupted, 0644); err != nil {
+		t.Fatal(err)
+	}
+	files = []phase2.ContributionFiles{
+		{Input: "multi_c0.ph2", Output: "multi_c1.ph2"},
+		{Input: "multi_a1.ph2", Output: "multi_a2.ph2"},
+	}
+	receipt, err = phase2.ContributeMulti(files, rand, nil)
+	if err == nil {
+		t.Fatal("contribution to a corrupted file should fail")
+	}
+	if receipt == nil || len(receipt.Contributions) != 1 || receipt.Contributions[0].Output != "multi_a2.ph2" {
+		t.Errorf("receipt doesn't list the successful contribution only: %+v", receipt)
+	}
+	if _, err := os.Stat("multi_c1.ph2"); !os.IsNotExist(err) {
+		t.Error("output of the corrupted file is left behind")
+	}
+	if err := phase2.Verify("multi_a2.ph2", "multi_a0.ph2"); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestContributeStream(t *testing.T) {
+	initializePhase2(t, "stream_0.ph2")
+	if err := phase2.Contribute("stream_0.ph2", "stream_1.ph2"); err != nil {
+		t.Fatal(err)
+	}
+	input, err := os.ReadFile("stream_1.ph2")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Streaming produces the same file as a contribution on disk with the same randomness
+	rand, err := common.NewSeededReader([]byte("stream"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var output bytes.Buffer
+	if err := phase2.ContributeStream(bytes.NewReader(input), &output, rand, nil); err != nil {
+		t.Fatal(err)
+	}
+	rand, err = common.NewSeededReader([]byte("stream"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := phase2.ContributeWithRand("stream_1.ph2", "stream_2.ph2", rand, nil); err != nil {
+		t.Fatal(err)
+	}
+	expected, err := os.ReadFile("stream_2.ph2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(output.Bytes(), expected) {
+		t.Fatal("streamed contribution doesn't match the one on disk")
+	}
+	if err := phase2.Verify("stream_2.ph2", "stream_0.ph2"); err != nil {
+		t.Fatal(err)
+	}
+
+	// The digest of the input is checked once it has been read
+	tampered := append([]byte{}, input...)
+	tampered[len(tampered)/2] ^= 1
+	truncated := input[:len(input)-1]
+	trailing := append(append([]byte{}, input...), 0)
+	for name, in := range map[string][]byte{"tampered": tampered, "truncated": truncated, "trailing": trailing} {
+		rand, err := common.NewEntropyReader(nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := phase2.ContributeStream(bytes.NewReader(in), io.Discard, rand, nil); err == nil {
+			t.Errorf("contribution to a %s stream should fail", name)
+		}
+	}
+}
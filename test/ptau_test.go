@@ -0,0 +1,481 @@
+package test
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"math"
+	"math/big"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/consensys/gnark-crypto/ecc/bn254"
+	"github.com/consensys/gnark-crypto/ecc/bn254/fp"
+	"github.com/worldcoin/semaphore-mtb-setup/common"
+	"github.com/worldcoin/semaphore-mtb-setup/phase1"
+)
+
+func TestPtauRoundTrip(t *testing.T) {
+	var power byte = 8
+	if err := phase1.Initialize(power, "ptau0.ph1"); err != nil {
+		t.Fatal(err)
+	}
+	if err := phase1.Contribute("ptau0.ph1", "ptau1.ph1"); err != nil {
+		t.Fatal(err)
+	}
+
+	// Export to .ptau
+	if err := exportPtau("ptau1.ph1", "ptau1.ptau"); err != nil {
+		t.Fatal(err)
+	}
+
+	// Import back to .ph1
+	if err := importPtau("ptau1.ptau", "ptau2.ph1"); err != nil {
+		t.Fatal(err)
+	}
+
+	// Parameters must be identical, while contributions aren't imported
+	original, err := os.ReadFile("ptau1.ph1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	imported, err := os.ReadFile("ptau2.ph1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	N := int(math.Pow(2, float64(power)))
+	paramsSize := 32*(2*N-1) + 32*N + 32*N + 64*N + 64
+	var header phase1.Header
+	if _, err := header.ReadFrom(bytes.NewReader(imported)); err != nil {
+		t.Fatal(err)
+	}
+	headerSize := int(header.Size())
+	if len(imported) != headerSize+paramsSize+common.DigestSize {
+		t.Fatalf("imported file has %d bytes, expected %d", len(imported), headerSize+paramsSize+common.DigestSize)
+	}
+	if header.Power != power {
+		t.Errorf("imported power is %d, expected %d", header.Power, power)
+	}
+	if !bytes.Equal(original[headerSize:headerSize+paramsSize], imported[headerSize:headerSize+paramsSize]) {
+		t.Error("imported parameters differ from the exported ones")
+	}
+	if err := phase1.Verify("ptau2.ph1", "ptau2.ph1"); err != nil {
+		t.Error(err)
+	}
+}
+
+func exportPtau(inputPath, outputPath string) error {
+	inputFile, err := os.Open(inputPath)
+	if err != nil {
+		return err
+	}
+	defer inputFile.Close()
+	outputFile, err := os.Create(outputPath)
+	if err != nil {
+		return err
+	}
+	defer outputFile.Close()
+	writer := bufio.NewWriter(outputFile)
+	if err := phase1.ExportPtau(inputFile, writer); err != nil {
+		return err
+	}
+	return writer.Flush()
+}
+
+func importPtau(inputPath, outputPath string) error {
+	inputFile, err := os.Open(inputPath)
+	if err != nil {
+		return err
+	}
+	defer inputFile.Close()
+	outputFile, err := os.Create(outputPath)
+	if err != nil {
+		return err
+	}
+	defer outputFile.Close()
+	writer := bufio.NewWriter(outputFile)
+	if err := phase1.ImportPtau(inputFile, writer); err != nil {
+		return err
+	}
+	return writer.Flush()
+}
+
+func TestImportPtau(t *testing.T) {
+	var power byte = 4
+	N := int(math.Pow(2, float64(power)))
+	tau, alpha, beta := big.NewInt(7), big.NewInt(11), big.NewInt(13)
+	_, _, g1, g2 := bn254.Generators()
+
+	// Parameters of a known toxic waste
+	tauG1 := make([]bn254.G1Affine, 2*N-1)
+	alphaTauG1 := make([]bn254.G1Affine, N)
+	betaTauG1 := make([]bn254.G1Affine, N)
+	tauG2 := make([]bn254.G2Affine, N)
+	betaG2 := make([]bn254.G2Affine, 1)
+	betaG2[0].ScalarMultiplication(&g2, beta)
+	tauI := big.NewInt(1)
+	for i := range tauG1 {
+		tauG1[i].ScalarMultiplication(&g1, tauI)
+		if i < N {
+			alphaTauG1[i].ScalarMultiplication(&tauG1[i], alpha)
+			betaTauG1[i].ScalarMultiplication(&tauG1[i], beta)
+			tauG2[i].ScalarMultiplication(&g2, tauI)
+		}
+		tauI.Mul(tauI, tau)
+	}
+	if err := os.WriteFile("imp.ptau", ptauFile(power, tauG1, tauG2, alphaTauG1, betaTauG1, betaG2), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := importPtau("imp.ptau", "imp.ph1"); err != nil {
+		t.Fatal(err)
+	}
+	if err := phase1.Verify("imp.ph1", "imp.ph1"); err != nil {
+		t.Fatal(err)
+	}
+
+	// Every point must land at its place in the .ph1 layout
+	imported, err := os.Open("imp.ph1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer imported.Close()
+	var header phase1.Header
+	if _, err := header.ReadFrom(imported); err != nil {
+		t.Fatal(err)
+	}
+	if header.Power != power || header.Contributions != 0 {
+		t.Fatalf("imported header has power %d and %d contributions", header.Power, header.Contributions)
+	}
+	dec := bn254.NewDecoder(bufio.NewReader(imported))
+	for _, section := range []struct {
+		name   string
+		points []bn254.G1Affine
+	}{{"TauG1", tauG1}, {"AlphaTauG1", alphaTauG1}, {"BetaTauG1", betaTauG1}} {
+		var p bn254.G1Affine
+		for i := range section.points {
+			if err := dec.Decode(&p); err != nil {
+				t.Fatal(err)
+			}
+			if !p.Equal(&section.points[i]) {
+				t.Fatalf("%s[%d] differs from the .ptau file", section.name, i)
+			}
+		}
+	}
+	for _, section := range []struct {
+		name   string
+		points []bn254.G2Affine
+	}{{"TauG2", tauG2}, {"BetaG2", betaG2}} {
+		var p bn254.G2Affine
+		for i := range section.points {
+			if err := dec.Decode(&p); err != nil {
+				t.Fatal(err)
+			}
+			if !p.Equal(&section.points[i]) {
+				t.Fatalf("%s[%d] differs from the .ptau file", section.name, i)
+			}
+		}
+	}
+
+	// A G2 point on the twist but out of the subgroup must be rejected
+	tauG2[1] = g2OutOfSubGroup(t)
+	if err := os.WriteFile("imp_bad.ptau", ptauFile(power, tauG1, tauG2, alphaTauG1, betaTauG1, betaG2), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := importPtau("imp_bad.ptau", "imp_bad.ph1"); err == nil {
+		t.Error("import accepted a G2 point out of the subgroup")
+	}
+}
+
+// Serialize parameters with the snarkjs layout, without any contribution
+func ptauFile(power byte, tauG1, tauG2, alphaTauG1, betaTauG1, betaG2 any) []byte {
+	var buff bytes.Buffer
+	le32 := func(v uint32) {
+		binary.Write(&buff, binary.LittleEndian, v)
+	}
+	section := func(id uint32, content []byte) {
+		le32(id)
+		binary.Write(&buff, binary.LittleEndian, uint64(len(content)))
+		buff.Write(content)
+	}
+	elements := func(points any) []byte {
+		var raw bytes.Buffer
+		switch points := points.(type) {
+		case []bn254.G1Affine:
+			for _, p := range points {
+				binary.Write(&raw, binary.LittleEndian, p.X)
+				binary.Write(&raw, binary.LittleEndian, p.Y)
+			}
+		case []bn254.G2Affine:
+			for _, p := range points {
+				binary.Write(&raw, binary.LittleEndian, p.X.A0)
+				binary.Write(&raw, binary.LittleEndian, p.X.A1)
+				binary.Write(&raw, binary.LittleEndian, p.Y.A0)
+				binary.Write(&raw, binary.LittleEndian, p.Y.A1)
+			}
+		}
+		return raw.Bytes()
+	}
+
+	buff.WriteString("ptau")
+	le32(1)
+	le32(7)
+	var header bytes.Buffer
+	binary.Write(&header, binary.LittleEndian, uint32(fp.Bytes))
+	q := fp.Modulus().FillBytes(make([]byte, fp.Bytes))
+	for i := len(q) - 1; i >= 0; i-- {
+		header.WriteByte(q[i])
+	}
+	binary.Write(&header, binary.LittleEndian, [2]uint32{uint32(power), uint32(power)})
+	section(1, header.Bytes())
+	section(2, elements(tauG1))
+	section(3, elements(tauG2))
+	section(4, elements(alphaTauG1))
+	section(5, elements(betaTauG1))
+	section(6, elements(betaG2))
+	section(7, make([]byte, 4))
+	return buff.Bytes()
+}
+
+// Find a point of the twist that the cofactor keeps out of the subgroup
+func g2OutOfSubGroup(t *testing.T) bn254.G2Affine {
+	var p bn254.G2Affine
+	for x := uint64(1); x < 1000; x++ {
+		p.X.A0.SetUint64(x)
+		p.X.A1.SetOne()
+		rhs := p.X
+		rhs.Square(&rhs).Mul(&rhs, &p.X)
+		b := p.X
+		b.SetOne().MulBybTwistCurveCoeff(&b)
+		rhs.Add(&rhs, &b)
+		if rhs.Legendre() != 1 {
+			continue
+		}
+		p.Y.Sqrt(&rhs)
+		if p.IsOnCurve() && !p.IsInSubGroup() {
+			return p
+		}
+	}
+	t.Fatal("no point out of the subgroup found")
+	return p
+}
+
+func TestExportPtauLayout(t *testing.T) {
+	var power byte = 4
+	N := int(math.Pow(2, float64(power)))
+	if err := phase1.Initialize(power, "exp_0.ph1"); err != nil {
+		t.Fatal(err)
+	}
+	if err := phase1.Contribute("exp_0.ph1", "exp_1.ph1"); err != nil {
+		t.Fatal(err)
+	}
+	if err := exportPtau("exp_1.ph1", "exp_1.ptau"); err != nil {
+		t.Fatal(err)
+	}
+	raw, err := os.ReadFile("exp_1.ptau")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Layout written by snarkjs powersoftau new: magic, version, #sections,
+	// then every section as id, size and content
+	le32 := func(b []byte) uint32 { return binary.LittleEndian.Uint32(b) }
+	if string(raw[:4]) != "ptau" || le32(raw[4:]) != 1 || le32(raw[8:]) != 7 {
+		t.Fatalf("unexpected file header %x", raw[:12])
+	}
+	sizes := []int{4 + 32 + 8, (2*N - 1) * 64, N * 128, N * 64, N * 64, 128, 4 + ptauRecordParams + 4}
+	sections := make([][]byte, len(sizes))
+	pos := 12
+	for i, size := range sizes {
+		id := le32(raw[pos:])
+		length := int(binary.LittleEndian.Uint64(raw[pos+4:]))
+		if id != uint32(i+1) || length != size {
+			t.Fatalf("section %d at %d has id %d and %d bytes, expected %d", i+1, pos, id, length, size)
+		}
+		pos += 12
+		sections[i] = raw[pos : pos+length]
+		pos += length
+	}
+	if pos != len(raw) {
+		t.Fatalf("%d trailing bytes after the last section", len(raw)-pos)
+	}
+
+	// Header as n8, q in little-endian, power and ceremonyPower
+	q := fp.Modulus().FillBytes(make([]byte, 32))
+	for i := range q {
+		if sections[0][4+i] != q[31-i] {
+			t.Fatal("header doesn't hold the bn254 base field modulus")
+		}
+	}
+	if le32(sections[0]) != 32 || le32(sections[0][36:]) != uint32(power) || le32(sections[0][40:]) != uint32(power) {
+		t.Fatalf("unexpected header section %x", sections[0])
+	}
+
+	// Points are in Montgomery form, the first power of tau being the generator
+	_, _, g1, g2 := bn254.Generators()
+	var expected bytes.Buffer
+	binary.Write(&expected, binary.LittleEndian, g1.X)
+	binary.Write(&expected, binary.LittleEndian, g1.Y)
+	if !bytes.Equal(sections[1][:64], expected.Bytes()) {
+		t.Error("TauG1[0] isn't the G1 generator")
+	}
+	expected.Reset()
+	binary.Write(&expected, binary.LittleEndian, g2.X.A0)
+	binary.Write(&expected, binary.LittleEndian, g2.X.A1)
+	binary.Write(&expected, binary.LittleEndian, g2.Y.A0)
+	binary.Write(&expected, binary.LittleEndian, g2.Y.A1)
+	if !bytes.Equal(sections[2][:128], expected.Bytes()) {
+		t.Error("TauG2[0] isn't the G2 generator")
+	}
+
+	// The contribution is exported without parameters, its first power of tau being the one of the parameters
+	record := sections[6][4:]
+	if le32(sections[6]) != 1 || le32(record[ptauRecordType:]) != 0 || le32(record[ptauRecordParams:]) != 0 {
+		t.Fatalf("unexpected contributions section %x", sections[6][:4])
+	}
+	if !bytes.Equal(record[:64], sections[1][64:128]) || !bytes.Equal(record[64:192], sections[2][128:256]) {
+		t.Error("exported contribution doesn't match the parameters")
+	}
+
+	// The challenge following the contribution hashes the points of the file, while gnark's proofs
+	// of knowledge aren't derived from the snarkjs challenge
+	err = phase1.VerifyPtau(bytes.NewReader(raw))
+	if err == nil || !strings.Contains(err.Error(), "knowledge of Tau") {
+		t.Errorf("unexpected verification of the exported file: %v", err)
+	}
+	tampered := append([]byte(nil), raw...)
+	tauG1 := ptauSections(t, raw)[2]
+	copy(tampered[tauG1+2*64:tauG1+3*64], raw[tauG1+3*64:tauG1+4*64])
+	copy(tampered[tauG1+3*64:tauG1+4*64], raw[tauG1+2*64:tauG1+3*64])
+	if err := phase1.VerifyPtau(bytes.NewReader(tampered)); err == nil || !strings.Contains(err.Error(), "challenge") {
+		t.Errorf("verification of swapped powers should fail on the challenge: %v", err)
+	}
+	tampered = append([]byte(nil), raw...)
+	binary.LittleEndian.PutUint32(tampered[len(raw)-4:], math.MaxUint32)
+	if err := phase1.VerifyPtau(bytes.NewReader(tampered)); err == nil || !strings.Contains(err.Error(), "exceed") {
+		t.Errorf("verification of oversized parameters should fail: %v", err)
+	}
+}
+
+// The fixtures are snarkjs ceremonies generated by testdata/ptau/generate.sh
+func readPtauFixture(t *testing.T, name string) []byte {
+	raw, err := os.ReadFile(filepath.Join("testdata", "ptau", name))
+	if os.IsNotExist(err) {
+		t.Fatalf("missing snarkjs fixture %s, run testdata/ptau/generate.sh", name)
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	return raw
+}
+
+func TestVerifyPtau(t *testing.T) {
+	raw := readPtauFixture(t, "pot4_0002.ptau")
+	if err := phase1.VerifyPtau(bytes.NewReader(raw)); err != nil {
+		t.Fatal(err)
+	}
+	sections := ptauSections(t, raw)
+	records := ptauRecords(t, raw, sections)
+	last := records[len(records)-1]
+
+	// Swapping two powers keeps the points of the last contribution in place
+	tampered := append([]byte(nil), raw...)
+	tauG1 := sections[2]
+	copy(tampered[tauG1+2*64:tauG1+3*64], raw[tauG1+3*64:tauG1+4*64])
+	copy(tampered[tauG1+3*64:tauG1+4*64], raw[tauG1+2*64:tauG1+3*64])
+	if err := phase1.VerifyPtau(bytes.NewReader(tampered)); err == nil {
+		t.Error("verification of swapped powers should fail")
+	}
+
+	// Dropping the last contribution leaves parameters no contribution accounts for
+	tampered = append([]byte(nil), raw...)
+	binary.LittleEndian.PutUint32(tampered[sections[7]:], 1)
+	if err := phase1.VerifyPtau(bytes.NewReader(tampered)); err == nil {
+		t.Error("verification without the last contribution should fail")
+	}
+
+	// The challenge following the last contribution hashes its response and the parameters
+	tampered = append([]byte(nil), raw...)
+	tampered[last+ptauRecordNextChallenge] ^= 1
+	if err := phase1.VerifyPtau(bytes.NewReader(tampered)); err == nil {
+		t.Error("verification of a replaced challenge should fail")
+	}
+	tampered = append([]byte(nil), raw...)
+	tampered[last+ptauRecordPartialHash+128] ^= 1
+	if err := phase1.VerifyPtau(bytes.NewReader(tampered)); err == nil {
+		t.Error("verification of a replaced response should fail")
+	}
+
+	// Parameters can't exceed the section
+	tampered = append([]byte(nil), raw...)
+	binary.LittleEndian.PutUint32(tampered[records[0]+ptauRecordParams:], math.MaxUint32)
+	if err := phase1.VerifyPtau(bytes.NewReader(tampered)); err == nil {
+		t.Error("verification of oversized parameters should fail")
+	}
+}
+
+func TestVerifyPtauBeacon(t *testing.T) {
+	raw := readPtauFixture(t, "pot4_beacon.ptau")
+	if err := phase1.VerifyPtau(bytes.NewReader(raw)); err != nil {
+		t.Fatal(err)
+	}
+
+	// Keys of the beacon contribution must be derived from the recorded beacon hash
+	sections := ptauSections(t, raw)
+	records := ptauRecords(t, raw, sections)
+	last := records[len(records)-1]
+	if binary.LittleEndian.Uint32(raw[last+ptauRecordType:]) != 1 {
+		t.Fatal("last contribution isn't a beacon")
+	}
+	tampered := append([]byte(nil), raw...)
+	params := last + ptauRecordParams + 4
+	for params < len(raw) && tampered[params] != 3 {
+		params += 2 + int(tampered[params+1])
+	}
+	tampered[params+2] ^= 1
+	if err := phase1.VerifyPtau(bytes.NewReader(tampered)); err == nil {
+		t.Error("verification of a beacon with another beacon hash should fail")
+	}
+}
+
+// Offsets in a snarkjs contribution record
+const (
+	ptauRecordPartialHash   = 9*64 + 5*128
+	ptauRecordNextChallenge = ptauRecordPartialHash + 216
+	ptauRecordType          = ptauRecordNextChallenge + 64
+	ptauRecordParams        = ptauRecordType + 4
+)
+
+// Index the contribution records of a .ptau file
+func ptauRecords(t *testing.T, raw []byte, sections map[uint32]int) []int {
+	pos := sections[7]
+	n := int(binary.LittleEndian.Uint32(raw[pos:]))
+	pos += 4
+	records := make([]int, n)
+	for i := range records {
+		if pos+ptauRecordParams+4 > len(raw) {
+			t.Fatalf("contribution %d is truncated", i+1)
+		}
+		records[i] = pos
+		pos += ptauRecordParams + 4 + int(binary.LittleEndian.Uint32(raw[pos+ptauRecordParams:]))
+	}
+	return records
+}
+
+// Index the content of every section of a .ptau file by id
+func ptauSections(t *testing.T, raw []byte) map[uint32]int {
+	sections := make(map[uint32]int)
+	n := int(binary.LittleEndian.Uint32(raw[8:]))
+	pos := 12
+	for i := 0; i < n; i++ {
+		if pos+12 > len(raw) {
+			t.Fatalf("section %d is truncated", i+1)
+		}
+		id := binary.LittleEndian.Uint32(raw[pos:])
+		size := int(binary.LittleEndian.Uint64(raw[pos+4:]))
+		sections[id] = pos + 12
+		pos += 12 + size
+	}
+	return sections
+}
@@ -0,0 +1,13 @@
+#!/bin/sh
+# Generates the snarkjs fixtures of TestVerifyPtau and TestVerifyPtauBeacon:
+# pot4_0002.ptau, a power 4 ceremony with two contributions, and pot4_beacon.ptau,
+# the same ceremony closed with a beacon. Requires snarkjs (npm install -g snarkjs).
+set -e
+cd "$(dirname "$0")"
+snarkjs powersoftau new bn128 4 pot4_0000.ptau
+snarkjs powersoftau contribute pot4_0000.ptau pot4_0001.ptau --name="First contribution" -e="first contribution entropy"
+snarkjs powersoftau contribute pot4_0001.ptau pot4_0002.ptau --name="Second contribution" -e="second contribution entropy"
+snarkjs powersoftau beacon pot4_0002.ptau pot4_beacon.ptau 0102030405060708090a0b0c0d0e0f101112131415161718191a1b1c1d1e1f 10 --name="Final beacon"
+snarkjs powersoftau verify pot4_0002.ptau
+snarkjs powersoftau verify pot4_beacon.ptau
+rm pot4_0000.ptau pot4_0001.ptau